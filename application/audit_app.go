@@ -0,0 +1,25 @@
+package application
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+)
+
+type auditApp struct {
+	ar repository.AuditRepository
+}
+
+var _ AuditAppInterface = &auditApp{}
+
+type AuditAppInterface interface {
+	SaveEvent(*entity.AuditEvent) error
+	GetEventsByUser(uint64) ([]entity.AuditEvent, error)
+}
+
+func (a *auditApp) SaveEvent(event *entity.AuditEvent) error {
+	return a.ar.SaveEvent(event)
+}
+
+func (a *auditApp) GetEventsByUser(userId uint64) ([]entity.AuditEvent, error) {
+	return a.ar.GetEventsByUser(userId)
+}
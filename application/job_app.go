@@ -0,0 +1,46 @@
+package application
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+	"time"
+)
+
+type jobApp struct {
+	jr repository.JobRepository
+}
+
+var _ JobAppInterface = &jobApp{}
+
+type JobAppInterface interface {
+	SaveJob(*entity.Job) (*entity.Job, map[string]string)
+	GetJob(uint64) (*entity.Job, error)
+	ClaimJob(uint64) (bool, error)
+	FinishJob(uint64, error) error
+	GetPendingJobs() ([]entity.Job, error)
+	RequeueStaleJobs(time.Time) error
+}
+
+func (j *jobApp) SaveJob(job *entity.Job) (*entity.Job, map[string]string) {
+	return j.jr.SaveJob(job)
+}
+
+func (j *jobApp) GetJob(jobId uint64) (*entity.Job, error) {
+	return j.jr.GetJob(jobId)
+}
+
+func (j *jobApp) ClaimJob(jobId uint64) (bool, error) {
+	return j.jr.ClaimJob(jobId)
+}
+
+func (j *jobApp) FinishJob(jobId uint64, jobErr error) error {
+	return j.jr.FinishJob(jobId, jobErr)
+}
+
+func (j *jobApp) GetPendingJobs() ([]entity.Job, error) {
+	return j.jr.GetPendingJobs()
+}
+
+func (j *jobApp) RequeueStaleJobs(startedBefore time.Time) error {
+	return j.jr.RequeueStaleJobs(startedBefore)
+}
@@ -0,0 +1,35 @@
+package application
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+)
+
+type objectRefApp struct {
+	or repository.ObjectRefRepository
+}
+
+var _ ObjectRefAppInterface = &objectRefApp{}
+
+type ObjectRefAppInterface interface {
+	RetainObject(string) error
+	ReleaseObject(string, func() error) (bool, error)
+	GetObjectRefs() ([]entity.ObjectRef, error)
+	DeleteObjectRef(string) error
+}
+
+func (o *objectRefApp) RetainObject(key string) error {
+	return o.or.RetainObject(key)
+}
+
+func (o *objectRefApp) ReleaseObject(key string, remove func() error) (bool, error) {
+	return o.or.ReleaseObject(key, remove)
+}
+
+func (o *objectRefApp) GetObjectRefs() ([]entity.ObjectRef, error) {
+	return o.or.GetObjectRefs()
+}
+
+func (o *objectRefApp) DeleteObjectRef(key string) error {
+	return o.or.DeleteObjectRef(key)
+}
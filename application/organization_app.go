@@ -0,0 +1,75 @@
+package application
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+)
+
+type organizationApp struct {
+	or repository.OrganizationRepository
+}
+
+var _ OrganizationAppInterface = &organizationApp{}
+
+type OrganizationAppInterface interface {
+	SaveOrganization(*entity.Organization, uint64) (*entity.Organization, map[string]string)
+	GetOrganization(uint64) (*entity.Organization, error)
+	GetOrganizationsByUser(uint64) ([]entity.Organization, error)
+	GetMembership(uint64, uint64) (*entity.Membership, error)
+	GetMemberships(uint64) ([]entity.Membership, error)
+	GetMembers(uint64) ([]entity.Membership, error)
+	UpdateMemberRole(uint64, uint64, string) map[string]string
+	RemoveMember(uint64, uint64) map[string]string
+	RemoveUserMemberships(uint64) map[string]string
+	SaveInvitation(*entity.Invitation) (*entity.Invitation, map[string]string)
+	GetInvitationByToken(string) (*entity.Invitation, error)
+	AcceptInvitation(string, uint64) (*entity.Membership, map[string]string)
+}
+
+func (o *organizationApp) SaveOrganization(org *entity.Organization, ownerId uint64) (*entity.Organization, map[string]string) {
+	return o.or.SaveOrganization(org, ownerId)
+}
+
+func (o *organizationApp) GetOrganization(orgId uint64) (*entity.Organization, error) {
+	return o.or.GetOrganization(orgId)
+}
+
+func (o *organizationApp) GetOrganizationsByUser(userId uint64) ([]entity.Organization, error) {
+	return o.or.GetOrganizationsByUser(userId)
+}
+
+func (o *organizationApp) GetMembership(orgId, userId uint64) (*entity.Membership, error) {
+	return o.or.GetMembership(orgId, userId)
+}
+
+func (o *organizationApp) GetMemberships(userId uint64) ([]entity.Membership, error) {
+	return o.or.GetMemberships(userId)
+}
+
+func (o *organizationApp) GetMembers(orgId uint64) ([]entity.Membership, error) {
+	return o.or.GetMembers(orgId)
+}
+
+func (o *organizationApp) UpdateMemberRole(orgId, userId uint64, role string) map[string]string {
+	return o.or.UpdateMemberRole(orgId, userId, role)
+}
+
+func (o *organizationApp) RemoveMember(orgId, userId uint64) map[string]string {
+	return o.or.RemoveMember(orgId, userId)
+}
+
+func (o *organizationApp) RemoveUserMemberships(userId uint64) map[string]string {
+	return o.or.RemoveUserMemberships(userId)
+}
+
+func (o *organizationApp) SaveInvitation(invitation *entity.Invitation) (*entity.Invitation, map[string]string) {
+	return o.or.SaveInvitation(invitation)
+}
+
+func (o *organizationApp) GetInvitationByToken(token string) (*entity.Invitation, error) {
+	return o.or.GetInvitationByToken(token)
+}
+
+func (o *organizationApp) AcceptInvitation(token string, userId uint64) (*entity.Membership, map[string]string) {
+	return o.or.AcceptInvitation(token, userId)
+}
@@ -13,28 +13,48 @@ var _ ProductAppInterface = &productApp{}
 
 type ProductAppInterface interface {
 	SaveProduct(*entity.Product) (*entity.Product, map[string]string)
-	GetAllProduct() ([]entity.Product, error)
-	GetProduct(uint64) (*entity.Product, error)
+	GetAllProduct(uint64) ([]entity.Product, error)
+	GetProduct(uint64, uint64) (*entity.Product, error)
 	UpdateProduct(*entity.Product) (*entity.Product, map[string]string)
-	DeleteProduct(uint64) error
+	DeleteProduct(uint64, uint64) error
+	GetProductsByUser(uint64) ([]entity.Product, error)
+	ReassignProducts(uint64, uint64, uint64) error
+	SetImageVariants(uint64, string, entity.ImageVariants) map[string]string
+	GetProductImages() ([]entity.Product, error)
 }
 
 func (f *productApp) SaveProduct(product *entity.Product) (*entity.Product, map[string]string) {
 	return f.fr.SaveProduct(product)
 }
 
-func (f *productApp) GetAllProduct() ([]entity.Product, error) {
-	return f.fr.GetAllProduct()
+func (f *productApp) GetAllProduct(orgId uint64) ([]entity.Product, error) {
+	return f.fr.GetAllProduct(orgId)
 }
 
-func (f *productApp) GetProduct(productId uint64) (*entity.Product, error) {
-	return f.fr.GetProduct(productId)
+func (f *productApp) GetProduct(orgId, productId uint64) (*entity.Product, error) {
+	return f.fr.GetProduct(orgId, productId)
 }
 
 func (f *productApp) UpdateProduct(product *entity.Product) (*entity.Product, map[string]string) {
 	return f.fr.UpdateProduct(product)
 }
 
-func (f *productApp) DeleteProduct(productId uint64) error {
-	return f.fr.DeleteProduct(productId)
-}
\ No newline at end of file
+func (f *productApp) DeleteProduct(orgId, productId uint64) error {
+	return f.fr.DeleteProduct(orgId, productId)
+}
+
+func (f *productApp) GetProductsByUser(userId uint64) ([]entity.Product, error) {
+	return f.fr.GetProductsByUser(userId)
+}
+
+func (f *productApp) ReassignProducts(orgId, fromUserId, toUserId uint64) error {
+	return f.fr.ReassignProducts(orgId, fromUserId, toUserId)
+}
+
+func (f *productApp) SetImageVariants(productId uint64, image string, variants entity.ImageVariants) map[string]string {
+	return f.fr.SetImageVariants(productId, image, variants)
+}
+
+func (f *productApp) GetProductImages() ([]entity.Product, error) {
+	return f.fr.GetProductImages()
+}
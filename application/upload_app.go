@@ -0,0 +1,45 @@
+package application
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+)
+
+type uploadApp struct {
+	ur repository.UploadRepository
+}
+
+var _ UploadAppInterface = &uploadApp{}
+
+type UploadAppInterface interface {
+	SaveUpload(*entity.Upload) (*entity.Upload, map[string]string)
+	GetUpload(uint64) (*entity.Upload, error)
+	GetUploadByKey(string) (*entity.Upload, error)
+	ConfirmUpload(uint64) map[string]string
+	RejectUpload(uint64) map[string]string
+	GetPendingUploads() ([]entity.Upload, error)
+}
+
+func (u *uploadApp) SaveUpload(upload *entity.Upload) (*entity.Upload, map[string]string) {
+	return u.ur.SaveUpload(upload)
+}
+
+func (u *uploadApp) GetUpload(uploadId uint64) (*entity.Upload, error) {
+	return u.ur.GetUpload(uploadId)
+}
+
+func (u *uploadApp) GetUploadByKey(key string) (*entity.Upload, error) {
+	return u.ur.GetUploadByKey(key)
+}
+
+func (u *uploadApp) ConfirmUpload(uploadId uint64) map[string]string {
+	return u.ur.ConfirmUpload(uploadId)
+}
+
+func (u *uploadApp) RejectUpload(uploadId uint64) map[string]string {
+	return u.ur.RejectUpload(uploadId)
+}
+
+func (u *uploadApp) GetPendingUploads() ([]entity.Upload, error) {
+	return u.ur.GetPendingUploads()
+}
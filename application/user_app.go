@@ -16,7 +16,12 @@ type UserAppInterface interface {
 	SaveUser(*entity.User) (*entity.User, map[string]string)
 	GetUsers() ([]entity.User, error)
 	GetUser(uint64) (*entity.User, error)
+	GetUserByEmail(string) (*entity.User, error)
 	GetUserByEmailAndPassword(*entity.User) (*entity.User, map[string]string)
+	UpdatePassword(uint64, string) map[string]string
+	UpdateAvatar(uint64, string) map[string]string
+	AnonymizeUser(uint64) map[string]string
+	GetAvatars() ([]string, error)
 }
 
 func (u *userApp) SaveUser(user *entity.User) (*entity.User, map[string]string) {
@@ -31,6 +36,26 @@ func (u *userApp) GetUsers() ([]entity.User, error) {
 	return u.us.GetUsers()
 }
 
+func (u *userApp) GetUserByEmail(email string) (*entity.User, error) {
+	return u.us.GetUserByEmail(email)
+}
+
 func (u *userApp) GetUserByEmailAndPassword(user *entity.User) (*entity.User, map[string]string) {
 	return u.us.GetUserByEmailAndPassword(user)
-}
\ No newline at end of file
+}
+
+func (u *userApp) UpdatePassword(userId uint64, password string) map[string]string {
+	return u.us.UpdatePassword(userId, password)
+}
+
+func (u *userApp) UpdateAvatar(userId uint64, key string) map[string]string {
+	return u.us.UpdateAvatar(userId, key)
+}
+
+func (u *userApp) AnonymizeUser(userId uint64) map[string]string {
+	return u.us.AnonymizeUser(userId)
+}
+
+func (u *userApp) GetAvatars() ([]string, error) {
+	return u.us.GetAvatars()
+}
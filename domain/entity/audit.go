@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+//AuditEvent records an action together with the person who really performed it.
+//ActorID differs from UserID when an admin acts on behalf of a user.
+type AuditEvent struct {
+	ID        uint64    `gorm:"primary_key;auto_increment" json:"id"`
+	ActorID   uint64    `gorm:"not null;index" json:"actor_id"`
+	UserID    uint64    `gorm:"not null;index" json:"user_id"`
+	Action    string    `gorm:"size:255;not null;" json:"action"`
+	Status    int       `json:"status"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
@@ -0,0 +1,79 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+//ImageURL turns the key of a stored image into a URL. It is replaced at startup to match where the objects are served from.
+var ImageURL = func(key string) string { return key }
+
+//ImageVariant is a resized copy of an image
+type ImageVariant struct {
+	Width  int    `json:"width"`
+	Format string `json:"format"`
+	Key    string `json:"key"`
+}
+
+//VariantKey is the object key of one variant of the image stored under key, next to the original
+func VariantKey(key string, width int, ext string) string {
+	return fmt.Sprintf("%s/%d.%s", strings.TrimSuffix(key, path.Ext(key)), width, ext)
+}
+
+//ImageVariants are kept as a JSON column
+type ImageVariants []ImageVariant
+
+func (v ImageVariants) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (v *ImageVariants) Scan(src interface{}) error {
+	var data []byte
+	switch s := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		data = []byte(s)
+	case []byte:
+		data = s
+	default:
+		return errors.New("image variants must be stored as text")
+	}
+	if len(data) == 0 {
+		*v = nil
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+//Srcset builds a srcset attribute per format, narrowest variant first
+func (v ImageVariants) Srcset() map[string]string {
+	if len(v) == 0 {
+		return nil
+	}
+	sorted := make(ImageVariants, len(v))
+	copy(sorted, v)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Width < sorted[j].Width })
+	candidates := map[string][]string{}
+	for _, variant := range sorted {
+		candidates[variant.Format] = append(candidates[variant.Format], fmt.Sprintf("%s %dw", ImageURL(variant.Key), variant.Width))
+	}
+	srcset := make(map[string]string, len(candidates))
+	for format, list := range candidates {
+		srcset[format] = strings.Join(list, ", ")
+	}
+	return srcset
+}
@@ -0,0 +1,35 @@
+package entity
+
+import "time"
+
+//Job is a unit of background work, kept in the database so its progress can be followed and it survives restarts
+type Job struct {
+	ID   uint64 `gorm:"primary_key;auto_increment" json:"id"`
+	Kind string `gorm:"size:50;not null;index" json:"kind"`
+	//SubjectID is what the job works on, the user for an erasure
+	SubjectID   uint64     `gorm:"not null;index" json:"subject_id"`
+	//Target completes SubjectID when needed, such as the image a product had when the job was enqueued
+	Target      string     `gorm:"size:255;" json:"target,omitempty"`
+	RequestedBy uint64     `gorm:"not null;" json:"requested_by"`
+	Status      string     `gorm:"size:20;not null;index" json:"status"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	Error       string     `gorm:"size:255;" json:"error,omitempty"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+//Kinds of jobs
+const (
+	JobUserErasure   = "user_erasure"
+	JobImageVariants = "image_variants"
+	JobStorageGC     = "storage_gc"
+	JobTusExpire     = "tus_expire"
+)
@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+//ObjectRef counts the products and users referencing a stored object. Images are stored under the hash of their content,
+//so one object can be shared, and it is only deleted when its last reference goes away.
+type ObjectRef struct {
+	Key       string    `gorm:"primary_key;size:255" json:"key"`
+	Refs      int       `gorm:"not null" json:"refs"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+//ImagePrefix starts the key of every product image uploaded through the API, the rest of the key is the SHA-256 of its content
+const ImagePrefix = "images/"
+
+//IncomingPrefix holds files while they stream in, their content key is only known at the end. Whatever is left there is collected as an orphan.
+const IncomingPrefix = "incoming/"
+
+//QuarantinePrefix holds files that were not scanned yet. Direct uploads land there, once they passed their image is stored again under ImagePrefix.
+const QuarantinePrefix = "quarantine/"
@@ -0,0 +1,74 @@
+package entity
+
+import (
+	"html"
+	"strings"
+	"time"
+)
+
+//Organization is the customer: products belong to an organization and users work in it through a Membership
+type Organization struct {
+	ID        uint64     `gorm:"primary_key;auto_increment" json:"id"`
+	Name      string     `gorm:"size:100;not null;" json:"name"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+//Roles of a member inside an organization, unrelated to the user's global Role
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleEditor = "editor"
+	OrgRoleViewer = "viewer"
+)
+
+//ValidOrgRole reports whether role is one of the organization roles
+func ValidOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleEditor || role == OrgRoleViewer
+}
+
+//Membership gives a user a role in an organization
+type Membership struct {
+	ID             uint64    `gorm:"primary_key;auto_increment" json:"id"`
+	OrganizationID uint64    `gorm:"not null;unique_index:idx_membership_org_user" json:"organization_id"`
+	UserID         uint64    `gorm:"not null;unique_index:idx_membership_org_user;index" json:"user_id"`
+	Role           string    `gorm:"size:20;not null;" json:"role"`
+	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+//CanEditProducts is true for the roles allowed to create, change and delete the organization's products
+func (m *Membership) CanEditProducts() bool {
+	return m.Role == OrgRoleOwner || m.Role == OrgRoleEditor
+}
+
+//Invitation asks the owner of Email to join an organization. It is accepted with its Token by a user logged in with that email.
+type Invitation struct {
+	ID             uint64     `gorm:"primary_key;auto_increment" json:"id"`
+	OrganizationID uint64     `gorm:"not null;index" json:"organization_id"`
+	Email          string     `gorm:"size:100;not null;" json:"email"`
+	Role           string     `gorm:"size:20;not null;" json:"role"`
+	InvitedBy      uint64     `gorm:"not null;" json:"invited_by"`
+	Token          string     `gorm:"size:255;not null;unique" json:"-"`
+	ExpiresAt      time.Time  `gorm:"not null;" json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt      time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+//Pending is true while the invitation can still be accepted
+func (i *Invitation) Pending() bool {
+	return i.AcceptedAt == nil && time.Now().Before(i.ExpiresAt)
+}
+
+func (o *Organization) Prepare() {
+	o.Name = html.EscapeString(strings.TrimSpace(o.Name))
+	o.CreatedAt = time.Now()
+	o.UpdatedAt = time.Now()
+}
+
+func (o *Organization) Validate() map[string]string {
+	var errorMessages = make(map[string]string)
+	if o.Name == "" {
+		errorMessages["name_required"] = "name is required"
+	}
+	return errorMessages
+}
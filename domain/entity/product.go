@@ -1,20 +1,46 @@
 package entity
 
 import (
+	"encoding/json"
 	"html"
 	"strings"
 	"time"
 )
 
+//Product belongs to an organization. UserID is the member who created it.
 type Product struct {
-	ID				uint64     `gorm:"primary_key;auto_increment" json:"id"`
-	UserID			uint64     `gorm:"size:100;not null;" json:"user_id"`
-	Title			string     `gorm:"size:100;not null;unique" json:"title"`
-	Description		string     `gorm:"text;not null;" json:"description"`
-	ProductImage	string     `gorm:"size:255;null;" json:"product_image"`
-	CreatedAt	time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt	time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
-	DeletedAt	*time.Time `json:"deleted_at"`
+	ID             uint64 `gorm:"primary_key;auto_increment" json:"id"`
+	OrganizationID uint64 `gorm:"not null;default:0;unique_index:idx_products_organization_title" json:"organization_id"`
+	UserID         uint64 `gorm:"size:100;not null;" json:"user_id"`
+	Title          string `gorm:"size:100;not null;unique_index:idx_products_organization_title" json:"title"`
+	Description    string `gorm:"text;not null;" json:"description"`
+	//ProductImage is the key of the stored image, its URL is only resolved when the product is serialised
+	ProductImage string `gorm:"size:255;null;" json:"product_image"`
+	//ImageVariants are the resized copies of ProductImage, made in the background after an upload
+	ImageVariants ImageVariants `gorm:"type:text" json:"image_variants,omitempty"`
+	CreatedAt     time.Time     `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt     time.Time     `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	DeletedAt     *time.Time    `json:"deleted_at"`
+}
+
+//productJSON has the fields of Product without its methods, MarshalJSON would call itself otherwise
+type productJSON Product
+
+//MarshalJSON resolves the URLs of the image and of its variants with ImageURL: the row only holds keys,
+//so the URLs follow wherever the objects are served from, signed links included. product_image stays the URL the clients
+//always got, the key is sent beside it.
+func (f Product) MarshalJSON() ([]byte, error) {
+	public := struct {
+		productJSON
+		ProductImage    string `json:"product_image"`
+		ProductImageKey string `json:"product_image_key,omitempty"`
+		//Srcset maps each variant format to a srcset attribute
+		Srcset map[string]string `json:"srcset,omitempty"`
+	}{productJSON: productJSON(f), ProductImageKey: f.ProductImage, Srcset: f.ImageVariants.Srcset()}
+	if f.ProductImage != "" {
+		public.ProductImage = ImageURL(f.ProductImage)
+	}
+	return json.Marshal(public)
 }
 
 func (f *Product) BeforeSave() {
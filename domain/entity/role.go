@@ -0,0 +1,36 @@
+package entity
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+const (
+	ScopeProductsWrite = "products:write"
+	ScopeUsersAdmin    = "users:admin"
+)
+
+//roleScopes lists what each role is allowed to do, tokens carry these scopes
+var roleScopes = map[string][]string{
+	RoleUser:  {ScopeProductsWrite},
+	RoleAdmin: {ScopeProductsWrite, ScopeUsersAdmin},
+}
+
+//Scopes returns the scopes granted by the user's role. Users saved before roles existed are plain users.
+func (u *User) Scopes() []string {
+	scopes, ok := roleScopes[u.Role]
+	if !ok {
+		scopes = roleScopes[RoleUser]
+	}
+	return append([]string(nil), scopes...)
+}
+
+//HasScope tells whether the role of the user grants scope now, whatever the tokens issued before say
+func (u *User) HasScope(scope string) bool {
+	for _, granted := range u.Scopes() {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package entity
+
+import "time"
+
+//Upload is a file a client was allowed to put straight into the storage. It is attached to a product once confirmed.
+type Upload struct {
+	ID             uint64    `gorm:"primary_key;auto_increment" json:"id"`
+	Key            string    `gorm:"size:255;not null;unique" json:"key"`
+	UserID         uint64    `gorm:"not null;index" json:"user_id"`
+	OrganizationID uint64    `gorm:"not null" json:"organization_id"`
+	ContentType    string    `gorm:"size:100;not null" json:"content_type"`
+	MaxBytes       int64     `gorm:"not null" json:"max_bytes"`
+	Status         string    `gorm:"size:20;not null;index" json:"status"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+const (
+	UploadPending   = "pending"
+	UploadConfirmed = "confirmed"
+	//UploadInfected is an upload the scanners refused, its file was deleted
+	UploadInfected = "infected"
+)
+
+//UploadPrefix started the key of the direct uploads confirmed before they were encoded again, products may still point there.
+//Direct uploads are uploaded under QuarantinePrefix.
+const UploadPrefix = "uploads/"
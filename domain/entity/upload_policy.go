@@ -0,0 +1,87 @@
+package entity
+
+import (
+	"path"
+	"strings"
+)
+
+//UploadPolicy is what an upload accepts: how large the file may be, of which types, how many of them a form field takes,
+//and the dimensions of the pictures. The routes declare theirs for each of their fields.
+type UploadPolicy struct {
+	MaxBytes int64
+	//Types are the content types allowed, as sniffed from the bytes, each with the extensions the name of the file may end with.
+	//The first extension is the one stored files get.
+	Types map[string][]string
+	//MaxCount is how many files one form field takes
+	MaxCount int
+	Image    ImageLimits
+}
+
+//ImageLimits bound the dimensions of the pictures a policy accepts
+type ImageLimits struct {
+	//MaxPixels is checked against the header before anything is decoded, a small file cannot expand into gigabytes of pixels
+	MaxPixels int64
+	MinWidth  int
+	MinHeight int
+	//MaxWidth and MaxHeight are not checked when they are 0
+	MaxWidth  int
+	MaxHeight int
+}
+
+//DefaultImageLimits allow 16 megapixels, what most phones take, and nothing smaller than the avatar crops
+var DefaultImageLimits = ImageLimits{
+	MaxPixels: 16000000,
+	MinWidth:  64,
+	MinHeight: 64,
+}
+
+//ImageTypes are the pictures the uploads decode
+var ImageTypes = map[string][]string{
+	"image/jpeg": {".jpg", ".jpeg"},
+	"image/png":  {".png"},
+	"image/gif":  {".gif"},
+	"image/webp": {".webp"},
+}
+
+//ProductImagePolicy is the policy of the product images, whether they come in a form, as a direct upload or through tus
+var ProductImagePolicy = UploadPolicy{
+	MaxBytes: 8192000,
+	Types:    ImageTypes,
+	MaxCount: 1,
+	Image:    DefaultImageLimits,
+}
+
+//AvatarPolicy is the policy of the pictures of the users, they are only ever shown small
+var AvatarPolicy = UploadPolicy{
+	MaxBytes: 512000,
+	Types:    ImageTypes,
+	MaxCount: 1,
+	Image:    DefaultImageLimits,
+}
+
+//Allows tells whether a file of contentType named filename may be uploaded. The content type is sniffed from the bytes,
+//the name is what the client sent: its extension has to match the type, a name without any is judged on its content alone.
+func (p *UploadPolicy) Allows(contentType, filename string) bool {
+	extensions, ok := p.Types[contentType]
+	if !ok {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(filename))
+	if ext == "" {
+		return true
+	}
+	for _, allowed := range extensions {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+//Extension is what the files of contentType are stored with, empty when the type is not allowed
+func (p *UploadPolicy) Extension(contentType string) string {
+	if extensions := p.Types[contentType]; len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
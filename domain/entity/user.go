@@ -1,9 +1,10 @@
 package entity
 
 import (
-	"DDD/infrastructure/security"
+	"fmt"
 	"github.com/badoux/checkmail"
 	"html"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -13,7 +14,9 @@ type User struct {
 	FirstName string     `gorm:"size:100;not null;" json:"first_name"`
 	LastName  string     `gorm:"size:100;not null;" json:"last_name"`
 	Email     string     `gorm:"size:100;not null;unique" json:"email"`
-	Password  string     `gorm:"size:100;not null;" json:"password"`
+	Password  string     `gorm:"size:255;not null;" json:"password"`
+	Role      string     `gorm:"size:20;not null;default:'user'" json:"role"`
+	Avatar    string     `gorm:"size:255;" json:"avatar"`
 	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 	DeletedAt *time.Time `json:"deleted_at,omitempty"`
@@ -23,15 +26,22 @@ type PublicUser struct {
 	ID        uint64 `gorm:"primary_key;auto_increment" json:"id"`
 	FirstName string `gorm:"size:100;not null;" json:"first_name"`
 	LastName  string `gorm:"size:100;not null;" json:"last_name"`
+	//Avatar maps each size in pixels to the URL of the picture
+	Avatar map[string]string `json:"avatar,omitempty"`
 }
 
-func (u *User) BeforeSave() error {
-	hashPassword, err := security.Hash(u.Password)
-	if err != nil {
-		return err
-	}
-	u.Password = string(hashPassword)
-	return nil
+//AvatarSizes are the square sizes, in pixels, every avatar is stored in
+var AvatarSizes = []int{64, 128, 256}
+
+//AvatarPrefix starts the key of every avatar
+const AvatarPrefix = "avatars/"
+
+//AvatarURL turns an object key into a URL. It is replaced at startup to match where the objects are served from.
+var AvatarURL = func(key string) string { return key }
+
+//AvatarKey is the object key of one size of the avatar stored under key
+func AvatarKey(key string, size int) string {
+	return fmt.Sprintf("%s/%d.jpg", key, size)
 }
 
 type Users []User
@@ -45,11 +55,18 @@ func (users Users) PublicUsers() []interface{} {
 }
 
 func (u *User) PublicUser() interface{} {
-	return &PublicUser{
+	public := &PublicUser{
 		ID:        u.ID,
 		FirstName: u.FirstName,
 		LastName:  u.LastName,
 	}
+	if u.Avatar != "" {
+		public.Avatar = make(map[string]string, len(AvatarSizes))
+		for _, size := range AvatarSizes {
+			public.Avatar[strconv.Itoa(size)] = AvatarURL(AvatarKey(u.Avatar, size))
+		}
+	}
+	return public
 }
 
 func (u *User) Prepare() {
@@ -107,9 +124,6 @@ func (u *User) Validate(action string) map[string]string {
 		if u.Password == "" {
 			errorMessages["password_required"] = "password is required"
 		}
-		if u.Password != "" && len(u.Password) < 6 {
-			errorMessages["invalid_password"] = "password should be at least 6 characters"
-		}
 		if u.Email == "" {
 			errorMessages["email_required"] = "email is required"
 		}
@@ -0,0 +1,8 @@
+package repository
+
+import "DDD/domain/entity"
+
+type AuditRepository interface {
+	SaveEvent(*entity.AuditEvent) error
+	GetEventsByUser(uint64) ([]entity.AuditEvent, error)
+}
@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"DDD/domain/entity"
+	"time"
+)
+
+type JobRepository interface {
+	SaveJob(*entity.Job) (*entity.Job, map[string]string)
+	GetJob(uint64) (*entity.Job, error)
+	//ClaimJob moves a pending job to running. It reports false when another worker got there first.
+	ClaimJob(uint64) (bool, error)
+	//FinishJob records the outcome of a running job, a nil error means it succeeded
+	FinishJob(uint64, error) error
+	GetPendingJobs() ([]entity.Job, error)
+	//RequeueStaleJobs makes jobs that started before the given time pending again, their worker is assumed dead
+	RequeueStaleJobs(time.Time) error
+}
@@ -0,0 +1,14 @@
+package repository
+
+import "DDD/domain/entity"
+
+type ObjectRefRepository interface {
+	//RetainObject adds a reference to the object
+	RetainObject(string) error
+	//ReleaseObject removes a reference and reports whether it was the last one, in which case it deletes the object with the given func
+	//before the reference is forgotten. Objects stored before references were counted have none, releasing them always reports true.
+	ReleaseObject(string, func() error) (bool, error)
+	GetObjectRefs() ([]entity.ObjectRef, error)
+	//DeleteObjectRef forgets the references of an object the garbage collector removed
+	DeleteObjectRef(string) error
+}
@@ -0,0 +1,22 @@
+package repository
+
+import "DDD/domain/entity"
+
+type OrganizationRepository interface {
+	//SaveOrganization creates the organization with ownerId as its first owner
+	SaveOrganization(org *entity.Organization, ownerId uint64) (*entity.Organization, map[string]string)
+	GetOrganization(uint64) (*entity.Organization, error)
+	GetOrganizationsByUser(uint64) ([]entity.Organization, error)
+	GetMembership(orgId, userId uint64) (*entity.Membership, error)
+	GetMemberships(userId uint64) ([]entity.Membership, error)
+	GetMembers(orgId uint64) ([]entity.Membership, error)
+	UpdateMemberRole(orgId, userId uint64, role string) map[string]string
+	RemoveMember(orgId, userId uint64) map[string]string
+	//RemoveUserMemberships takes a user out of every organization. Where the user was the last owner an editor, or else another member, is promoted,
+	//and organizations left without members are deleted.
+	RemoveUserMemberships(userId uint64) map[string]string
+	SaveInvitation(*entity.Invitation) (*entity.Invitation, map[string]string)
+	GetInvitationByToken(string) (*entity.Invitation, error)
+	//AcceptInvitation turns a pending invitation into a membership of userId
+	AcceptInvitation(token string, userId uint64) (*entity.Membership, map[string]string)
+}
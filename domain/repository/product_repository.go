@@ -2,10 +2,19 @@ package repository
 
 import "DDD/domain/entity"
 
+//ProductRepository is scoped to one organization: every lookup takes the organization id, and a product of another organization is reported as not found
 type ProductRepository interface {
 	SaveProduct(*entity.Product) (*entity.Product, map[string]string)
-	GetProduct(uint64) (*entity.Product, error)
-	GetAllProduct() ([]entity.Product, error)
+	GetProduct(orgId, productId uint64) (*entity.Product, error)
+	GetAllProduct(orgId uint64) ([]entity.Product, error)
 	UpdateProduct(*entity.Product) (*entity.Product, map[string]string)
-	DeleteProduct(uint64) error
+	DeleteProduct(orgId, productId uint64) error
+	//GetProductsByUser returns the products a user created in every organization, it is not scoped and only meant for the user's own data
+	GetProductsByUser(userId uint64) ([]entity.Product, error)
+	//ReassignProducts makes toUserId the creator of the products fromUserId created in the organization
+	ReassignProducts(orgId, fromUserId, toUserId uint64) error
+	//SetImageVariants records the variants made of image, unless the product has another image by now
+	SetImageVariants(productId uint64, image string, variants entity.ImageVariants) map[string]string
+	//GetProductImages returns the image and variants of every product in every organization, for the storage garbage collector
+	GetProductImages() ([]entity.Product, error)
 }
\ No newline at end of file
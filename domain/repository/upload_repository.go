@@ -0,0 +1,15 @@
+package repository
+
+import "DDD/domain/entity"
+
+type UploadRepository interface {
+	SaveUpload(*entity.Upload) (*entity.Upload, map[string]string)
+	GetUpload(uint64) (*entity.Upload, error)
+	//GetUploadByKey finds the upload whose file is stored under the key
+	GetUploadByKey(string) (*entity.Upload, error)
+	//ConfirmUpload marks a pending upload as used, so an upload is attached at most once
+	ConfirmUpload(uint64) map[string]string
+	//RejectUpload marks a pending upload as infected, it can never be confirmed
+	RejectUpload(uint64) map[string]string
+	GetPendingUploads() ([]entity.Upload, error)
+}
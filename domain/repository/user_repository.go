@@ -6,5 +6,12 @@ type UserRepository interface {
 	SaveUser(*entity.User) (*entity.User, map[string]string)
 	GetUser(uint64) (*entity.User, error)
 	GetUsers() ([]entity.User, error)
+	GetUserByEmail(string) (*entity.User, error)
 	GetUserByEmailAndPassword(*entity.User) (*entity.User, map[string]string)
+	UpdatePassword(uint64, string) map[string]string
+	UpdateAvatar(uint64, string) map[string]string
+	//AnonymizeUser replaces the personal data of a user and deletes the account, the id stays so records pointing at it keep working
+	AnonymizeUser(uint64) map[string]string
+	//GetAvatars returns the avatar keys of every user having one
+	GetAvatars() ([]string, error)
 }
\ No newline at end of file
@@ -14,5 +14,6 @@ require (
 	github.com/stretchr/testify v1.7.0
 	github.com/twinj/uuid v1.0.0
 	golang.org/x/crypto v0.0.0-20210503195802-e9a32991a82e
+	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
 	gopkg.in/stretchr/testify.v1 v1.2.2 // indirect
 )
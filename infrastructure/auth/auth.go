@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v7"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,6 +14,23 @@ type AuthInterface interface {
 	FetchAuth(string) (uint64, error)
 	DeleteRefresh(string) error
 	DeleteTokens(*AccessDetails) error
+	CreateLink(*LinkDetails) error
+	ConsumeLink(string) (uint64, error)
+	//ListTokens returns the live access and refresh tokens of a user, links are not included
+	ListTokens(uint64) ([]StoredToken, error)
+	//DeleteUserTokens revokes every token and link of a user
+	DeleteUserTokens(uint64) error
+}
+
+//StoredToken is a token kept in the store, as listed by ListTokens
+type StoredToken struct {
+	Uuid      string
+	ExpiresAt time.Time
+}
+
+//Refresh tells refresh tokens apart from access tokens by the "++userid" suffix of their uuid
+func (st StoredToken) Refresh() bool {
+	return strings.Contains(st.Uuid, "++")
 }
 
 type ClientData struct {
@@ -28,6 +46,58 @@ func NewAuth(client *redis.Client) *ClientData {
 type AccessDetails struct {
 	TokenUuid string
 	UserId    uint64
+	//OrgId is the organization the session acts in, zero when the user belongs to none
+	OrgId   uint64
+	Scopes  []string
+	Expires int64
+	//ActorId is the admin acting as UserId, zero for normal tokens
+	ActorId uint64
+}
+
+func (ad *AccessDetails) Impersonated() bool {
+	return ad.ActorId != 0
+}
+
+func (ad *AccessDetails) HasScope(scope string) bool {
+	for _, s := range ad.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type RefreshDetails struct {
+	RefreshUuid string
+	UserId      uint64
+	OrgId       uint64
+	Expires     int64
+}
+
+//AccessUuid returns the uuid of the access token issued together with this refresh token
+func (rd *RefreshDetails) AccessUuid() string {
+	return strings.TrimSuffix(rd.RefreshUuid, fmt.Sprintf("++%d", rd.UserId))
+}
+
+//LinkDetails describes a single-use token sent by email, such as a magic login link
+type LinkDetails struct {
+	Token    string
+	LinkUuid string
+	Purpose  string
+	UserId   uint64
+	Expires  int64
+}
+
+//linkPrefix keeps link uuids apart from token uuids, so a link can never pass as a session
+const linkPrefix = "link:"
+
+func linkKey(linkUuid string) string {
+	return linkPrefix + linkUuid
+}
+
+//userTokensKey is the redis set indexing the keys of one user, so they can be listed and revoked together
+func userTokensKey(userid uint64) string {
+	return "user_tokens:" + strconv.FormatUint(userid, 10)
 }
 
 type TokenDetails struct {
@@ -56,6 +126,26 @@ func (tk *ClientData) CreateAuth(userid uint64, td *TokenDetails) error {
 	if atCreated == "0" || rtCreated == "0" {
 		return errors.New("no record inserted")
 	}
+	return tk.index(userid, rt, td.TokenUuid, td.RefreshUuid)
+}
+
+//index adds keys to the user's set and keeps the set alive as long as its longest lived key
+func (tk *ClientData) index(userid uint64, expires time.Time, keys ...string) error {
+	setKey := userTokensKey(userid)
+	members := make([]interface{}, len(keys))
+	for i, k := range keys {
+		members[i] = k
+	}
+	if err := tk.client.SAdd(setKey, members...).Err(); err != nil {
+		return err
+	}
+	ttl, err := tk.client.TTL(setKey).Result()
+	if err != nil {
+		return err
+	}
+	if ttl < time.Until(expires) {
+		return tk.client.ExpireAt(setKey, expires).Err()
+	}
 	return nil
 }
 
@@ -93,8 +183,67 @@ func (tk *ClientData) DeleteTokens(authD *AccessDetails) error {
 func (tk *ClientData) DeleteRefresh(refreshUuid string) error {
 	//delete refresh token
 	deleted, err := tk.client.Del(refreshUuid).Result()
-	if err != nil || deleted == 0 {
+	if err != nil {
 		return err
 	}
+	//a refresh token can only be used once
+	if deleted == 0 {
+		return errors.New("refresh token not found")
+	}
 	return nil
 }
+
+func (tk *ClientData) CreateLink(ld *LinkDetails) error {
+	expires := time.Unix(ld.Expires, 0)
+	if err := tk.client.Set(linkKey(ld.LinkUuid), strconv.FormatUint(ld.UserId, 10), time.Until(expires)).Err(); err != nil {
+		return err
+	}
+	return tk.index(ld.UserId, expires, linkKey(ld.LinkUuid))
+}
+
+//ConsumeLink reads and deletes the link in one transaction, so concurrent clicks cannot both succeed
+func (tk *ClientData) ConsumeLink(linkUuid string) (uint64, error) {
+	var get *redis.StringCmd
+	_, err := tk.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(linkKey(linkUuid))
+		pipe.Del(linkKey(linkUuid))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(get.Val(), 10, 64)
+}
+
+//ListTokens reads the user's set. Members whose key has expired or was deleted are dropped from the set on the way.
+func (tk *ClientData) ListTokens(userid uint64) ([]StoredToken, error) {
+	keys, err := tk.client.SMembers(userTokensKey(userid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var tokens []StoredToken
+	for _, key := range keys {
+		ttl, err := tk.client.PTTL(key).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ttl <= 0 {
+			tk.client.SRem(userTokensKey(userid), key)
+			continue
+		}
+		if strings.HasPrefix(key, linkPrefix) {
+			continue
+		}
+		tokens = append(tokens, StoredToken{Uuid: key, ExpiresAt: time.Now().Add(ttl)})
+	}
+	return tokens, nil
+}
+
+func (tk *ClientData) DeleteUserTokens(userid uint64) error {
+	keys, err := tk.client.SMembers(userTokensKey(userid)).Result()
+	if err != nil {
+		return err
+	}
+	keys = append(keys, userTokensKey(userid))
+	return tk.client.Del(keys...).Err()
+}
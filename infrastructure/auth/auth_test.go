@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v7"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/twinj/uuid"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//runAuthSuite is the behaviour every AuthInterface backend has to provide
+func runAuthSuite(t *testing.T, newAuth func(t *testing.T) AuthInterface) {
+	t.Run("CreateAndFetch", func(t *testing.T) {
+		a := newAuth(t)
+		td := tokenDetails(1, time.Minute)
+		assert.Nil(t, a.CreateAuth(1, td))
+
+		userId, err := a.FetchAuth(td.TokenUuid)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, userId)
+
+		userId, err = a.FetchAuth(td.RefreshUuid)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, userId)
+	})
+	t.Run("FetchUnknown", func(t *testing.T) {
+		a := newAuth(t)
+		_, err := a.FetchAuth(uuid.NewV4().String())
+		assert.NotNil(t, err)
+	})
+	t.Run("DeleteTokens", func(t *testing.T) {
+		a := newAuth(t)
+		td := tokenDetails(2, time.Minute)
+		if err := a.CreateAuth(2, td); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		access := &AccessDetails{TokenUuid: td.TokenUuid, UserId: 2}
+		assert.Nil(t, a.DeleteTokens(access))
+
+		_, err := a.FetchAuth(td.TokenUuid)
+		assert.NotNil(t, err)
+		_, err = a.FetchAuth(td.RefreshUuid)
+		assert.NotNil(t, err)
+		//logging out twice is an error
+		assert.NotNil(t, a.DeleteTokens(access))
+	})
+	t.Run("DeleteRefreshOnce", func(t *testing.T) {
+		a := newAuth(t)
+		td := tokenDetails(3, time.Minute)
+		if err := a.CreateAuth(3, td); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		assert.Nil(t, a.DeleteRefresh(td.RefreshUuid))
+		assert.NotNil(t, a.DeleteRefresh(td.RefreshUuid))
+
+		//the access token is untouched
+		userId, err := a.FetchAuth(td.TokenUuid)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 3, userId)
+	})
+	t.Run("ConsumeLinkOnce", func(t *testing.T) {
+		a := newAuth(t)
+		link := &LinkDetails{LinkUuid: uuid.NewV4().String(), UserId: 5, Expires: time.Now().Add(time.Minute).Unix()}
+		if err := a.CreateLink(link); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		//a link is not a session
+		_, err := a.FetchAuth(link.LinkUuid)
+		assert.NotNil(t, err)
+
+		//concurrent clicks: exactly one wins
+		results := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			go func() {
+				userId, err := a.ConsumeLink(link.LinkUuid)
+				if err == nil && userId != 5 {
+					err = fmt.Errorf("wrong user %d", userId)
+				}
+				results <- err
+			}()
+		}
+		var succeeded int
+		for i := 0; i < 8; i++ {
+			if <-results == nil {
+				succeeded++
+			}
+		}
+		assert.EqualValues(t, 1, succeeded)
+	})
+	t.Run("ListAndDeleteUserTokens", func(t *testing.T) {
+		a := newAuth(t)
+		first, second, other := tokenDetails(6, time.Minute), tokenDetails(6, time.Minute), tokenDetails(7, time.Minute)
+		for _, td := range []*TokenDetails{first, second, other} {
+			userId, _ := strconv.ParseUint(td.RefreshUuid[strings.Index(td.RefreshUuid, "++")+2:], 10, 64)
+			if err := a.CreateAuth(userId, td); err != nil {
+				t.Fatalf("want non error, got %#v", err)
+			}
+		}
+		link := &LinkDetails{LinkUuid: uuid.NewV4().String(), UserId: 6, Expires: time.Now().Add(time.Minute).Unix()}
+		if err := a.CreateLink(link); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		//a logged out session is not listed
+		assert.Nil(t, a.DeleteTokens(&AccessDetails{TokenUuid: second.TokenUuid, UserId: 6}))
+
+		tokens, err := a.ListTokens(6)
+		assert.Nil(t, err)
+		var uuids []string
+		for _, st := range tokens {
+			uuids = append(uuids, st.Uuid)
+			assert.True(t, st.ExpiresAt.After(time.Now()))
+		}
+		assert.ElementsMatch(t, []string{first.TokenUuid, first.RefreshUuid}, uuids)
+
+		assert.Nil(t, a.DeleteUserTokens(6))
+		_, err = a.FetchAuth(first.TokenUuid)
+		assert.NotNil(t, err)
+		_, err = a.ConsumeLink(link.LinkUuid)
+		assert.NotNil(t, err)
+		tokens, _ = a.ListTokens(6)
+		assert.Empty(t, tokens)
+
+		//other users keep their sessions
+		userId, err := a.FetchAuth(other.TokenUuid)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 7, userId)
+	})
+	t.Run("Expiry", func(t *testing.T) {
+		a := newAuth(t)
+		td := tokenDetails(4, time.Second)
+		if err := a.CreateAuth(4, td); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		time.Sleep(1100 * time.Millisecond)
+
+		_, err := a.FetchAuth(td.TokenUuid)
+		assert.NotNil(t, err)
+		_, err = a.FetchAuth(td.RefreshUuid)
+		assert.NotNil(t, err)
+		assert.NotNil(t, a.DeleteRefresh(td.RefreshUuid))
+
+		link := &LinkDetails{LinkUuid: uuid.NewV4().String(), UserId: 4, Expires: time.Now().Add(time.Second).Unix()}
+		if err := a.CreateLink(link); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		time.Sleep(1100 * time.Millisecond)
+		_, err = a.ConsumeLink(link.LinkUuid)
+		assert.NotNil(t, err)
+	})
+}
+
+func tokenDetails(userid uint64, ttl time.Duration) *TokenDetails {
+	tokenUuid := uuid.NewV4().String()
+	return &TokenDetails{
+		TokenUuid:   tokenUuid,
+		RefreshUuid: tokenUuid + "++" + strconv.Itoa(int(userid)),
+		AtExpires:   time.Now().Add(ttl).Unix(),
+		RtExpires:   time.Now().Add(ttl).Unix(),
+	}
+}
+
+func loadTestEnv() {
+	if _, err := os.Stat("./../../.env"); !os.IsNotExist(err) {
+		_ = godotenv.Load(os.ExpandEnv("./../../.env"))
+	}
+}
+
+func TestMemoryAuth(t *testing.T) {
+	runAuthSuite(t, func(t *testing.T) AuthInterface {
+		return NewMemoryAuth()
+	})
+}
+
+func TestSQLAuth(t *testing.T) {
+	loadTestEnv()
+	DBURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", os.Getenv("TEST_DB_HOST"), os.Getenv("TEST_DB_PORT"), os.Getenv("TEST_DB_USER"), os.Getenv("TEST_DB_NAME"), os.Getenv("TEST_DB_PASSWORD"))
+	conn, err := gorm.Open("postgres", DBURL)
+	if err != nil {
+		t.Skipf("no test database: %v", err)
+	}
+	defer conn.Close()
+
+	runAuthSuite(t, func(t *testing.T) AuthInterface {
+		if err := conn.DropTableIfExists(&AuthToken{}).Error; err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		a := NewSQLAuth(conn)
+		if err := a.Automigrate(); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		return a
+	})
+}
+
+func TestRedisAuth(t *testing.T) {
+	loadTestEnv()
+	client := redis.NewClient(&redis.Options{
+		Addr:     os.Getenv("REDIS_HOST") + ":" + os.Getenv("REDIS_PORT"),
+		Password: os.Getenv("REDIS_PASSWORD"),
+		DB:       1,
+	})
+	defer client.Close()
+	if err := client.Ping().Err(); err != nil {
+		t.Skipf("no test redis: %v", err)
+	}
+
+	runAuthSuite(t, func(t *testing.T) AuthInterface {
+		if err := client.FlushDB().Err(); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		return NewAuth(client)
+	})
+}
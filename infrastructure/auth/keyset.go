@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/twinj/uuid"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//SigningKey is an asymmetric key identified by its kid.
+//Keys that only have a public half can verify tokens but never sign them.
+type SigningKey struct {
+	Kid     string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+//KeySet holds the active signing key followed by the keys that are still accepted for verification.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*SigningKey
+}
+
+//JSONWebKey is the public part of a SigningKey as published in the JWKS document.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+//NewKeySet builds a key set. The first key is the active one and must be able to sign.
+func NewKeySet(keys ...*SigningKey) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	if keys[0].Private == nil {
+		return nil, fmt.Errorf("active key %q has no private key", keys[0].Kid)
+	}
+	return &KeySet{keys: keys}, nil
+}
+
+//LoadKeySet reads PEM files, the first file is the active key. The kid of every key is its file name without the extension.
+func LoadKeySet(paths ...string) (*KeySet, error) {
+	var keys []*SigningKey
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		key, err := ParseSigningKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySet(keys...)
+}
+
+//GenerateKeySet creates a key set with a single ephemeral Ed25519 key. Tokens do not survive a restart, so this is for development only.
+func GenerateKeySet() (*KeySet, error) {
+	key, err := GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(key)
+}
+
+func GenerateSigningKey() (*SigningKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		Kid:     uuid.NewV4().String(),
+		Method:  SigningMethodEd25519,
+		Private: private,
+		Public:  public,
+	}, nil
+}
+
+//ParseSigningKey accepts RSA (RS256) and Ed25519 (EdDSA) keys, either private or public, in PKCS#1, PKCS#8 or PKIX PEM blocks.
+func ParseSigningKey(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := &SigningKey{Kid: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = SigningMethodEd25519, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = SigningMethodEd25519, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+//Active returns the key new tokens are signed with.
+func (ks *KeySet) Active() *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[0]
+}
+
+func (ks *KeySet) Lookup(kid string) (*SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, k := range ks.keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+//Rotate makes key the active key. The previous keys stay in the set so tokens they signed keep verifying until Retire is called.
+func (ks *KeySet) Rotate(key *SigningKey) error {
+	if key.Private == nil {
+		return fmt.Errorf("key %q has no private key", key.Kid)
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	keys := []*SigningKey{key}
+	for _, k := range ks.keys {
+		if k.Kid != key.Kid {
+			keys = append(keys, k)
+		}
+	}
+	ks.keys = keys
+	return nil
+}
+
+//Retire removes a previous key from the set. The active key cannot be retired.
+func (ks *KeySet) Retire(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.keys[0].Kid == kid {
+		return errors.New("cannot retire the active key")
+	}
+	for i, k := range ks.keys {
+		if k.Kid == kid {
+			ks.keys = append(ks.keys[:i:i], ks.keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key %q", kid)
+}
+
+//Methods returns the alg values of the keys in the set, to restrict the algorithms accepted when parsing.
+func (ks *KeySet) Methods() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var methods []string
+	seen := map[string]bool{}
+	for _, k := range ks.keys {
+		if !seen[k.Method.Alg()] {
+			seen[k.Method.Alg()] = true
+			methods = append(methods, k.Method.Alg())
+		}
+	}
+	return methods
+}
+
+//JWKS returns the public keys of the set in the RFC 7517 format.
+func (ks *KeySet) JWKS() *JSONWebKeySet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, k := range ks.keys {
+		jwk := JSONWebKey{Kid: k.Kid, Use: "sig", Alg: k.Method.Alg()}
+		switch pub := k.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//sweepInterval bounds how often CreateAuth scans the whole map for expired entries
+const sweepInterval = time.Minute
+
+//MemoryAuth keeps token metadata in process memory. It is meant for single-node deployments, development and tests.
+type MemoryAuth struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	userId  uint64
+	expires time.Time
+}
+
+var _ AuthInterface = &MemoryAuth{}
+
+func NewMemoryAuth() *MemoryAuth {
+	return &MemoryAuth{entries: map[string]memoryEntry{}, lastSweep: time.Now()}
+}
+
+func (m *MemoryAuth) CreateAuth(userid uint64, td *TokenDetails) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	m.entries[td.TokenUuid] = memoryEntry{userId: userid, expires: time.Unix(td.AtExpires, 0)}
+	m.entries[td.RefreshUuid] = memoryEntry{userId: userid, expires: time.Unix(td.RtExpires, 0)}
+	return nil
+}
+
+//get returns the live entry for key, dropping it when it has expired. The caller must hold the lock.
+func (m *MemoryAuth) get(key string) (memoryEntry, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return e, false
+	}
+	if !time.Now().Before(e.expires) {
+		delete(m.entries, key)
+		return e, false
+	}
+	return e, true
+}
+
+func (m *MemoryAuth) FetchAuth(tokenUuid string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.get(tokenUuid)
+	if !ok {
+		return 0, errors.New("token not found")
+	}
+	return e.userId, nil
+}
+
+func (m *MemoryAuth) DeleteTokens(authD *AccessDetails) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	//get the refresh uuid
+	refreshUuid := fmt.Sprintf("%s++%d", authD.TokenUuid, authD.UserId)
+	_, atFound := m.get(authD.TokenUuid)
+	_, rtFound := m.get(refreshUuid)
+	delete(m.entries, authD.TokenUuid)
+	delete(m.entries, refreshUuid)
+	if !atFound || !rtFound {
+		return errors.New("something went wrong")
+	}
+	return nil
+}
+
+func (m *MemoryAuth) DeleteRefresh(refreshUuid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.get(refreshUuid); !ok {
+		return errors.New("refresh token not found")
+	}
+	delete(m.entries, refreshUuid)
+	return nil
+}
+
+func (m *MemoryAuth) CreateLink(ld *LinkDetails) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[linkKey(ld.LinkUuid)] = memoryEntry{userId: ld.UserId, expires: time.Unix(ld.Expires, 0)}
+	return nil
+}
+
+func (m *MemoryAuth) ConsumeLink(linkUuid string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.get(linkKey(linkUuid))
+	if !ok {
+		return 0, errors.New("link not found")
+	}
+	delete(m.entries, linkKey(linkUuid))
+	return e.userId, nil
+}
+
+func (m *MemoryAuth) ListTokens(userid uint64) ([]StoredToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tokens []StoredToken
+	for k := range m.entries {
+		e, ok := m.get(k)
+		if !ok || e.userId != userid || strings.HasPrefix(k, linkPrefix) {
+			continue
+		}
+		tokens = append(tokens, StoredToken{Uuid: k, ExpiresAt: e.expires})
+	}
+	return tokens, nil
+}
+
+func (m *MemoryAuth) DeleteUserTokens(userid uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.entries {
+		if e.userId == userid {
+			delete(m.entries, k)
+		}
+	}
+	return nil
+}
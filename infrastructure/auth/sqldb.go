@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+//AuthToken is a row of the auth_tokens table used by SQLAuth
+type AuthToken struct {
+	Uuid      string    `gorm:"primary_key;size:255"`
+	UserID    uint64    `gorm:"not null;index"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+//SQLAuth keeps token metadata in a table of the application database
+type SQLAuth struct {
+	db *gorm.DB
+}
+
+var _ AuthInterface = &SQLAuth{}
+
+func NewSQLAuth(db *gorm.DB) *SQLAuth {
+	return &SQLAuth{db: db}
+}
+
+func (s *SQLAuth) Automigrate() error {
+	return s.db.AutoMigrate(&AuthToken{}).Error
+}
+
+func (s *SQLAuth) CreateAuth(userid uint64, td *TokenDetails) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		//expired rows are never read again, so clear them while we are writing anyway
+		if err := tx.Where("expires_at <= ?", time.Now()).Delete(&AuthToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&AuthToken{Uuid: td.TokenUuid, UserID: userid, ExpiresAt: time.Unix(td.AtExpires, 0)}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&AuthToken{Uuid: td.RefreshUuid, UserID: userid, ExpiresAt: time.Unix(td.RtExpires, 0)}).Error
+	})
+}
+
+func (s *SQLAuth) FetchAuth(tokenUuid string) (uint64, error) {
+	var token AuthToken
+	err := s.db.Where("uuid = ? AND expires_at > ?", tokenUuid, time.Now()).Take(&token).Error
+	if err != nil {
+		return 0, err
+	}
+	return token.UserID, nil
+}
+
+func (s *SQLAuth) DeleteTokens(authD *AccessDetails) error {
+	//get the refresh uuid
+	refreshUuid := fmt.Sprintf("%s++%d", authD.TokenUuid, authD.UserId)
+	result := s.db.Where("uuid IN (?) AND expires_at > ?", []string{authD.TokenUuid, refreshUuid}, time.Now()).Delete(&AuthToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 2 {
+		return errors.New("something went wrong")
+	}
+	return nil
+}
+
+func (s *SQLAuth) DeleteRefresh(refreshUuid string) error {
+	result := s.db.Where("uuid = ? AND expires_at > ?", refreshUuid, time.Now()).Delete(&AuthToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("refresh token not found")
+	}
+	return nil
+}
+
+func (s *SQLAuth) CreateLink(ld *LinkDetails) error {
+	return s.db.Create(&AuthToken{Uuid: linkKey(ld.LinkUuid), UserID: ld.UserId, ExpiresAt: time.Unix(ld.Expires, 0)}).Error
+}
+
+//ConsumeLink relies on the row count of the delete: when two requests race, only one of them deletes the row
+func (s *SQLAuth) ConsumeLink(linkUuid string) (uint64, error) {
+	var token AuthToken
+	err := s.db.Where("uuid = ? AND expires_at > ?", linkKey(linkUuid), time.Now()).Take(&token).Error
+	if err != nil {
+		return 0, err
+	}
+	result := s.db.Where("uuid = ?", token.Uuid).Delete(&AuthToken{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return 0, errors.New("link already used")
+	}
+	return token.UserID, nil
+}
+
+func (s *SQLAuth) ListTokens(userid uint64) ([]StoredToken, error) {
+	var rows []AuthToken
+	err := s.db.Where("user_id = ? AND expires_at > ? AND uuid NOT LIKE ?", userid, time.Now(), linkPrefix+"%").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]StoredToken, len(rows))
+	for i, row := range rows {
+		tokens[i] = StoredToken{Uuid: row.Uuid, ExpiresAt: row.ExpiresAt}
+	}
+	return tokens, nil
+}
+
+func (s *SQLAuth) DeleteUserTokens(userid uint64) error {
+	return s.db.Where("user_id = ?", userid).Delete(&AuthToken{}).Error
+}
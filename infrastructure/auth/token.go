@@ -1,29 +1,45 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/twinj/uuid"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
-type Token struct{}
+type Token struct {
+	keys *KeySet
+}
 
-func NewToken() *Token { return &Token{} }
+func NewToken(keys *KeySet) *Token { return &Token{keys: keys} }
 
 type TokenInterface interface {
-	CreateToken(userid uint64) (*TokenDetails, error)
+	CreateToken(userid, orgId uint64, scopes []string) (*TokenDetails, error)
+	CreateImpersonationToken(actorId, userid, orgId uint64, scopes []string) (*TokenDetails, error)
+	TokenValid(*http.Request) error
 	ExtractTokenMetadata(*http.Request) (*AccessDetails, error)
+	ExtractAccessMetadata(accessToken string) (*AccessDetails, error)
+	ExtractRefreshMetadata(refreshToken string) (*RefreshDetails, error)
+	CreateLinkToken(purpose string, userid uint64, ttl time.Duration) (*LinkDetails, error)
+	ExtractLinkMetadata(purpose, linkToken string) (*LinkDetails, error)
 }
 
+//Purposes of link tokens, a link issued for one purpose is rejected for any other
+const (
+	LinkLogin         = "login"
+	LinkPasswordReset = "password_reset"
+)
+
 //Token implements the TokenInterface
 var _ TokenInterface = &Token{}
 
-func (t *Token) CreateToken(userid uint64) (*TokenDetails, error) {
+//CreateToken issues an access and refresh token pair. The scopes are embedded in the access token as a space separated "scope" claim.
+//orgId is the organization the session acts in, both tokens carry it so a refresh stays in the same organization. Zero means none.
+func (t *Token) CreateToken(userid, orgId uint64, scopes []string) (*TokenDetails, error) {
 	td := &TokenDetails{}
 	td.AtExpires = time.Now().Add(time.Minute * 15).Unix()
 	td.TokenUuid = uuid.NewV4().String()
@@ -37,9 +53,10 @@ func (t *Token) CreateToken(userid uint64) (*TokenDetails, error) {
 	atClaims["authorized"] = true
 	atClaims["access_uuid"] = td.TokenUuid
 	atClaims["user_id"] = userid
+	atClaims["org_id"] = orgId
+	atClaims["scope"] = strings.Join(scopes, " ")
 	atClaims["exp"] = td.AtExpires
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
-	td.AccessToken, err = at.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	td.AccessToken, err = t.sign(atClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -47,39 +64,84 @@ func (t *Token) CreateToken(userid uint64) (*TokenDetails, error) {
 	rtClaims := jwt.MapClaims{}
 	rtClaims["refresh_uuid"] = td.RefreshUuid
 	rtClaims["user_id"] = userid
+	rtClaims["org_id"] = orgId
 	rtClaims["exp"] = td.RtExpires
-	rt := jwt.NewWithClaims(jwt.SigningMethodHS256, rtClaims)
-	td.RefreshToken, err = rt.SignedString([]byte(os.Getenv("REFRESH_SECRET")))
+	td.RefreshToken, err = t.sign(rtClaims)
 	if err != nil {
 		return nil, err
 	}
 	return td, nil
 }
 
-func TokenValid(r *http.Request) error {
-	token, err := VerifyToken(r)
+//ImpersonationTTL is deliberately short, impersonation tokens cannot be refreshed
+const ImpersonationTTL = time.Minute * 10
+
+//CreateImpersonationToken issues an access token for userid on behalf of actorId, recorded in the RFC 8693 "act" claim.
+//No refresh token is signed. The refresh uuid is still filled in, with the access expiry, so the token stores and logout treat it like any other token.
+func (t *Token) CreateImpersonationToken(actorId, userid, orgId uint64, scopes []string) (*TokenDetails, error) {
+	td := &TokenDetails{}
+	td.AtExpires = time.Now().Add(ImpersonationTTL).Unix()
+	td.TokenUuid = uuid.NewV4().String()
+	td.RtExpires = td.AtExpires
+	td.RefreshUuid = td.TokenUuid + "++" + strconv.Itoa(int(userid))
+
+	var err error
+	atClaims := jwt.MapClaims{}
+	atClaims["authorized"] = true
+	atClaims["access_uuid"] = td.TokenUuid
+	atClaims["user_id"] = userid
+	atClaims["org_id"] = orgId
+	atClaims["act"] = map[string]interface{}{"sub": strconv.FormatUint(actorId, 10)}
+	atClaims["scope"] = strings.Join(scopes, " ")
+	atClaims["exp"] = td.AtExpires
+	td.AccessToken, err = t.sign(atClaims)
 	if err != nil {
-		return err
-	}
-	if _, ok := token.Claims.(jwt.Claims); !ok && !token.Valid {
-		return err
+		return nil, err
 	}
-	return nil
+	return td, nil
+}
+
+//sign signs the claims with the active key and records its kid in the header
+func (t *Token) sign(claims jwt.MapClaims) (string, error) {
+	key := t.keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Private)
 }
 
-func VerifyToken(r *http.Request) (*jwt.Token, error) {
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		//Make sure that the token method conform to "SigningMethodHMAC"
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+//parse verifies the signature with the key named by the kid header, so tokens signed by rotated keys stay valid
+func (t *Token) parse(tokenString string) (*jwt.Token, error) {
+	parser := &jwt.Parser{ValidMethods: t.keys.Methods()}
+	return parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("token has no kid")
+		}
+		key, ok := t.keys.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown kid: %v", kid)
+		}
+		//Make sure that the token method conform to the method of the key
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(os.Getenv("ACCESS_SECRET")), nil
+		return key.Public, nil
 	})
+}
+
+func (t *Token) TokenValid(r *http.Request) error {
+	token, err := t.VerifyToken(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return token, nil
+	if _, ok := token.Claims.(jwt.Claims); !ok && !token.Valid {
+		return err
+	}
+	return nil
+}
+
+func (t *Token) VerifyToken(r *http.Request) (*jwt.Token, error) {
+	return t.parse(ExtractToken(r))
 }
 
 //get the token from the request body
@@ -93,26 +155,123 @@ func ExtractToken(r *http.Request) string {
 }
 
 func (t *Token) ExtractTokenMetadata(r *http.Request) (*AccessDetails, error) {
-	fmt.Println("WE ENTERED METADATA")
-	token, err := VerifyToken(r)
+	return t.ExtractAccessMetadata(ExtractToken(r))
+}
+
+func (t *Token) ExtractAccessMetadata(accessToken string) (*AccessDetails, error) {
+	token, err := t.parse(accessToken)
 	if err != nil {
 		return nil, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		accessUuid, ok := claims["access_uuid"].(string)
-		if !ok {
-			return nil, err
-		}
-		userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	accessUuid, ok := claims["access_uuid"].(string)
+	if !ok {
+		return nil, errors.New("not an access token")
+	}
+	userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	scope, _ := claims["scope"].(string)
+	details := &AccessDetails{
+		TokenUuid: accessUuid,
+		UserId:    userId,
+		OrgId:     orgId(claims),
+		Scopes:    strings.Fields(scope),
+		Expires:   expiry(claims),
+	}
+	if act, ok := claims["act"].(map[string]interface{}); ok {
+		actor, _ := act["sub"].(string)
+		details.ActorId, err = strconv.ParseUint(actor, 10, 64)
 		if err != nil {
-			return nil, err
+			return nil, errors.New("invalid actor")
 		}
-		return &AccessDetails{
-			TokenUuid: accessUuid,
-			UserId:    userId,
-		}, nil
 	}
-	return nil, err
+	return details, nil
+}
+
+func (t *Token) ExtractRefreshMetadata(refreshToken string) (*RefreshDetails, error) {
+	token, err := t.parse(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("refresh token expired")
+	}
+	refreshUuid, ok := claims["refresh_uuid"].(string)
+	if !ok {
+		return nil, errors.New("not a refresh token")
+	}
+	userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &RefreshDetails{
+		RefreshUuid: refreshUuid,
+		UserId:      userId,
+		OrgId:       orgId(claims),
+		Expires:     expiry(claims),
+	}, nil
+}
+
+func expiry(claims jwt.MapClaims) int64 {
+	exp, _ := claims["exp"].(float64)
+	return int64(exp)
 }
 
+//orgId reads the active organization, tokens issued before organizations existed have none
+func orgId(claims jwt.MapClaims) uint64 {
+	org, _ := claims["org_id"].(float64)
+	return uint64(org)
+}
+
+//CreateLinkToken signs a single-use token to be sent by email. The caller stores it with AuthInterface.CreateLink.
+func (t *Token) CreateLinkToken(purpose string, userid uint64, ttl time.Duration) (*LinkDetails, error) {
+	ld := &LinkDetails{
+		LinkUuid: uuid.NewV4().String(),
+		Purpose:  purpose,
+		UserId:   userid,
+		Expires:  time.Now().Add(ttl).Unix(),
+	}
+	claims := jwt.MapClaims{}
+	claims["link_uuid"] = ld.LinkUuid
+	claims["purpose"] = purpose
+	claims["user_id"] = userid
+	claims["exp"] = ld.Expires
+	var err error
+	ld.Token, err = t.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	return ld, nil
+}
+
+func (t *Token) ExtractLinkMetadata(purpose, linkToken string) (*LinkDetails, error) {
+	token, err := t.parse(linkToken)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("link expired")
+	}
+	linkUuid, ok := claims["link_uuid"].(string)
+	if !ok || claims["purpose"] != purpose {
+		return nil, errors.New("invalid link")
+	}
+	userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &LinkDetails{
+		Token:    linkToken,
+		LinkUuid: linkUuid,
+		Purpose:  purpose,
+		UserId:   userId,
+		Expires:  expiry(claims),
+	}, nil
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func bearerRequest(token string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func pemEncodePKCS1(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func rsaSigningKey(t *testing.T, kid string) *SigningKey {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	key, err := ParseSigningKey(kid, pemEncodePKCS1(private))
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	return key
+}
+
+func TestCreateToken_Success(t *testing.T) {
+	ks, err := GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	tk := NewToken(ks)
+
+	td, err := tk.CreateToken(1, 3, []string{"products:write", "users:admin"})
+	assert.Nil(t, err)
+
+	access, err := tk.ExtractTokenMetadata(bearerRequest(td.AccessToken))
+	assert.Nil(t, err)
+	assert.EqualValues(t, td.TokenUuid, access.TokenUuid)
+	assert.EqualValues(t, 1, access.UserId)
+	assert.EqualValues(t, 3, access.OrgId)
+	assert.EqualValues(t, []string{"products:write", "users:admin"}, access.Scopes)
+	assert.True(t, access.HasScope("users:admin"))
+	assert.False(t, access.HasScope("users"))
+
+	refresh, err := tk.ExtractRefreshMetadata(td.RefreshToken)
+	assert.Nil(t, err)
+	assert.EqualValues(t, td.RefreshUuid, refresh.RefreshUuid)
+	assert.EqualValues(t, 1, refresh.UserId)
+	assert.EqualValues(t, 3, refresh.OrgId)
+
+	//an access token cannot be used as a refresh token
+	_, err = tk.ExtractRefreshMetadata(td.AccessToken)
+	assert.NotNil(t, err)
+}
+
+func TestCreateToken_KeyRotation(t *testing.T) {
+	ks, err := NewKeySet(rsaSigningKey(t, "old"))
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	tk := NewToken(ks)
+	oldToken, err := tk.CreateToken(1, 0, nil)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+
+	newKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	assert.Nil(t, ks.Rotate(newKey))
+	assert.EqualValues(t, newKey.Kid, ks.Active().Kid)
+
+	newToken, err := tk.CreateToken(2, 0, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, tk.TokenValid(bearerRequest(newToken.AccessToken)))
+	//tokens signed before the rotation keep verifying
+	assert.Nil(t, tk.TokenValid(bearerRequest(oldToken.AccessToken)))
+
+	assert.Nil(t, ks.Retire("old"))
+	assert.NotNil(t, tk.TokenValid(bearerRequest(oldToken.AccessToken)))
+	assert.NotNil(t, ks.Retire(newKey.Kid))
+}
+
+func TestVerifyToken_UnknownKey(t *testing.T) {
+	issuer, _ := GenerateKeySet()
+	verifier, _ := GenerateKeySet()
+
+	td, err := NewToken(issuer).CreateToken(1, 0, nil)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	assert.NotNil(t, NewToken(verifier).TokenValid(bearerRequest(td.AccessToken)))
+}
+
+func TestJWKS_Success(t *testing.T) {
+	rsaKey := rsaSigningKey(t, "rsa")
+	edKey, _ := GenerateSigningKey()
+	ks, err := NewKeySet(edKey, rsaKey)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	set := ks.JWKS()
+
+	assert.EqualValues(t, 2, len(set.Keys))
+	assert.EqualValues(t, "OKP", set.Keys[0].Kty)
+	assert.EqualValues(t, "EdDSA", set.Keys[0].Alg)
+	assert.EqualValues(t, edKey.Kid, set.Keys[0].Kid)
+	assert.EqualValues(t, "RSA", set.Keys[1].Kty)
+	assert.EqualValues(t, "RS256", set.Keys[1].Alg)
+	assert.EqualValues(t, "AQAB", set.Keys[1].E)
+}
+
+func TestCreateImpersonationToken_Success(t *testing.T) {
+	ks, _ := GenerateKeySet()
+	tk := NewToken(ks)
+
+	td, err := tk.CreateImpersonationToken(1, 2, 3, []string{"products:write"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "", td.RefreshToken)
+
+	access, err := tk.ExtractTokenMetadata(bearerRequest(td.AccessToken))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, access.UserId)
+	assert.EqualValues(t, 1, access.ActorId)
+	assert.EqualValues(t, 3, access.OrgId)
+	assert.True(t, access.Impersonated())
+
+	td, _ = tk.CreateToken(2, 0, nil)
+	access, _ = tk.ExtractTokenMetadata(bearerRequest(td.AccessToken))
+	assert.False(t, access.Impersonated())
+}
+
+func TestCreateLinkToken_Success(t *testing.T) {
+	ks, _ := GenerateKeySet()
+	tk := NewToken(ks)
+
+	ld, err := tk.CreateLinkToken(LinkLogin, 7, time.Minute)
+	assert.Nil(t, err)
+
+	parsed, err := tk.ExtractLinkMetadata(LinkLogin, ld.Token)
+	assert.Nil(t, err)
+	assert.EqualValues(t, ld.LinkUuid, parsed.LinkUuid)
+	assert.EqualValues(t, 7, parsed.UserId)
+
+	//a login link is useless for anything else
+	_, err = tk.ExtractLinkMetadata("password_reset", ld.Token)
+	assert.NotNil(t, err)
+	//and a link cannot be used as an access token
+	_, err = tk.ExtractAccessMetadata(ld.Token)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+//Handler does the work of one kind of job. Jobs can run more than once after a crash, so handlers must be safe to repeat.
+type Handler func(job *entity.Job) error
+
+//Queue is what request handlers need to start jobs and follow them
+type Queue interface {
+	Enqueue(*entity.Job) (*entity.Job, map[string]string)
+	Get(uint64) (*entity.Job, error)
+}
+
+//Runner executes the jobs kept by a JobRepository with a fixed number of workers.
+//Every worker claims a job in the database before running it, so several instances can share the table.
+type Runner struct {
+	repo      repository.JobRepository
+	handlers  map[string]Handler
+	schedules []schedule
+	workers   int
+	queue     chan uint64
+	stop      chan struct{}
+	wg        sync.WaitGroup
+
+	//PollInterval is how often the table is read for jobs enqueued elsewhere or left behind
+	PollInterval time.Duration
+	//StaleAfter is how long a job may run before it is assumed its worker died
+	StaleAfter time.Duration
+}
+
+var _ Queue = &Runner{}
+
+func NewRunner(repo repository.JobRepository, workers int) *Runner {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Runner{
+		repo:         repo,
+		handlers:     map[string]Handler{},
+		workers:      workers,
+		queue:        make(chan uint64, 100),
+		stop:         make(chan struct{}),
+		PollInterval: time.Minute,
+		StaleAfter:   time.Hour,
+	}
+}
+
+//Handle registers the handler of a kind of job. It must be called before Start.
+func (r *Runner) Handle(kind string, h Handler) {
+	r.handlers[kind] = h
+}
+
+type schedule struct {
+	kind     string
+	interval time.Duration
+}
+
+//Schedule enqueues a job of the kind every interval while the runner is started. It must be called before Start.
+//Every instance sharing the table schedules its own jobs, so scheduled work must not mind running twice.
+func (r *Runner) Schedule(kind string, interval time.Duration) {
+	r.schedules = append(r.schedules, schedule{kind: kind, interval: interval})
+}
+
+//Enqueue saves the job as pending and hands it to a worker
+func (r *Runner) Enqueue(job *entity.Job) (*entity.Job, map[string]string) {
+	if _, ok := r.handlers[job.Kind]; !ok {
+		return nil, map[string]string{"invalid_job": "unknown kind of job"}
+	}
+	saved, err := r.repo.SaveJob(job)
+	if err != nil {
+		return nil, err
+	}
+	r.offer(saved.ID)
+	return saved, nil
+}
+
+func (r *Runner) Get(jobId uint64) (*entity.Job, error) {
+	return r.repo.GetJob(jobId)
+}
+
+//offer never blocks a request, a job that does not fit in the queue is picked up by the next poll
+func (r *Runner) offer(jobId uint64) {
+	select {
+	case r.queue <- jobId:
+	default:
+	}
+}
+
+func (r *Runner) Start() {
+	for i := 0; i < r.workers; i++ {
+		r.wg.Add(1)
+		go r.work()
+	}
+	r.wg.Add(1)
+	go r.poll()
+	for _, s := range r.schedules {
+		r.wg.Add(1)
+		go r.every(s)
+	}
+}
+
+//Stop waits for the running jobs to finish
+func (r *Runner) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+func (r *Runner) poll() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.repo.RequeueStaleJobs(time.Now().Add(-r.StaleAfter)); err != nil {
+			log.Printf("jobs: cannot requeue stale jobs: %v", err)
+		}
+		pending, err := r.repo.GetPendingJobs()
+		if err != nil {
+			log.Printf("jobs: cannot read pending jobs: %v", err)
+		}
+		for _, job := range pending {
+			r.offer(job.ID)
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) every(s schedule) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if _, err := r.Enqueue(&entity.Job{Kind: s.kind}); err != nil {
+				log.Printf("jobs: cannot enqueue the scheduled %s job: %v", s.kind, err)
+			}
+		}
+	}
+}
+
+func (r *Runner) work() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case jobId := <-r.queue:
+			r.run(jobId)
+		}
+	}
+}
+
+func (r *Runner) run(jobId uint64) {
+	claimed, err := r.repo.ClaimJob(jobId)
+	if err != nil {
+		log.Printf("jobs: cannot claim job %d: %v", jobId, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	job, err := r.repo.GetJob(jobId)
+	if err != nil {
+		log.Printf("jobs: cannot load job %d: %v", jobId, err)
+		return
+	}
+	jobErr := r.execute(job)
+	if jobErr != nil {
+		log.Printf("jobs: %s job %d failed: %v", job.Kind, job.ID, jobErr)
+	}
+	if err := r.repo.FinishJob(job.ID, jobErr); err != nil {
+		log.Printf("jobs: cannot record the end of job %d: %v", job.ID, err)
+	}
+}
+
+//execute turns a panicking handler into a failed job instead of a dead worker
+func (r *Runner) execute(job *entity.Job) (err error) {
+	handler, ok := r.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for %s jobs", job.Kind)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(job)
+}
@@ -0,0 +1,180 @@
+package jobs
+
+import (
+	"DDD/domain/entity"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+//memoryJobs is a JobRepository kept in memory
+type memoryJobs struct {
+	mu   sync.Mutex
+	jobs map[uint64]*entity.Job
+	next uint64
+}
+
+func newMemoryJobs() *memoryJobs {
+	return &memoryJobs{jobs: map[uint64]*entity.Job{}}
+}
+
+func (m *memoryJobs) SaveJob(job *entity.Job) (*entity.Job, map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	job.ID = m.next
+	job.Status = entity.JobPending
+	saved := *job
+	m.jobs[job.ID] = &saved
+	return job, nil
+}
+
+func (m *memoryJobs) GetJob(id uint64) (*entity.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, errors.New("job not found")
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (m *memoryJobs) ClaimJob(id uint64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || job.Status != entity.JobPending {
+		return false, nil
+	}
+	now := time.Now()
+	job.Status = entity.JobRunning
+	job.StartedAt = &now
+	job.Attempts++
+	return true, nil
+}
+
+func (m *memoryJobs) FinishJob(id uint64, jobErr error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.jobs[id]
+	job.Status = entity.JobSucceeded
+	if jobErr != nil {
+		job.Status = entity.JobFailed
+		job.Error = jobErr.Error()
+	}
+	return nil
+}
+
+func (m *memoryJobs) GetPendingJobs() ([]entity.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pending []entity.Job
+	for _, job := range m.jobs {
+		if job.Status == entity.JobPending {
+			pending = append(pending, *job)
+		}
+	}
+	return pending, nil
+}
+
+func (m *memoryJobs) RequeueStaleJobs(startedBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.Status == entity.JobRunning && job.StartedAt.Before(startedBefore) {
+			job.Status = entity.JobPending
+		}
+	}
+	return nil
+}
+
+//waitFor polls the job until it is finished
+func waitFor(t *testing.T, r *Runner, id uint64) *entity.Job {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := r.Get(id)
+		assert.Nil(t, err)
+		if job.Status == entity.JobSucceeded || job.Status == entity.JobFailed {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %d did not finish", id)
+	return nil
+}
+
+func TestRunner_RunsJobs(t *testing.T) {
+	r := NewRunner(newMemoryJobs(), 2)
+	done := make(chan uint64, 1)
+	r.Handle("test", func(job *entity.Job) error {
+		done <- job.SubjectID
+		return nil
+	})
+	r.Start()
+	defer r.Stop()
+
+	job, jobErr := r.Enqueue(&entity.Job{Kind: "test", SubjectID: 7})
+	assert.Nil(t, jobErr)
+	assert.EqualValues(t, 7, <-done)
+	assert.EqualValues(t, entity.JobSucceeded, waitFor(t, r, job.ID).Status)
+}
+
+func TestRunner_RecordsFailures(t *testing.T) {
+	r := NewRunner(newMemoryJobs(), 1)
+	r.Handle("failing", func(job *entity.Job) error {
+		return errors.New("storage unavailable")
+	})
+	r.Handle("panicking", func(job *entity.Job) error {
+		panic("boom")
+	})
+	r.Start()
+	defer r.Stop()
+
+	failing, _ := r.Enqueue(&entity.Job{Kind: "failing"})
+	panicking, _ := r.Enqueue(&entity.Job{Kind: "panicking"})
+	job := waitFor(t, r, failing.ID)
+	assert.EqualValues(t, entity.JobFailed, job.Status)
+	assert.EqualValues(t, "storage unavailable", job.Error)
+	//the worker survived the panic
+	job = waitFor(t, r, panicking.ID)
+	assert.EqualValues(t, entity.JobFailed, job.Status)
+	assert.EqualValues(t, "panic: boom", job.Error)
+
+	_, jobErr := r.Enqueue(&entity.Job{Kind: "unknown"})
+	assert.EqualValues(t, map[string]string{"invalid_job": "unknown kind of job"}, jobErr)
+}
+
+//jobs saved while no runner was up are picked up when one starts
+func TestRunner_PicksUpPendingJobs(t *testing.T) {
+	repo := newMemoryJobs()
+	saved, _ := repo.SaveJob(&entity.Job{Kind: "test"})
+	r := NewRunner(repo, 1)
+	r.Handle("test", func(job *entity.Job) error { return nil })
+	r.Start()
+	defer r.Stop()
+
+	assert.EqualValues(t, entity.JobSucceeded, waitFor(t, r, saved.ID).Status)
+}
+
+func TestRunner_Schedule(t *testing.T) {
+	r := NewRunner(newMemoryJobs(), 1)
+	runs := make(chan struct{}, 10)
+	r.Handle("cleanup", func(job *entity.Job) error {
+		runs <- struct{}{}
+		return nil
+	})
+	r.Schedule("cleanup", 10*time.Millisecond)
+	r.Start()
+	defer r.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-runs:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("the scheduled job ran %d times", i)
+		}
+	}
+}
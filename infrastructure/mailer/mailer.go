@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+)
+
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+//LogMailer prints messages instead of sending them, for development
+type LogMailer struct{}
+
+var _ Mailer = &LogMailer{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	log.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+//SMTPMailer sends plain text mail through an SMTP relay
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+var _ Mailer = &SMTPMailer{}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{addr: host + ":" + port, auth: auth, from: from}
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	//the headers come from our own code, but make sure an address can never inject new ones
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+	msg := "From: " + m.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
@@ -0,0 +1,41 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+type AuditRepo struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepo {
+	return &AuditRepo{db}
+}
+
+//AuditRepo implements the repository.AuditRepository interface
+var _ repository.AuditRepository = &AuditRepo{}
+
+func (r *AuditRepo) SaveEvent(event *entity.AuditEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	err := r.db.Debug().Create(event).Error
+	if err != nil {
+		return errors.New("database error, please try again")
+	}
+	return nil
+}
+
+//GetEventsByUser returns the events performed as the user or by the user, newest first
+func (r *AuditRepo) GetEventsByUser(userId uint64) ([]entity.AuditEvent, error) {
+	var events []entity.AuditEvent
+	err := r.db.Debug().Where("user_id = ? OR actor_id = ?", userId, userId).Order("created_at desc").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
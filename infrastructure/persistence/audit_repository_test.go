@@ -0,0 +1,51 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSaveEvent_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	var event = entity.AuditEvent{}
+	event.ActorID = 1
+	event.UserID = 2
+	event.Action = "impersonation started"
+
+	repo := NewAuditRepository(conn)
+
+	saveErr := repo.SaveEvent(&event)
+	assert.Nil(t, saveErr)
+	assert.NotEqual(t, event.ID, 0)
+	assert.False(t, event.CreatedAt.IsZero())
+}
+
+func TestGetEventsByUser_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewAuditRepository(conn)
+	events := []entity.AuditEvent{
+		{ActorID: 1, UserID: 2, Action: "impersonation started"},
+		{ActorID: 1, UserID: 2, Action: "PUT /food/:product_id"},
+		{ActorID: 3, UserID: 3, Action: "impersonation started"},
+	}
+	for i := range events {
+		if err := repo.SaveEvent(&events[i]); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+	}
+
+	asUser, getErr := repo.GetEventsByUser(2)
+	assert.Nil(t, getErr)
+	assert.EqualValues(t, 2, len(asUser))
+
+	asActor, getErr := repo.GetEventsByUser(1)
+	assert.Nil(t, getErr)
+	assert.EqualValues(t, 2, len(asActor))
+}
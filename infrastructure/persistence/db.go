@@ -5,13 +5,20 @@ import (
 	"DDD/domain/repository"
 	"fmt"
 	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
 type Repositories struct {
-	User 	repository.UserRepository
-	Product repository.ProductRepository
-	db *gorm.DB
+	User         repository.UserRepository
+	Product      repository.ProductRepository
+	Audit        repository.AuditRepository
+	Organization repository.OrganizationRepository
+	Job          repository.JobRepository
+	Upload       repository.UploadRepository
+	ObjectRef    repository.ObjectRefRepository
+	db           *gorm.DB
 }
 
 func NewRepositories(Dbdriver, DbUser, DbPassword, DbPort, DbHost, DbName string) (*Repositories, error) {
@@ -23,16 +30,141 @@ func NewRepositories(Dbdriver, DbUser, DbPassword, DbPort, DbHost, DbName string
 	db.LogMode(true)
 
 	return &Repositories{
-		User: NewUserRepository(db),
-		Product: NewProductRepository(db),
-		db:   db,
+		User:         NewUserRepository(db),
+		Product:      NewProductRepository(db),
+		Audit:        NewAuditRepository(db),
+		Organization: NewOrganizationRepository(db),
+		Job:          NewJobRepository(db),
+		Upload:       NewUploadRepository(db),
+		ObjectRef:    NewObjectRefRepository(db),
+		db:           db,
 	}, nil
 }
 
+//DB exposes the connection for infrastructure that keeps its own tables, such as auth.SQLAuth
+func (s *Repositories) DB() *gorm.DB {
+	return s.db
+}
+
 func (s *Repositories) Close() error {
 	return s.db.Close()
 }
 
 func (s *Repositories) Automigrate() error {
-	return s.db.AutoMigrate(&entity.User{}, &entity.Product{}).Error
-}
\ No newline at end of file
+	err := s.db.AutoMigrate(&entity.User{}, &entity.Product{}, &entity.AuditEvent{}, &entity.Organization{}, &entity.Membership{}, &entity.Invitation{}, &entity.Job{}, &entity.Upload{}, &entity.ObjectRef{}).Error
+	if err != nil {
+		return err
+	}
+	//AutoMigrate never widens existing columns, and argon2id hashes need more room than bcrypt ones
+	err = s.db.Model(&entity.User{}).ModifyColumn("password", "varchar(255)").Error
+	if err != nil {
+		return err
+	}
+	//titles used to be unique across all users, they are now unique per organization. The old constraint has the name each
+	//database gave to the unique column. SQLite keeps it in the definition of the table, which would have to be rebuilt:
+	//SQLite databases created before organizations still refuse the same title in two organizations.
+	switch s.db.Dialect().GetName() {
+	case "postgres":
+		err = s.db.Exec("ALTER TABLE products DROP CONSTRAINT IF EXISTS products_title_key").Error
+	case "mysql":
+		if s.db.Dialect().HasIndex("products", "title") {
+			err = s.db.Model(&entity.Product{}).RemoveIndex("title").Error
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return migrateProductOwnership(s.db)
+}
+
+//migrateProductOwnership moves products created before organizations existed into a personal organization of their creator
+func migrateProductOwnership(db *gorm.DB) error {
+	var owners []uint64
+	err := db.Model(&entity.Product{}).Unscoped().Where("organization_id = 0").Pluck("DISTINCT user_id", &owners).Error
+	if err != nil {
+		return err
+	}
+	for _, userId := range owners {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var user entity.User
+			if err := tx.Where("id = ?", userId).Take(&user).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+				return err
+			}
+			name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+			if name == "" {
+				name = fmt.Sprintf("User %d", userId)
+			}
+			org := &entity.Organization{Name: name, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+			if err := tx.Create(org).Error; err != nil {
+				return err
+			}
+			if user.ID != 0 {
+				err := tx.Create(&entity.Membership{OrganizationID: org.ID, UserID: user.ID, Role: entity.OrgRoleOwner, CreatedAt: time.Now()}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return tx.Model(&entity.Product{}).Unscoped().Where("organization_id = 0 AND user_id = ?", userId).UpdateColumn("organization_id", org.ID).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//NormalizeProductImages leaves only the keys in the product images that were saved with a URL in front, prefixes holds the URLs they were saved with.
+//Some got the prefix twice, every prefix is stripped for as long as one is found. Rows holding a key already are left alone, so it can run at every start.
+//The variants jobs enqueued before hold the image of their product in their target, theirs are stripped the same way.
+func (s *Repositories) NormalizeProductImages(prefixes ...string) error {
+	return normalizeProductImages(s.db, prefixes...)
+}
+
+func normalizeProductImages(db *gorm.DB, prefixes ...string) error {
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			continue
+		}
+		var products []entity.Product
+		err := db.Unscoped().Select("id, product_image").Where("product_image LIKE ?", prefix+"%").Find(&products).Error
+		if err != nil {
+			return err
+		}
+		for _, product := range products {
+			key := stripPrefixes(product.ProductImage, prefixes)
+			err := db.Model(&entity.Product{}).Unscoped().Where("id = ?", product.ID).UpdateColumn("product_image", key).Error
+			if err != nil {
+				return err
+			}
+		}
+		var jobs []entity.Job
+		err = db.Select("id, target").Where("kind = ? AND target LIKE ?", entity.JobImageVariants, prefix+"%").Find(&jobs).Error
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			key := stripPrefixes(job.Target, prefixes)
+			err := db.Model(&entity.Job{}).Where("id = ?", job.ID).UpdateColumn("target", key).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//stripPrefixes removes the prefixes from the front of image until none is left
+func stripPrefixes(image string, prefixes []string) string {
+	for {
+		stripped := image
+		for _, prefix := range prefixes {
+			if prefix != "" {
+				stripped = strings.TrimPrefix(stripped, prefix)
+			}
+		}
+		if stripped == image {
+			return image
+		}
+		image = stripped
+	}
+}
@@ -0,0 +1,88 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+type JobRepo struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) *JobRepo {
+	return &JobRepo{db}
+}
+
+//JobRepo implements the repository.JobRepository interface
+var _ repository.JobRepository = &JobRepo{}
+
+//jobErrorLength is the size of the error column
+const jobErrorLength = 255
+
+func (r *JobRepo) SaveJob(job *entity.Job) (*entity.Job, map[string]string) {
+	dbErr := map[string]string{}
+	job.Status = entity.JobPending
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+	err := r.db.Debug().Create(job).Error
+	if err != nil {
+		dbErr["db_error"] = "database error"
+		return nil, dbErr
+	}
+	return job, nil
+}
+
+func (r *JobRepo) GetJob(id uint64) (*entity.Job, error) {
+	var job entity.Job
+	err := r.db.Debug().Where("id = ?", id).Take(&job).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New("job not found")
+	}
+	if err != nil {
+		return nil, errors.New("database error, please try again")
+	}
+	return &job, nil
+}
+
+func (r *JobRepo) ClaimJob(id uint64) (bool, error) {
+	result := r.db.Debug().Model(&entity.Job{}).
+		Where("id = ? AND status = ?", id, entity.JobPending).
+		Updates(map[string]interface{}{"status": entity.JobRunning, "started_at": time.Now(), "attempts": gorm.Expr("attempts + 1")})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *JobRepo) FinishJob(id uint64, jobErr error) error {
+	update := map[string]interface{}{"status": entity.JobSucceeded, "finished_at": time.Now(), "error": ""}
+	if jobErr != nil {
+		message := jobErr.Error()
+		if len(message) > jobErrorLength {
+			message = message[:jobErrorLength]
+		}
+		update["status"] = entity.JobFailed
+		update["error"] = message
+	}
+	return r.db.Debug().Model(&entity.Job{}).Where("id = ? AND status = ?", id, entity.JobRunning).Updates(update).Error
+}
+
+//GetPendingJobs returns the jobs waiting for a worker, oldest first
+func (r *JobRepo) GetPendingJobs() ([]entity.Job, error) {
+	var jobs []entity.Job
+	err := r.db.Debug().Where("status = ?", entity.JobPending).Order("id").Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *JobRepo) RequeueStaleJobs(startedBefore time.Time) error {
+	return r.db.Debug().Model(&entity.Job{}).
+		Where("status = ? AND started_at < ?", entity.JobRunning, startedBefore).
+		Update("status", entity.JobPending).Error
+}
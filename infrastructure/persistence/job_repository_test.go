@@ -0,0 +1,65 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestJob_Lifecycle(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewJobRepository(conn)
+
+	job, saveErr := repo.SaveJob(&entity.Job{Kind: entity.JobUserErasure, SubjectID: 1, RequestedBy: 1, Status: entity.JobSucceeded})
+	assert.Nil(t, saveErr)
+	//a new job always waits for a worker
+	assert.EqualValues(t, entity.JobPending, job.Status)
+
+	pending, err := repo.GetPendingJobs()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(pending))
+
+	claimed, err := repo.ClaimJob(job.ID)
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+	//the second worker loses the race
+	claimed, err = repo.ClaimJob(job.ID)
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+
+	assert.Nil(t, repo.FinishJob(job.ID, errors.New("storage unavailable")))
+	got, err := repo.GetJob(job.ID)
+	assert.Nil(t, err)
+	assert.EqualValues(t, entity.JobFailed, got.Status)
+	assert.EqualValues(t, "storage unavailable", got.Error)
+	assert.EqualValues(t, 1, got.Attempts)
+	assert.NotNil(t, got.FinishedAt)
+
+	_, err = repo.GetJob(404)
+	assert.NotNil(t, err)
+}
+
+func TestRequeueStaleJobs(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewJobRepository(conn)
+	job, _ := repo.SaveJob(&entity.Job{Kind: entity.JobUserErasure, SubjectID: 1, RequestedBy: 1})
+	claimed, _ := repo.ClaimJob(job.ID)
+	assert.True(t, claimed)
+
+	//a job that just started is left alone
+	assert.Nil(t, repo.RequeueStaleJobs(time.Now().Add(-time.Hour)))
+	got, _ := repo.GetJob(job.ID)
+	assert.EqualValues(t, entity.JobRunning, got.Status)
+
+	assert.Nil(t, repo.RequeueStaleJobs(time.Now().Add(time.Minute)))
+	got, _ = repo.GetJob(job.ID)
+	assert.EqualValues(t, entity.JobPending, got.Status)
+}
@@ -0,0 +1,88 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+type ObjectRefRepo struct {
+	db *gorm.DB
+}
+
+func NewObjectRefRepository(db *gorm.DB) *ObjectRefRepo {
+	return &ObjectRefRepo{db}
+}
+
+//ObjectRefRepo implements the repository.ObjectRefRepository interface
+var _ repository.ObjectRefRepository = &ObjectRefRepo{}
+
+//keyColumn is the quoted name of the key column, key is a reserved word in MySQL
+func keyColumn(db *gorm.DB) string {
+	return db.Dialect().Quote("key")
+}
+
+func (r *ObjectRefRepo) RetainObject(key string) error {
+	//the update and the insert can both lose a race with another upload of the same content, the second try of the update cannot
+	for attempt := 0; ; attempt++ {
+		result := r.db.Debug().Model(&entity.ObjectRef{}).Where(keyColumn(r.db)+" = ?", key).
+			UpdateColumns(map[string]interface{}{"refs": gorm.Expr("refs + 1"), "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+		err := r.db.Debug().Create(&entity.ObjectRef{Key: key, Refs: 1, CreatedAt: time.Now(), UpdatedAt: time.Now()}).Error
+		if err == nil || attempt > 0 {
+			return err
+		}
+	}
+}
+
+//ReleaseObject runs remove before the transaction that forgets the last reference commits. The row stays locked until
+//the object is gone, so a RetainObject of the same content waits for it, finds no reference and stores the object again.
+func (r *ObjectRefRepo) ReleaseObject(key string, remove func() error) (bool, error) {
+	last := false
+	var removeErr error
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Debug().Model(&entity.ObjectRef{}).Where(keyColumn(tx)+" = ? AND refs > 0", key).
+			UpdateColumns(map[string]interface{}{"refs": gorm.Expr("refs - 1"), "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			result = tx.Debug().Where(keyColumn(tx)+" = ? AND refs <= 0", key).Delete(&entity.ObjectRef{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil
+			}
+		}
+		//nothing counted the references of this object, it belongs to whoever releases it. Such objects are not named
+		//after their content, nothing retains them again.
+		last = true
+		//the reference is forgotten even when the object stays, the garbage collector deletes it then
+		removeErr = remove()
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return last, removeErr
+}
+
+func (r *ObjectRefRepo) GetObjectRefs() ([]entity.ObjectRef, error) {
+	var refs []entity.ObjectRef
+	err := r.db.Debug().Order(keyColumn(r.db)).Find(&refs).Error
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func (r *ObjectRefRepo) DeleteObjectRef(key string) error {
+	return r.db.Debug().Where(keyColumn(r.db)+" = ?", key).Delete(&entity.ObjectRef{}).Error
+}
@@ -0,0 +1,85 @@
+package persistence
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestReleaseObject_LastReference(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewObjectRefRepository(conn)
+	removed := 0
+	remove := func() error {
+		removed++
+		return nil
+	}
+
+	assert.Nil(t, repo.RetainObject("images/abc.png"))
+	assert.Nil(t, repo.RetainObject("images/abc.png"))
+
+	last, err := repo.ReleaseObject("images/abc.png", remove)
+	assert.Nil(t, err)
+	assert.False(t, last)
+	assert.EqualValues(t, 0, removed)
+	last, err = repo.ReleaseObject("images/abc.png", remove)
+	assert.Nil(t, err)
+	assert.True(t, last)
+	assert.EqualValues(t, 1, removed)
+
+	//the count starts over when the content is uploaded again
+	assert.Nil(t, repo.RetainObject("images/abc.png"))
+	last, err = repo.ReleaseObject("images/abc.png", remove)
+	assert.Nil(t, err)
+	assert.True(t, last)
+	assert.EqualValues(t, 2, removed)
+
+	//objects stored before references were counted are released by their only user
+	last, err = repo.ReleaseObject("0b8f0b3c-legacy.png", remove)
+	assert.Nil(t, err)
+	assert.True(t, last)
+	assert.EqualValues(t, 3, removed)
+}
+
+func TestReleaseObject_RemoveFails(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewObjectRefRepository(conn)
+	assert.Nil(t, repo.RetainObject("images/abc.png"))
+
+	last, err := repo.ReleaseObject("images/abc.png", func() error {
+		return errors.New("storage unavailable")
+	})
+	assert.True(t, last)
+	assert.EqualValues(t, "storage unavailable", err.Error())
+	//the reference is gone all the same, the garbage collector deletes the object
+	refs, err := repo.GetObjectRefs()
+	assert.Nil(t, err)
+	assert.Empty(t, refs)
+}
+
+func TestDeleteObjectRef(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewObjectRefRepository(conn)
+	assert.Nil(t, repo.RetainObject("images/abc.png"))
+	assert.Nil(t, repo.RetainObject("images/def.png"))
+
+	refs, err := repo.GetObjectRefs()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, len(refs))
+	assert.EqualValues(t, "images/abc.png", refs[0].Key)
+	assert.EqualValues(t, 1, refs[0].Refs)
+
+	assert.Nil(t, repo.DeleteObjectRef("images/abc.png"))
+	refs, err = repo.GetObjectRefs()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(refs))
+}
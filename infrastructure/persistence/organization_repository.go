@@ -0,0 +1,289 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
+
+type OrganizationRepo struct {
+	db *gorm.DB
+}
+
+func NewOrganizationRepository(db *gorm.DB) *OrganizationRepo {
+	return &OrganizationRepo{db}
+}
+
+//OrganizationRepo implements the repository.OrganizationRepository interface
+var _ repository.OrganizationRepository = &OrganizationRepo{}
+
+//errLastOwner aborts a transaction that would leave an organization without an owner
+var errLastOwner = errors.New("last owner")
+
+func (r *OrganizationRepo) SaveOrganization(org *entity.Organization, ownerId uint64) (*entity.Organization, map[string]string) {
+	dbErr := map[string]string{}
+	err := r.db.Debug().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&entity.Membership{OrganizationID: org.ID, UserID: ownerId, Role: entity.OrgRoleOwner, CreatedAt: time.Now()}).Error
+	})
+	if err != nil {
+		dbErr["db_error"] = "database error"
+		return nil, dbErr
+	}
+	return org, nil
+}
+
+func (r *OrganizationRepo) GetOrganization(id uint64) (*entity.Organization, error) {
+	var org entity.Organization
+	err := r.db.Debug().Where("id = ?", id).Take(&org).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New("organization not found")
+	}
+	if err != nil {
+		return nil, errors.New("database error, please try again")
+	}
+	return &org, nil
+}
+
+func (r *OrganizationRepo) GetOrganizationsByUser(userId uint64) ([]entity.Organization, error) {
+	var orgs []entity.Organization
+	err := r.db.Debug().
+		Joins("JOIN memberships ON memberships.organization_id = organizations.id").
+		Where("memberships.user_id = ?", userId).
+		Order("organizations.id").
+		Find(&orgs).Error
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+func (r *OrganizationRepo) GetMembership(orgId, userId uint64) (*entity.Membership, error) {
+	var membership entity.Membership
+	err := r.db.Debug().Where("organization_id = ? AND user_id = ?", orgId, userId).Take(&membership).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New("membership not found")
+	}
+	if err != nil {
+		return nil, errors.New("database error, please try again")
+	}
+	return &membership, nil
+}
+
+//GetMemberships returns the organizations a user belongs to, oldest organization first
+func (r *OrganizationRepo) GetMemberships(userId uint64) ([]entity.Membership, error) {
+	var memberships []entity.Membership
+	err := r.db.Debug().Where("user_id = ?", userId).Order("organization_id").Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (r *OrganizationRepo) GetMembers(orgId uint64) ([]entity.Membership, error) {
+	var memberships []entity.Membership
+	err := r.db.Debug().Where("organization_id = ?", orgId).Order("id").Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+//UpdateMemberRole refuses to demote the last owner, an organization always keeps one
+func (r *OrganizationRepo) UpdateMemberRole(orgId, userId uint64, role string) map[string]string {
+	dbErr := map[string]string{}
+	if !entity.ValidOrgRole(role) {
+		dbErr["invalid_role"] = "role must be owner, editor or viewer"
+		return dbErr
+	}
+	err := r.db.Debug().Transaction(func(tx *gorm.DB) error {
+		membership, err := lockMembership(tx, orgId, userId)
+		if err != nil {
+			return err
+		}
+		if membership.Role == entity.OrgRoleOwner && role != entity.OrgRoleOwner {
+			if err := ensureAnotherOwner(tx, orgId); err != nil {
+				return err
+			}
+		}
+		return tx.Model(membership).Update("role", role).Error
+	})
+	return membershipError(err)
+}
+
+//RemoveMember refuses to remove the last owner, an organization always keeps one
+func (r *OrganizationRepo) RemoveMember(orgId, userId uint64) map[string]string {
+	err := r.db.Debug().Transaction(func(tx *gorm.DB) error {
+		membership, err := lockMembership(tx, orgId, userId)
+		if err != nil {
+			return err
+		}
+		if membership.Role == entity.OrgRoleOwner {
+			if err := ensureAnotherOwner(tx, orgId); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(membership).Error
+	})
+	return membershipError(err)
+}
+
+func (r *OrganizationRepo) RemoveUserMemberships(userId uint64) map[string]string {
+	err := r.db.Debug().Transaction(func(tx *gorm.DB) error {
+		var memberships []entity.Membership
+		err := tx.Set("gorm:query_option", forUpdate(tx)).Where("user_id = ?", userId).Find(&memberships).Error
+		if err != nil {
+			return err
+		}
+		for i := range memberships {
+			membership := &memberships[i]
+			if membership.Role == entity.OrgRoleOwner {
+				if err := handOver(tx, membership); err != nil {
+					return err
+				}
+			}
+			if err := tx.Delete(membership).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return membershipError(err)
+}
+
+//handOver keeps the organization of a leaving owner usable: when no owner would remain the oldest editor, or else the oldest member, is promoted.
+//An organization nobody else belongs to is deleted.
+func handOver(tx *gorm.DB, leaving *entity.Membership) error {
+	err := ensureAnotherOwner(tx, leaving.OrganizationID)
+	if err != errLastOwner {
+		return err
+	}
+	var successor entity.Membership
+	err = tx.Where("organization_id = ? AND user_id <> ?", leaving.OrganizationID, leaving.UserID).Order(gorm.Expr("CASE WHEN role = ? THEN 0 ELSE 1 END, id", entity.OrgRoleEditor)).Take(&successor).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return tx.Where("id = ?", leaving.OrganizationID).Delete(&entity.Organization{}).Error
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&successor).Update("role", entity.OrgRoleOwner).Error
+}
+
+func lockMembership(tx *gorm.DB, orgId, userId uint64) (*entity.Membership, error) {
+	var membership entity.Membership
+	err := tx.Set("gorm:query_option", forUpdate(tx)).Where("organization_id = ? AND user_id = ?", orgId, userId).Take(&membership).Error
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+func ensureAnotherOwner(tx *gorm.DB, orgId uint64) error {
+	var owners int
+	err := tx.Model(&entity.Membership{}).Where("organization_id = ? AND role = ?", orgId, entity.OrgRoleOwner).Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return errLastOwner
+	}
+	return nil
+}
+
+//forUpdate locks the selected rows where the database supports it, so two owners cannot demote each other at the same time
+func forUpdate(db *gorm.DB) string {
+	if db.Dialect().GetName() == "postgres" {
+		return "FOR UPDATE"
+	}
+	return ""
+}
+
+func membershipError(err error) map[string]string {
+	if err == nil {
+		return nil
+	}
+	dbErr := map[string]string{}
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		dbErr["no_member"] = "member not found"
+	case err == errLastOwner:
+		dbErr["last_owner"] = "an organization needs at least one owner"
+	default:
+		dbErr["db_error"] = "database error"
+	}
+	return dbErr
+}
+
+func (r *OrganizationRepo) SaveInvitation(invitation *entity.Invitation) (*entity.Invitation, map[string]string) {
+	dbErr := map[string]string{}
+	if !entity.ValidOrgRole(invitation.Role) {
+		dbErr["invalid_role"] = "role must be owner, editor or viewer"
+		return nil, dbErr
+	}
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+	err := r.db.Debug().Create(invitation).Error
+	if err != nil {
+		dbErr["db_error"] = "database error"
+		return nil, dbErr
+	}
+	return invitation, nil
+}
+
+func (r *OrganizationRepo) GetInvitationByToken(token string) (*entity.Invitation, error) {
+	var invitation entity.Invitation
+	err := r.db.Debug().Where("token = ?", token).Take(&invitation).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New("invitation not found")
+	}
+	if err != nil {
+		return nil, errors.New("database error, please try again")
+	}
+	return &invitation, nil
+}
+
+//AcceptInvitation marks the invitation accepted only if nobody did so first, so an invitation adds at most one member
+func (r *OrganizationRepo) AcceptInvitation(token string, userId uint64) (*entity.Membership, map[string]string) {
+	dbErr := map[string]string{}
+	var membership *entity.Membership
+	err := r.db.Debug().Transaction(func(tx *gorm.DB) error {
+		var invitation entity.Invitation
+		if err := tx.Where("token = ?", token).Take(&invitation).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		result := tx.Model(&entity.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND expires_at > ?", invitation.ID, now).
+			Update("accepted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+		var existing entity.Membership
+		err := tx.Where("organization_id = ? AND user_id = ?", invitation.OrganizationID, userId).Take(&existing).Error
+		if err == nil {
+			membership = &existing
+			return nil
+		}
+		if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		membership = &entity.Membership{OrganizationID: invitation.OrganizationID, UserID: userId, Role: invitation.Role, CreatedAt: now}
+		return tx.Create(membership).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		dbErr["invalid_invitation"] = "invitation is invalid, expired or already used"
+		return nil, dbErr
+	}
+	if err != nil {
+		dbErr["db_error"] = "database error"
+		return nil, dbErr
+	}
+	return membership, nil
+}
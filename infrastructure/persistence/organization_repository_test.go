@@ -0,0 +1,153 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSaveOrganization_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewOrganizationRepository(conn)
+
+	org, saveErr := repo.SaveOrganization(&entity.Organization{Name: "Acme"}, 1)
+	assert.Nil(t, saveErr)
+	assert.EqualValues(t, "Acme", org.Name)
+
+	membership, err := repo.GetMembership(org.ID, 1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, entity.OrgRoleOwner, membership.Role)
+
+	orgs, err := repo.GetOrganizationsByUser(1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(orgs))
+
+	_, err = repo.GetMembership(org.ID, 2)
+	assert.NotNil(t, err)
+}
+
+func TestOrganization_LastOwner(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewOrganizationRepository(conn)
+	org, _ := repo.SaveOrganization(&entity.Organization{Name: "Acme"}, 1)
+	lastOwner := map[string]string{"last_owner": "an organization needs at least one owner"}
+
+	assert.EqualValues(t, lastOwner, repo.UpdateMemberRole(org.ID, 1, entity.OrgRoleEditor))
+	assert.EqualValues(t, lastOwner, repo.RemoveMember(org.ID, 1))
+	assert.EqualValues(t, map[string]string{"no_member": "member not found"}, repo.RemoveMember(org.ID, 2))
+
+	//with a second owner the first one can step down
+	if err := conn.Create(&entity.Membership{OrganizationID: org.ID, UserID: 2, Role: entity.OrgRoleOwner}).Error; err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	assert.Nil(t, repo.UpdateMemberRole(org.ID, 1, entity.OrgRoleViewer))
+	assert.EqualValues(t, lastOwner, repo.RemoveMember(org.ID, 2))
+	assert.Nil(t, repo.RemoveMember(org.ID, 1))
+}
+
+func TestAcceptInvitation_Once(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewOrganizationRepository(conn)
+	org, _ := repo.SaveOrganization(&entity.Organization{Name: "Acme"}, 1)
+
+	invitation, saveErr := repo.SaveInvitation(&entity.Invitation{
+		OrganizationID: org.ID,
+		Email:          " Izzah@Gmail.com",
+		Role:           entity.OrgRoleEditor,
+		InvitedBy:      1,
+		Token:          "invitation-token",
+		ExpiresAt:      time.Now().Add(time.Hour),
+	})
+	assert.Nil(t, saveErr)
+	assert.EqualValues(t, "izzah@gmail.com", invitation.Email)
+
+	membership, acceptErr := repo.AcceptInvitation("invitation-token", 2)
+	assert.Nil(t, acceptErr)
+	assert.EqualValues(t, entity.OrgRoleEditor, membership.Role)
+	assert.EqualValues(t, org.ID, membership.OrganizationID)
+
+	_, acceptErr = repo.AcceptInvitation("invitation-token", 3)
+	assert.EqualValues(t, map[string]string{"invalid_invitation": "invitation is invalid, expired or already used"}, acceptErr)
+
+	_, saveErr = repo.SaveInvitation(&entity.Invitation{OrganizationID: org.ID, Email: "x@gmail.com", Role: "admin", Token: "other"})
+	assert.EqualValues(t, map[string]string{"invalid_role": "role must be owner, editor or viewer"}, saveErr)
+}
+
+func TestMigrateProductOwnership(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	if _, err := seedUser(conn); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	//a product saved before organizations existed
+	if err := conn.Create(&entity.Product{ID: 1, Title: "old product", Description: "old desc", UserID: 1}).Error; err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	assert.Nil(t, migrateProductOwnership(conn))
+
+	repo := NewOrganizationRepository(conn)
+	memberships, err := repo.GetMemberships(1)
+	assert.Nil(t, err)
+	if assert.EqualValues(t, 1, len(memberships)) {
+		assert.EqualValues(t, entity.OrgRoleOwner, memberships[0].Role)
+		org, _ := repo.GetOrganization(memberships[0].OrganizationID)
+		assert.EqualValues(t, "Sammi Dev", org.Name)
+
+		product, err := NewProductRepository(conn).GetProduct(memberships[0].OrganizationID, 1)
+		assert.Nil(t, err)
+		assert.EqualValues(t, "old product", product.Title)
+	}
+	//running it again changes nothing
+	assert.Nil(t, migrateProductOwnership(conn))
+	memberships, _ = repo.GetMemberships(1)
+	assert.EqualValues(t, 1, len(memberships))
+}
+
+func TestRemoveUserMemberships(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewOrganizationRepository(conn)
+	shared, _ := repo.SaveOrganization(&entity.Organization{Name: "Acme"}, 1)
+	alone, _ := repo.SaveOrganization(&entity.Organization{Name: "Solo"}, 1)
+	joined, _ := repo.SaveOrganization(&entity.Organization{Name: "Other"}, 3)
+	for _, m := range []entity.Membership{
+		{OrganizationID: shared.ID, UserID: 2, Role: entity.OrgRoleViewer},
+		{OrganizationID: shared.ID, UserID: 3, Role: entity.OrgRoleEditor},
+		{OrganizationID: joined.ID, UserID: 1, Role: entity.OrgRoleEditor},
+	} {
+		if err := conn.Create(&m).Error; err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+	}
+
+	assert.Nil(t, repo.RemoveUserMemberships(1))
+
+	memberships, err := repo.GetMemberships(1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, len(memberships))
+	//the editor took over rather than the older viewer
+	membership, err := repo.GetMembership(shared.ID, 3)
+	assert.Nil(t, err)
+	assert.EqualValues(t, entity.OrgRoleOwner, membership.Role)
+	membership, _ = repo.GetMembership(shared.ID, 2)
+	assert.EqualValues(t, entity.OrgRoleViewer, membership.Role)
+	//nobody was left in Solo
+	_, err = repo.GetOrganization(alone.ID)
+	assert.NotNil(t, err)
+	membership, _ = repo.GetMembership(joined.ID, 3)
+	assert.EqualValues(t, entity.OrgRoleOwner, membership.Role)
+}
@@ -5,8 +5,8 @@ import (
 	"DDD/domain/repository"
 	"errors"
 	"github.com/jinzhu/gorm"
-	"os"
 	"strings"
+	"time"
 )
 
 type ProductRepo struct {
@@ -22,8 +22,10 @@ var _ repository.ProductRepository = &ProductRepo{}
 
 func (r *ProductRepo) SaveProduct(product *entity.Product) (*entity.Product, map[string]string) {
 	dbErr := map[string]string{}
-	product.ProductImage = os.Getenv("DO_SPACES_URL") + product.ProductImage
-
+	if product.OrganizationID == 0 {
+		dbErr["no_organization"] = "a product must belong to an organization"
+		return nil, dbErr
+	}
 	err := r.db.Debug().Create(&product).Error
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "Duplicate") {
@@ -36,36 +38,44 @@ func (r *ProductRepo) SaveProduct(product *entity.Product) (*entity.Product, map
 	return product, nil
 }
 
-func (r *ProductRepo) GetProduct(id uint64) (*entity.Product, error) {
+func (r *ProductRepo) GetProduct(orgId, id uint64) (*entity.Product, error) {
 	var product entity.Product
-	err := r.db.Debug().Where("id = ?", id).Take(&product).Error
-	if err != nil {
-		return nil, errors.New("database error, please try again")
-	}
+	err := r.db.Debug().Where("id = ? AND organization_id = ?", id, orgId).Take(&product).Error
 	if gorm.IsRecordNotFoundError(err) {
 		return nil, errors.New("product not found")
 	}
+	if err != nil {
+		return nil, errors.New("database error, please try again")
+	}
 	return &product, nil
 }
 
-func (r *ProductRepo) GetAllProduct() ([]entity.Product, error) {
+func (r *ProductRepo) GetAllProduct(orgId uint64) ([]entity.Product, error) {
 	var products []entity.Product
-	err := r.db.Debug().Limit(100).Order("created_at desc").Find(&products).Error
+	err := r.db.Debug().Where("organization_id = ?", orgId).Limit(100).Order("created_at desc").Find(&products).Error
 	if err != nil {
 		return nil, err
 	}
-	if gorm.IsRecordNotFoundError(err) {
-		return nil, errors.New("user not found")
-	}
 	return products, nil
 }
 
+//UpdateProduct only touches the row when it belongs to product.OrganizationID, the product cannot be moved to another organization
 func (r *ProductRepo) UpdateProduct(product *entity.Product) (*entity.Product, map[string]string) {
 	dbErr := map[string]string{}
-	err := r.db.Debug().Save(&product).Error
-	if err != nil {
+	//the BeforeSave hook does not run for column updates
+	product.BeforeSave()
+	result := r.db.Debug().Model(&entity.Product{}).
+		Where("id = ? AND organization_id = ?", product.ID, product.OrganizationID).
+		Updates(map[string]interface{}{
+			"title":          product.Title,
+			"description":    product.Description,
+			"product_image":  product.ProductImage,
+			"image_variants": product.ImageVariants,
+			"updated_at":     product.UpdatedAt,
+		})
+	if result.Error != nil {
 		//since our title is unique
-		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "Duplicate") {
+		if strings.Contains(result.Error.Error(), "duplicate") || strings.Contains(result.Error.Error(), "Duplicate") {
 			dbErr["unique_title"] = "title already taken"
 			return nil, dbErr
 		}
@@ -73,14 +83,65 @@ func (r *ProductRepo) UpdateProduct(product *entity.Product) (*entity.Product, m
 		dbErr["db_error"] = "database error"
 		return nil, dbErr
 	}
+	if result.RowsAffected == 0 {
+		dbErr["no_product"] = "product not found"
+		return nil, dbErr
+	}
 	return product, nil
 }
 
-func (r *ProductRepo) DeleteProduct(id uint64) error {
+func (r *ProductRepo) DeleteProduct(orgId, id uint64) error {
 	var product entity.Product
-	err := r.db.Debug().Where("id = ?", id).Delete(&product).Error
+	result := r.db.Debug().Where("id = ? AND organization_id = ?", id, orgId).Delete(&product)
+	if result.Error != nil {
+		return errors.New("database error, please try again")
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("product not found")
+	}
+	return nil
+}
+
+func (r *ProductRepo) GetProductsByUser(userId uint64) ([]entity.Product, error) {
+	var products []entity.Product
+	err := r.db.Debug().Where("user_id = ?", userId).Order("id").Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (r *ProductRepo) ReassignProducts(orgId, fromUserId, toUserId uint64) error {
+	err := r.db.Debug().Model(&entity.Product{}).
+		Where("organization_id = ? AND user_id = ?", orgId, fromUserId).
+		Updates(map[string]interface{}{"user_id": toUserId, "updated_at": time.Now()}).Error
 	if err != nil {
 		return errors.New("database error, please try again")
 	}
 	return nil
 }
+
+func (r *ProductRepo) SetImageVariants(productId uint64, image string, variants entity.ImageVariants) map[string]string {
+	dbErr := map[string]string{}
+	result := r.db.Debug().Model(&entity.Product{}).
+		Where("id = ? AND product_image = ?", productId, image).
+		UpdateColumn("image_variants", variants)
+	if result.Error != nil {
+		dbErr["db_error"] = "database error"
+		return dbErr
+	}
+	if result.RowsAffected == 0 {
+		dbErr["no_product"] = "product not found"
+		return dbErr
+	}
+	return nil
+}
+
+func (r *ProductRepo) GetProductImages() ([]entity.Product, error) {
+	var products []entity.Product
+	err := r.db.Debug().Select("id, product_image, image_variants").Order("id").Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
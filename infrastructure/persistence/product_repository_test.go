@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"DDD/domain/entity"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -15,6 +16,7 @@ func TestSaveProduct_Success(t *testing.T) {
 	product.Title = "product title"
 	product.Description = "product description"
 	product.UserID = 1
+	product.OrganizationID = 1
 
 	repo := NewProductRepository(conn)
 
@@ -39,6 +41,7 @@ func TestSaveProduct_Failure(t *testing.T) {
 	product.Title = "product title"
 	product.Description = "product desc"
 	product.UserID = 1
+	product.OrganizationID = 1
 
 	repo := NewProductRepository(conn)
 	f, saveErr := repo.SaveProduct(&product)
@@ -61,7 +64,7 @@ func TestGetProduct_Success(t *testing.T) {
 	}
 	repo := NewProductRepository(conn)
 
-	f, saveErr := repo.GetProduct(product.ID)
+	f, saveErr := repo.GetProduct(1, product.ID)
 
 	assert.Nil(t, saveErr)
 	assert.EqualValues(t, f.Title, product.Title)
@@ -79,7 +82,7 @@ func TestGetAllProduct_Success(t *testing.T) {
 		t.Fatalf("want non error, got %#v", err)
 	}
 	repo := NewProductRepository(conn)
-	products, getErr := repo.GetAllProduct()
+	products, getErr := repo.GetAllProduct(1)
 
 	assert.Nil(t, getErr)
 	assert.EqualValues(t, len(products), 2)
@@ -152,7 +155,163 @@ func TestDeleteProduct_Success(t *testing.T) {
 	}
 	repo := NewProductRepository(conn)
 
-	deleteErr := repo.DeleteProduct(product.ID)
+	deleteErr := repo.DeleteProduct(1, product.ID)
 
 	assert.Nil(t, deleteErr)
 }
+
+//one organization can never read or modify the products of another
+func TestProduct_TenantScoped(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	product, err := seedProduct(conn)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewProductRepository(conn)
+
+	f, getErr := repo.GetProduct(2, product.ID)
+	assert.Nil(t, f)
+	assert.NotNil(t, getErr)
+
+	products, getErr := repo.GetAllProduct(2)
+	assert.Nil(t, getErr)
+	assert.EqualValues(t, 0, len(products))
+
+	foreign := *product
+	foreign.OrganizationID = 2
+	foreign.Title = "taken over"
+	_, updateErr := repo.UpdateProduct(&foreign)
+	assert.EqualValues(t, map[string]string{"no_product": "product not found"}, updateErr)
+
+	assert.NotNil(t, repo.DeleteProduct(2, product.ID))
+
+	//titles only have to be unique inside an organization
+	other := entity.Product{Title: product.Title, Description: "same title", UserID: 2, OrganizationID: 2}
+	_, saveErr := repo.SaveProduct(&other)
+	assert.Nil(t, saveErr)
+
+	f, getErr = repo.GetProduct(1, product.ID)
+	assert.Nil(t, getErr)
+	assert.EqualValues(t, "product title", f.Title)
+}
+
+func TestReassignProducts_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	_, err = seedProducts(conn)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewProductRepository(conn)
+	other := entity.Product{Title: "elsewhere", Description: "other organization", UserID: 1, OrganizationID: 2}
+	_, saveErr := repo.SaveProduct(&other)
+	assert.Nil(t, saveErr)
+
+	products, err := repo.GetProductsByUser(1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, len(products))
+
+	//only the products of the given organization change hands
+	assert.Nil(t, repo.ReassignProducts(1, 1, 2))
+	products, err = repo.GetProductsByUser(1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(products))
+	assert.EqualValues(t, "elsewhere", products[0].Title)
+
+	products, err = repo.GetProductsByUser(2)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, len(products))
+}
+
+func TestSetImageVariants_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	product, err := seedProduct(conn)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	if err := conn.Model(product).UpdateColumn("product_image", "photo.png").Error; err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewProductRepository(conn)
+	variants := entity.ImageVariants{
+		{Width: 480, Format: "jpg", Key: "photo/480.jpg"},
+		{Width: 150, Format: "jpg", Key: "photo/150.jpg"},
+		{Width: 150, Format: "webp", Key: "photo/150.webp"},
+	}
+	//variants of an image the product no longer has are refused
+	assert.EqualValues(t, map[string]string{"no_product": "product not found"}, repo.SetImageVariants(product.ID, "older.png", variants))
+	assert.Nil(t, repo.SetImageVariants(product.ID, "photo.png", variants))
+
+	f, err := repo.GetProduct(product.OrganizationID, product.ID)
+	assert.Nil(t, err)
+	assert.EqualValues(t, variants, f.ImageVariants)
+	assert.EqualValues(t, map[string]string{
+		"jpg":  "photo/150.jpg 150w, photo/480.jpg 480w",
+		"webp": "photo/150.webp 150w",
+	}, f.ImageVariants.Srcset())
+
+	//the garbage collector sees the image and its variants
+	images, err := repo.GetProductImages()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(images))
+	assert.EqualValues(t, "photo.png", images[0].ProductImage)
+	assert.EqualValues(t, variants, images[0].ImageVariants)
+}
+
+func TestNormalizeProductImages(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	images := []string{
+		"https://spaces.example.com/images/a.png",
+		//the update handler added the prefix to an image that had it already
+		"https://spaces.example.com/https://spaces.example.com/images/b.png",
+		"https://cdn.example.com/images/c.png",
+		"images/d.png",
+		"",
+	}
+	for i, image := range images {
+		product := &entity.Product{Title: fmt.Sprintf("product %d", i), Description: "desc", UserID: 1, OrganizationID: 1, ProductImage: image}
+		if err := conn.Create(product).Error; err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+	}
+	//a variants job enqueued with the URL, and a job of another kind whose target is not an image
+	jobs := []*entity.Job{
+		{Kind: entity.JobImageVariants, SubjectID: 1, Target: "https://spaces.example.com/images/a.png", Status: entity.JobPending},
+		{Kind: entity.JobStorageGC, Target: "https://cdn.example.com/report", Status: entity.JobPending},
+	}
+	for _, job := range jobs {
+		if err := conn.Create(job).Error; err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+	}
+	assert.Nil(t, normalizeProductImages(conn, "https://spaces.example.com/", "https://cdn.example.com/", ""))
+
+	var variants, gc entity.Job
+	assert.Nil(t, conn.Where("id = ?", jobs[0].ID).Take(&variants).Error)
+	assert.EqualValues(t, "images/a.png", variants.Target)
+	assert.Nil(t, conn.Where("id = ?", jobs[1].ID).Take(&gc).Error)
+	assert.EqualValues(t, "https://cdn.example.com/report", gc.Target)
+
+	products, err := NewProductRepository(conn).GetAllProduct(1)
+	assert.Nil(t, err)
+	var keys []string
+	for _, product := range products {
+		keys = append(keys, product.ProductImage)
+	}
+	assert.ElementsMatch(t, []string{"images/a.png", "images/b.png", "images/c.png", "images/d.png", ""}, keys)
+	//running it again changes nothing
+	assert.Nil(t, normalizeProductImages(conn, "https://spaces.example.com/", "https://cdn.example.com/"))
+	products, _ = NewProductRepository(conn).GetAllProduct(1)
+	assert.EqualValues(t, len(images), len(products))
+}
@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"DDD/domain/entity"
+	"DDD/infrastructure/security"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	"github.com/joho/godotenv"
@@ -47,13 +48,20 @@ func LocalDatabase() (*gorm.DB, error) {
 		log.Println("CONNECTED TO: ", dbdriver)
 	}
 
-	err = conn.DropTableIfExists(&entity.User{}, &entity.Product{}).Error
+	err = conn.DropTableIfExists(&entity.User{}, &entity.Product{}, &entity.AuditEvent{}, &entity.Organization{}, &entity.Membership{}, &entity.Invitation{}, &entity.Job{}, &entity.Upload{}, &entity.ObjectRef{}).Error
 	if err != nil {
 		return nil, err
 	}
 	err = conn.Debug().AutoMigrate(
 		entity.User{},
 		entity.Product{},
+		entity.AuditEvent{},
+		entity.Organization{},
+		entity.Membership{},
+		entity.Invitation{},
+		entity.Job{},
+		entity.Upload{},
+		entity.ObjectRef{},
 	).Error
 	if err != nil {
 		return nil, err
@@ -61,13 +69,22 @@ func LocalDatabase() (*gorm.DB, error) {
 	return conn, nil
 }
 
+//hashed stands in for UserRepo.SaveUser, which is the only place passwords get hashed
+func hashed(password string) string {
+	h, err := security.Hash(password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return h
+}
+
 func seedUser(db *gorm.DB) (*entity.User, error) {
 	user := &entity.User{
 		ID:        1,
 		FirstName: "Sammi",
 		LastName:  "Dev",
 		Email:     "sammidev@gmail.com",
-		Password:  "sammidev",
+		Password:  hashed("sammidev"),
 		DeletedAt: nil,
 	}
 	err := db.Create(&user).Error
@@ -84,7 +101,7 @@ func seedUsers(db *gorm.DB) ([]entity.User, error) {
 			FirstName: "Sammi",
 			LastName:  "Aldhi Yanto",
 			Email:     "sammidev@gmail.com",
-			Password:  "sammidev",
+			Password:  hashed("sammidev"),
 			DeletedAt: nil,
 		},
 		{
@@ -92,7 +109,7 @@ func seedUsers(db *gorm.DB) ([]entity.User, error) {
 			FirstName: "Rahmatul",
 			LastName:  "Izzah Annisa",
 			Email:     "izzaah@yahoo.com",
-			Password:  "izzaah",
+			Password:  hashed("izzaah"),
 			DeletedAt: nil,
 		},
 	}
@@ -111,6 +128,7 @@ func seedProduct(db *gorm.DB) (*entity.Product, error) {
 		Title:       "product title",
 		Description: "product desc",
 		UserID:      1,
+		OrganizationID: 1,
 	}
 	err := db.Create(&product).Error
 	if err != nil {
@@ -126,12 +144,14 @@ func seedProducts(db *gorm.DB) ([]entity.Product, error) {
 			Title:       "first product",
 			Description: "first desc",
 			UserID:      1,
+			OrganizationID: 1,
 		},
 		{
 			ID:          2,
 			Title:       "second product",
 			Description: "second desc",
 			UserID:      1,
+			OrganizationID: 1,
 		},
 	}
 	for _, v := range products {
@@ -0,0 +1,99 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"DDD/domain/repository"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+type UploadRepo struct {
+	db *gorm.DB
+}
+
+func NewUploadRepository(db *gorm.DB) *UploadRepo {
+	return &UploadRepo{db}
+}
+
+//UploadRepo implements the repository.UploadRepository interface
+var _ repository.UploadRepository = &UploadRepo{}
+
+func (r *UploadRepo) SaveUpload(upload *entity.Upload) (*entity.Upload, map[string]string) {
+	dbErr := map[string]string{}
+	upload.Status = entity.UploadPending
+	if upload.CreatedAt.IsZero() {
+		upload.CreatedAt = time.Now()
+	}
+	err := r.db.Debug().Create(upload).Error
+	if err != nil {
+		dbErr["db_error"] = "database error"
+		return nil, dbErr
+	}
+	return upload, nil
+}
+
+func (r *UploadRepo) GetUpload(id uint64) (*entity.Upload, error) {
+	var upload entity.Upload
+	err := r.db.Debug().Where("id = ?", id).Take(&upload).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New("upload not found")
+	}
+	if err != nil {
+		return nil, errors.New("database error, please try again")
+	}
+	return &upload, nil
+}
+
+func (r *UploadRepo) GetUploadByKey(key string) (*entity.Upload, error) {
+	var upload entity.Upload
+	err := r.db.Debug().Where(keyColumn(r.db)+" = ?", key).Take(&upload).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New("upload not found")
+	}
+	if err != nil {
+		return nil, errors.New("database error, please try again")
+	}
+	return &upload, nil
+}
+
+func (r *UploadRepo) ConfirmUpload(id uint64) map[string]string {
+	dbErr := map[string]string{}
+	result := r.db.Debug().Model(&entity.Upload{}).
+		Where("id = ? AND status = ?", id, entity.UploadPending).
+		Update("status", entity.UploadConfirmed)
+	if result.Error != nil {
+		dbErr["db_error"] = "database error"
+		return dbErr
+	}
+	if result.RowsAffected == 0 {
+		dbErr["no_upload"] = "upload not found or already used"
+		return dbErr
+	}
+	return nil
+}
+
+func (r *UploadRepo) RejectUpload(id uint64) map[string]string {
+	dbErr := map[string]string{}
+	result := r.db.Debug().Model(&entity.Upload{}).
+		Where("id = ? AND status = ?", id, entity.UploadPending).
+		Update("status", entity.UploadInfected)
+	if result.Error != nil {
+		dbErr["db_error"] = "database error"
+		return dbErr
+	}
+	if result.RowsAffected == 0 {
+		dbErr["no_upload"] = "upload not found or already used"
+		return dbErr
+	}
+	return nil
+}
+
+func (r *UploadRepo) GetPendingUploads() ([]entity.Upload, error) {
+	var uploads []entity.Upload
+	err := r.db.Debug().Where("status = ?", entity.UploadPending).Order("id").Find(&uploads).Error
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
@@ -0,0 +1,60 @@
+package persistence
+
+import (
+	"DDD/domain/entity"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestConfirmUpload_Once(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewUploadRepository(conn)
+
+	upload, saveErr := repo.SaveUpload(&entity.Upload{Key: "uploads/abc.png", UserID: 1, OrganizationID: 4, ContentType: "image/png", MaxBytes: 1024, ExpiresAt: time.Now().Add(time.Minute)})
+	assert.Nil(t, saveErr)
+	assert.EqualValues(t, entity.UploadPending, upload.Status)
+
+	pending, err := repo.GetPendingUploads()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(pending))
+
+	assert.Nil(t, repo.ConfirmUpload(upload.ID))
+	got, err := repo.GetUpload(upload.ID)
+	assert.Nil(t, err)
+	assert.EqualValues(t, entity.UploadConfirmed, got.Status)
+	//the upload URLs find theirs by key
+	got, err = repo.GetUploadByKey("uploads/abc.png")
+	assert.Nil(t, err)
+	assert.EqualValues(t, upload.ID, got.ID)
+	assert.EqualValues(t, entity.UploadConfirmed, got.Status)
+	_, err = repo.GetUploadByKey("uploads/other.png")
+	assert.NotNil(t, err)
+
+	//a confirmed upload cannot be attached again
+	assert.EqualValues(t, map[string]string{"no_upload": "upload not found or already used"}, repo.ConfirmUpload(upload.ID))
+	pending, err = repo.GetPendingUploads()
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestRejectUpload_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewUploadRepository(conn)
+
+	upload, saveErr := repo.SaveUpload(&entity.Upload{Key: "quarantine/abc.png", UserID: 1, OrganizationID: 4, ContentType: "image/png", MaxBytes: 1024, ExpiresAt: time.Now().Add(time.Minute)})
+	assert.Nil(t, saveErr)
+	assert.Nil(t, repo.RejectUpload(upload.ID))
+	got, err := repo.GetUpload(upload.ID)
+	assert.Nil(t, err)
+	assert.EqualValues(t, entity.UploadInfected, got.Status)
+
+	//an infected upload is never confirmed
+	assert.EqualValues(t, map[string]string{"no_upload": "upload not found or already used"}, repo.ConfirmUpload(upload.ID))
+}
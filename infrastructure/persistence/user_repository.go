@@ -5,9 +5,11 @@ import (
 	"DDD/domain/repository"
 	"DDD/infrastructure/security"
 	"errors"
+	"fmt"
 	"github.com/jinzhu/gorm"
-	"golang.org/x/crypto/bcrypt"
+	"log"
 	"strings"
+	"time"
 )
 
 type UserRepo struct {
@@ -20,9 +22,16 @@ func NewUserRepository(db *gorm.DB) *UserRepo {
 
 var _ repository.UserRepository = &UserRepo{}
 
+//SaveUser creates the user. user.Password is the plain password, it is hashed here and only here.
 func (r *UserRepo) SaveUser(user *entity.User) (*entity.User, map[string]string) {
 	dbErr := map[string]string{}
-	err := r.db.Debug().Create(&user).Error
+	hashed, err := security.Hash(user.Password)
+	if err != nil {
+		dbErr["hash_error"] = "cannot hash the password"
+		return nil, dbErr
+	}
+	user.Password = hashed
+	err = r.db.Debug().Create(&user).Error
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "Duplicate") {
 			dbErr["email_taken"] = "email already taken"
@@ -58,6 +67,18 @@ func (r *UserRepo) GetUsers() ([]entity.User, error) {
 	return users, nil
 }
 
+func (r *UserRepo) GetUserByEmail(email string) (*entity.User, error) {
+	var user entity.User
+	err := r.db.Debug().Where("email = ?", email).Take(&user).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (r *UserRepo) GetUserByEmailAndPassword(u *entity.User) (*entity.User, map[string]string) {
 	var user entity.User
 	dbErr := map[string]string{}
@@ -71,9 +92,86 @@ func (r *UserRepo) GetUserByEmailAndPassword(u *entity.User) (*entity.User, map[
 		return nil, dbErr
 	}
 	err = security.VerifyPassword(user.Password, u.Password)
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
+	if err != nil {
 		dbErr["incorrect_password"] = "incorrect password"
 		return nil, dbErr
 	}
+	//this is the only time we have the plain password, so upgrade hashes made with an older algorithm or weaker parameters now
+	if security.NeedsRehash(user.Password) {
+		if hashed, err := security.Hash(u.Password); err == nil {
+			err = r.db.Debug().Model(&user).UpdateColumn("password", hashed).Error
+			if err != nil {
+				log.Printf("cannot upgrade the password hash of user %d: %v", user.ID, err)
+			}
+		}
+	}
 	return &user, nil
 }
+
+//UpdatePassword replaces the password of a user. password is the plain password, it is hashed here.
+func (r *UserRepo) UpdatePassword(id uint64, password string) map[string]string {
+	dbErr := map[string]string{}
+	hashed, err := security.Hash(password)
+	if err != nil {
+		dbErr["hash_error"] = "cannot hash the password"
+		return dbErr
+	}
+	result := r.db.Debug().Model(&entity.User{}).Where("id = ?", id).Updates(map[string]interface{}{"password": hashed, "updated_at": time.Now()})
+	if result.Error != nil {
+		dbErr["db_error"] = "database error"
+		return dbErr
+	}
+	if result.RowsAffected == 0 {
+		dbErr["no_user"] = "user not found"
+		return dbErr
+	}
+	return nil
+}
+
+func (r *UserRepo) UpdateAvatar(id uint64, key string) map[string]string {
+	dbErr := map[string]string{}
+	result := r.db.Debug().Model(&entity.User{}).Where("id = ?", id).Updates(map[string]interface{}{"avatar": key, "updated_at": time.Now()})
+	if result.Error != nil {
+		dbErr["db_error"] = "database error"
+		return dbErr
+	}
+	if result.RowsAffected == 0 {
+		dbErr["no_user"] = "user not found"
+		return dbErr
+	}
+	return nil
+}
+
+//AnonymizeUser leaves no way to log in: the email cannot receive links and an empty password matches no hash
+func (r *UserRepo) AnonymizeUser(id uint64) map[string]string {
+	dbErr := map[string]string{}
+	now := time.Now()
+	result := r.db.Debug().Model(&entity.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"first_name": "Deleted",
+		"last_name":  "User",
+		"email":      fmt.Sprintf("deleted-%d@invalid", id),
+		"password":   "",
+		"role":       entity.RoleUser,
+		"avatar":     "",
+		"updated_at": now,
+		"deleted_at": now,
+	})
+	if result.Error != nil {
+		dbErr["db_error"] = "database error"
+		return dbErr
+	}
+	if result.RowsAffected == 0 {
+		dbErr["no_user"] = "user not found"
+		return dbErr
+	}
+	return nil
+}
+
+func (r *UserRepo) GetAvatars() ([]string, error) {
+	var avatars []string
+	err := r.db.Debug().Model(&entity.User{}).Where("avatar <> ''").Pluck("avatar", &avatars).Error
+	if err != nil {
+		return nil, err
+	}
+	return avatars, nil
+}
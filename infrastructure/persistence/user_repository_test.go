@@ -2,7 +2,10 @@ package persistence
 
 import (
 	"DDD/domain/entity"
+	"DDD/infrastructure/security"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
 	"testing"
 )
 
@@ -89,6 +92,26 @@ func TestGetUsers_Success(t *testing.T) {
 	assert.EqualValues(t, len(users), 2)
 }
 
+func TestGetUserByEmail_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	user, err := seedUser(conn)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewUserRepository(conn)
+	u, getErr := repo.GetUserByEmail(user.Email)
+
+	assert.Nil(t, getErr)
+	assert.EqualValues(t, u.ID, user.ID)
+
+	u, getErr = repo.GetUserByEmail("nobody@gmail.com")
+	assert.Nil(t, u)
+	assert.NotNil(t, getErr)
+}
+
 func TestGetUserByEmailAndPassword_Success(t *testing.T) {
 	conn, err := DBConn()
 	if err != nil {
@@ -109,4 +132,125 @@ func TestGetUserByEmailAndPassword_Success(t *testing.T) {
 	assert.Nil(t, getErr)
 	assert.EqualValues(t, u.Email, user.Email)
 	assert.NotEqual(t, u.Password, user.Password)
-}
\ No newline at end of file
+}
+func TestGetUserByEmailAndPassword_Failure(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	_, err = seedUser(conn)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	var user = &entity.User{
+		Email:    "sammidev@gmail.com",
+		Password: "wrong password",
+	}
+	repo := NewUserRepository(conn)
+	u, getErr := repo.GetUserByEmailAndPassword(user)
+
+	dbMsg := map[string]string{
+		"incorrect_password": "incorrect password",
+	}
+	assert.Nil(t, u)
+	assert.EqualValues(t, dbMsg, getErr)
+}
+
+//users created before argon2id have bcrypt hashes, logging in upgrades them
+func TestGetUserByEmailAndPassword_UpgradesHash(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	legacy, err := security.NewBcryptHasher(bcrypt.MinCost).Hash("sammidev")
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	seeded := &entity.User{ID: 1, FirstName: "Sammi", LastName: "Dev", Email: "sammidev@gmail.com", Password: legacy}
+	if err := conn.Create(seeded).Error; err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewUserRepository(conn)
+	_, getErr := repo.GetUserByEmailAndPassword(&entity.User{Email: "sammidev@gmail.com", Password: "sammidev"})
+	assert.Nil(t, getErr)
+
+	stored, err := repo.GetUser(1)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+	assert.Nil(t, security.VerifyPassword(stored.Password, "sammidev"))
+
+	//the upgraded hash keeps working
+	_, getErr = repo.GetUserByEmailAndPassword(&entity.User{Email: "sammidev@gmail.com", Password: "sammidev"})
+	assert.Nil(t, getErr)
+}
+
+func TestUpdatePassword_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	user, err := seedUser(conn)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewUserRepository(conn)
+	updateErr := repo.UpdatePassword(user.ID, "Correct4Horse")
+	assert.Nil(t, updateErr)
+
+	_, getErr := repo.GetUserByEmailAndPassword(&entity.User{Email: user.Email, Password: "Correct4Horse"})
+	assert.Nil(t, getErr)
+	_, getErr = repo.GetUserByEmailAndPassword(&entity.User{Email: user.Email, Password: "sammidev"})
+	assert.NotNil(t, getErr)
+
+	assert.EqualValues(t, map[string]string{"no_user": "user not found"}, repo.UpdatePassword(404, "Correct4Horse"))
+}
+
+func TestUpdateAvatar_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	user, err := seedUser(conn)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewUserRepository(conn)
+	assert.Nil(t, repo.UpdateAvatar(user.ID, "avatars/abc"))
+
+	u, err := repo.GetUser(user.ID)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "avatars/abc", u.Avatar)
+	assert.EqualValues(t, "avatars/abc/64.jpg", entity.AvatarKey(u.Avatar, 64))
+
+	avatars, err := repo.GetAvatars()
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"avatars/abc"}, avatars)
+}
+
+func TestAnonymizeUser_Success(t *testing.T) {
+	conn, err := DBConn()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	user, err := seedUser(conn)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	repo := NewUserRepository(conn)
+	assert.Nil(t, repo.AnonymizeUser(user.ID))
+
+	//the account is gone and its password no longer works
+	_, err = repo.GetUser(user.ID)
+	assert.NotNil(t, err)
+	_, loginErr := repo.GetUserByEmailAndPassword(&entity.User{Email: user.Email, Password: "sammidev"})
+	assert.NotNil(t, loginErr)
+
+	var row entity.User
+	assert.Nil(t, conn.Unscoped().Where("id = ?", user.ID).Take(&row).Error)
+	assert.EqualValues(t, "deleted-1@invalid", row.Email)
+	assert.EqualValues(t, "Deleted", row.FirstName)
+	assert.EqualValues(t, "", row.Password)
+
+	//it was already erased
+	assert.EqualValues(t, map[string]string{"no_user": "user not found"}, repo.AnonymizeUser(user.ID))
+}
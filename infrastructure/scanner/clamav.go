@@ -0,0 +1,81 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+//chunkSize is how much of the file goes in each INSTREAM chunk
+const chunkSize = 32 << 10
+
+//ClamAV scans files with a clamd daemon, streaming them over TCP with the INSTREAM command
+type ClamAV struct {
+	addr string
+	//timeout bounds the whole scan, from the connection to the reply
+	timeout time.Duration
+}
+
+var _ Scanner = &ClamAV{}
+
+//NewClamAV talks to the clamd listening on addr, such as "localhost:3310"
+func NewClamAV(addr string, timeout time.Duration) *ClamAV {
+	return &ClamAV{addr: addr, timeout: timeout}
+}
+
+func (c *ClamAV) Scan(r io.Reader) (string, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+	//the z prefix has clamd end its reply with a NUL byte
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	//each chunk is its length as four bytes in network order, then the bytes. A chunk of length 0 ends the stream.
+	chunk := make([]byte, 4+chunkSize)
+	for {
+		n, readErr := r.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := conn.Write(chunk[:4+n]); err != nil {
+				return "", err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", err
+	}
+	return parseReply(strings.TrimSuffix(reply, "\x00"))
+}
+
+//parseReply reads "stream: OK", "stream: <signature> FOUND" or an error such as "INSTREAM size limit exceeded. ERROR"
+func parseReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
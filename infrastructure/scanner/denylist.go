@@ -0,0 +1,61 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+//HashDenylist refuses the files whose SHA-256 is on a list, such as known malware or pictures that must never be published
+type HashDenylist struct {
+	hashes map[string]bool
+}
+
+var _ Scanner = &HashDenylist{}
+
+//NewHashDenylist refuses the files with the given hex encoded SHA-256
+func NewHashDenylist(hashes []string) *HashDenylist {
+	d := &HashDenylist{hashes: map[string]bool{}}
+	for _, hash := range hashes {
+		d.hashes[strings.ToLower(strings.TrimSpace(hash))] = true
+	}
+	return d
+}
+
+//LoadHashDenylist reads one hash per line. Only the first field is read, so the output of sha256sum can be used as it is,
+//and the lines starting with # are comments.
+func LoadHashDenylist(path string) (*HashDenylist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hashes []string
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		fields := strings.Fields(lines.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		hashes = append(hashes, fields[0])
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return NewHashDenylist(hashes), nil
+}
+
+func (d *HashDenylist) Scan(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if d.hashes[sum] {
+		return "Denylist.SHA256." + sum, nil
+	}
+	return "", nil
+}
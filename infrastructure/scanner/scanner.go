@@ -0,0 +1,10 @@
+package scanner
+
+import "io"
+
+//Scanner looks into an uploaded file before it is made public
+type Scanner interface {
+	//Scan reads the file from r and returns the name of what it found, empty when the file is clean.
+	//An error means the file could not be scanned, it is not to be trusted either.
+	Scan(r io.Reader) (string, error)
+}
@@ -0,0 +1,122 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//eicar is the standard antivirus test file, every scanner reports it
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+//fakeClamd answers INSTREAM like clamd: it finds the EICAR file, and refuses streams longer than maxBytes
+func fakeClamd(t *testing.T, maxBytes int) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, maxBytes)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveClamd(conn net.Conn, maxBytes int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		_, _ = io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+	var received bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&received, r, int64(size)); err != nil {
+			return
+		}
+		if received.Len() > maxBytes {
+			_, _ = io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			return
+		}
+	}
+	if strings.Contains(received.String(), eicar) {
+		_, _ = io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+		return
+	}
+	_, _ = io.WriteString(conn, "stream: OK\x00")
+}
+
+func TestClamAV_Scan(t *testing.T) {
+	clamav := NewClamAV(fakeClamd(t, 1<<20), time.Second)
+
+	found, err := clamav.Scan(strings.NewReader("a clean file"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "", found)
+
+	//the file spans several chunks
+	infected := append(bytes.Repeat([]byte{'a'}, chunkSize+10), eicar...)
+	found, err = clamav.Scan(bytes.NewReader(infected))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "Eicar-Test-Signature", found)
+}
+
+func TestClamAV_Errors(t *testing.T) {
+	clamav := NewClamAV(fakeClamd(t, 16), time.Second)
+	_, err := clamav.Scan(strings.NewReader("longer than the limit of the daemon"))
+	assert.NotNil(t, err)
+
+	//nothing listens there anymore
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+	_, err = NewClamAV(addr, time.Second).Scan(strings.NewReader("a clean file"))
+	assert.NotNil(t, err)
+}
+
+func TestHashDenylist_Scan(t *testing.T) {
+	sum := sha256.Sum256([]byte(eicar))
+	hash := hex.EncodeToString(sum[:])
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	list := "# known files\n\n" + strings.ToUpper(hash) + "  eicar.com\n"
+	if err := ioutil.WriteFile(path, []byte(list), 0600); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	denylist, err := LoadHashDenylist(path)
+	assert.Nil(t, err)
+
+	found, err := denylist.Scan(strings.NewReader(eicar))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "Denylist.SHA256."+hash, found)
+
+	found, err = denylist.Scan(strings.NewReader("a clean file"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "", found)
+
+	_, err = LoadHashDenylist(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
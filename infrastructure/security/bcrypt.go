@@ -0,0 +1,39 @@
+package security
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+//BcryptHasher handles the modular crypt format $2a$/$2b$/$2y$ hashes this application stored before argon2id
+type BcryptHasher struct {
+	cost int
+}
+
+var _ Hasher = &BcryptHasher{}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hashed), err
+}
+
+func (h *BcryptHasher) Verify(encoded, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatchedPassword
+	}
+	return err
+}
+
+func (h *BcryptHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.cost
+}
@@ -0,0 +1,85 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//prefixLength is how much of the SHA-1 leaves the checker, as in the Pwned Passwords range API
+const prefixLength = 5
+
+//RangeSource returns the hash suffixes known for a SHA-1 prefix, one "SUFFIX:COUNT" line each
+type RangeSource interface {
+	Range(prefix string) ([]string, error)
+}
+
+//BreachChecker looks passwords up with k-anonymity: the source only ever sees the first five hex characters of the hash
+type BreachChecker struct {
+	source RangeSource
+}
+
+func NewBreachChecker(source RangeSource) *BreachChecker {
+	return &BreachChecker{source: source}
+}
+
+func (b *BreachChecker) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	lines, err := b.source.Range(hash[:prefixLength])
+	if err != nil {
+		return false, err
+	}
+	suffix := hash[prefixLength:]
+	for _, line := range lines {
+		candidate := line
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			candidate = line[:i]
+		}
+		if strings.EqualFold(strings.TrimSpace(candidate), suffix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//PrefixFiles reads a local copy of the Pwned Passwords range data: a directory with one file per prefix, named like 5BAA6.txt
+type PrefixFiles struct {
+	dir string
+}
+
+var _ RangeSource = &PrefixFiles{}
+
+func NewPrefixFiles(dir string) (*PrefixFiles, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	return &PrefixFiles{dir: dir}, nil
+}
+
+func (p *PrefixFiles) Range(prefix string) ([]string, error) {
+	f, err := os.Open(filepath.Join(p.dir, strings.ToUpper(prefix)+".txt"))
+	if os.IsNotExist(err) {
+		//an incomplete copy simply knows no breached passwords for this prefix
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
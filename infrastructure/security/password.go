@@ -1,11 +1,77 @@
 package security
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+)
 
-func Hash(password string) ([]byte, error) {
-	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+var ErrMismatchedPassword = errors.New("incorrect password")
+
+//Hasher hashes passwords into self-describing strings, so a stored hash says which algorithm and parameters produced it
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) error
+	//Handles reports whether encoded was produced by this algorithm
+	Handles(encoded string) bool
+	//Outdated reports whether encoded uses weaker parameters than the hasher is configured with
+	Outdated(encoded string) bool
+}
+
+//Passwords hashes new passwords with the preferred hasher and verifies old hashes with whichever hasher recognises them
+type Passwords struct {
+	preferred Hasher
+	others    []Hasher
+}
+
+func NewPasswords(preferred Hasher, others ...Hasher) *Passwords {
+	return &Passwords{preferred: preferred, others: others}
+}
+
+func (p *Passwords) Hash(password string) (string, error) {
+	return p.preferred.Hash(password)
+}
+
+func (p *Passwords) Verify(encoded, password string) error {
+	h := p.hasherFor(encoded)
+	if h == nil {
+		return errors.New("unknown password hash format")
+	}
+	return h.Verify(encoded, password)
+}
+
+//NeedsRehash is true when encoded was not produced by the preferred hasher with its current parameters
+func (p *Passwords) NeedsRehash(encoded string) bool {
+	return !p.preferred.Handles(encoded) || p.preferred.Outdated(encoded)
+}
+
+func (p *Passwords) hasherFor(encoded string) Hasher {
+	if p.preferred.Handles(encoded) {
+		return p.preferred
+	}
+	for _, h := range p.others {
+		if h.Handles(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+//passwords is the configuration used by the package functions, bcrypt hashes from before argon2id keep verifying
+var passwords = NewPasswords(NewArgon2idHasher(DefaultArgon2Params), NewBcryptHasher(bcrypt.DefaultCost))
+
+//Configure replaces the password configuration. It is meant to be called once at startup.
+func Configure(p *Passwords) {
+	passwords = p
+}
+
+func Hash(password string) (string, error) {
+	return passwords.Hash(password)
 }
 
 func VerifyPassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-}
\ No newline at end of file
+	return passwords.Verify(hashedPassword, password)
+}
+
+func NeedsRehash(hashedPassword string) bool {
+	return passwords.NeedsRehash(hashedPassword)
+}
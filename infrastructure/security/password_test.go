@@ -0,0 +1,51 @@
+package security
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+//testParams keeps the tests fast, production uses DefaultArgon2Params
+var testParams = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idHasher_Success(t *testing.T) {
+	h := NewArgon2idHasher(testParams)
+
+	encoded, err := h.Hash("sammidev")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.True(t, h.Handles(encoded))
+	assert.Nil(t, h.Verify(encoded, "sammidev"))
+	assert.EqualValues(t, ErrMismatchedPassword, h.Verify(encoded, "sammidev!"))
+	assert.False(t, h.Outdated(encoded))
+
+	//the same password never hashes to the same string
+	again, _ := h.Hash("sammidev")
+	assert.NotEqual(t, encoded, again)
+}
+
+func TestArgon2idHasher_Outdated(t *testing.T) {
+	weak, _ := NewArgon2idHasher(testParams).Hash("sammidev")
+	stronger := testParams
+	stronger.Iterations = 2
+
+	assert.True(t, NewArgon2idHasher(stronger).Outdated(weak))
+	//verification uses the parameters stored in the hash
+	assert.Nil(t, NewArgon2idHasher(stronger).Verify(weak, "sammidev"))
+}
+
+func TestPasswords_LegacyBcrypt(t *testing.T) {
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+	p := NewPasswords(NewArgon2idHasher(testParams), bcryptHasher)
+	legacy, _ := bcryptHasher.Hash("sammidev")
+
+	assert.Nil(t, p.Verify(legacy, "sammidev"))
+	assert.EqualValues(t, ErrMismatchedPassword, p.Verify(legacy, "wrong"))
+	assert.True(t, p.NeedsRehash(legacy))
+
+	current, _ := p.Hash("sammidev")
+	assert.False(t, p.NeedsRehash(current))
+	assert.NotNil(t, p.Verify("plain text", "plain text"))
+}
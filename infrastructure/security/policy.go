@@ -0,0 +1,130 @@
+package security
+
+import (
+	"DDD/domain/entity"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//PasswordPolicy decides which new passwords are acceptable. The zero value accepts any non empty password.
+type PasswordPolicy struct {
+	MinLength int
+	//MaxLength bounds the work a single hash costs, 0 means no limit
+	MaxLength     int
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	//MaxRepeated is the longest run of one character allowed, 0 means no limit
+	MaxRepeated int
+	//Breaches is consulted last, nil skips the check
+	Breaches *BreachChecker
+}
+
+//DefaultPasswordPolicy is used when nothing else is configured
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:    10,
+	MaxLength:    128,
+	RequireLower: true,
+	RequireUpper: true,
+	RequireDigit: true,
+	MaxRepeated:  3,
+}
+
+//personalMinLength keeps short names like "Al" from rejecting half of all passwords
+const personalMinLength = 3
+
+//Check returns one entry per broken rule, keyed so the frontend can show its own message. user is who the password is for.
+func (p *PasswordPolicy) Check(password string, user *entity.User) map[string]string {
+	errorMessages := make(map[string]string)
+	if password == "" {
+		errorMessages["password_required"] = "password is required"
+		return errorMessages
+	}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		errorMessages["password_too_short"] = fmt.Sprintf("password should be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		errorMessages["password_too_long"] = fmt.Sprintf("password should be at most %d characters", p.MaxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	var run, longestRun int
+	var previous rune
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+		if r == previous {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longestRun {
+			longestRun = run
+		}
+		previous = r
+	}
+	if p.RequireLower && !lower {
+		errorMessages["password_missing_lowercase"] = "password should contain a lowercase letter"
+	}
+	if p.RequireUpper && !upper {
+		errorMessages["password_missing_uppercase"] = "password should contain an uppercase letter"
+	}
+	if p.RequireDigit && !digit {
+		errorMessages["password_missing_digit"] = "password should contain a digit"
+	}
+	if p.RequireSymbol && !symbol {
+		errorMessages["password_missing_symbol"] = "password should contain a symbol"
+	}
+	if p.MaxRepeated > 0 && longestRun > p.MaxRepeated {
+		errorMessages["password_repeated_characters"] = fmt.Sprintf("password should not repeat a character more than %d times in a row", p.MaxRepeated)
+	}
+
+	if user != nil {
+		folded := strings.ToLower(password)
+		if containsPersonal(folded, user.FirstName, user.LastName) {
+			errorMessages["password_contains_name"] = "password should not contain your name"
+		}
+		local := user.Email
+		if at := strings.LastIndex(local, "@"); at >= 0 {
+			local = local[:at]
+		}
+		if containsPersonal(folded, user.Email, local) {
+			errorMessages["password_contains_email"] = "password should not contain your email"
+		}
+	}
+
+	//only pay for the lookup when the password is otherwise acceptable
+	if len(errorMessages) == 0 && p.Breaches != nil {
+		breached, err := p.Breaches.Breached(password)
+		if err != nil {
+			log.Printf("password breach check failed: %v", err)
+		}
+		if breached {
+			errorMessages["password_breached"] = "this password has appeared in a data breach, please choose another one"
+		}
+	}
+	return errorMessages
+}
+
+func containsPersonal(folded string, values ...string) bool {
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if utf8.RuneCountInString(v) >= personalMinLength && strings.Contains(folded, v) {
+			return true
+		}
+	}
+	return false
+}
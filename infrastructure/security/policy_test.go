@@ -0,0 +1,72 @@
+package security
+
+import (
+	"DDD/domain/entity"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var policyUser = &entity.User{FirstName: "Sammi", LastName: "Dev", Email: "sammidev@gmail.com"}
+
+func TestPasswordPolicy_Accepts(t *testing.T) {
+	policy := DefaultPasswordPolicy
+	assert.Empty(t, policy.Check("Correct4Horse", policyUser))
+	//the zero value only requires a password
+	assert.Empty(t, (&PasswordPolicy{}).Check("x", nil))
+}
+
+func TestPasswordPolicy_Rules(t *testing.T) {
+	policy := DefaultPasswordPolicy
+	policy.RequireSymbol = true
+	samples := []struct {
+		password string
+		key      string
+	}{
+		{"", "password_required"},
+		{"Sh0rt!", "password_too_short"},
+		{"uppercase-4-none", "password_missing_uppercase"},
+		{"LOWERCASE-4-NONE", "password_missing_lowercase"},
+		{"No-Digits-Here", "password_missing_digit"},
+		{"NoSymbols4Here", "password_missing_symbol"},
+		{"Baaaad-Pass4", "password_repeated_characters"},
+		{"My-sammi-Pass4", "password_contains_name"},
+		{"SammiDev-rocks-4", "password_contains_name"},
+		{"Mail:sammidev@GMAIL.com4", "password_contains_email"},
+	}
+	for _, v := range samples {
+		errs := policy.Check(v.password, policyUser)
+		assert.Contains(t, errs, v.key, v.password)
+	}
+	long := DefaultPasswordPolicy
+	long.MaxLength = 12
+	assert.Contains(t, long.Check("Much-too-long-4-this", nil), "password_too_long")
+}
+
+func TestPasswordPolicy_Breached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breaches")
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	//SHA-1 of "Password123" is B2E98AD6F6EB8508DD6A14CFA704BAD7F05F6FB1
+	range5 := "003D68EB55068C33ACE09247EE4C639306B:3\r\nAD6F6EB8508DD6A14CFA704BAD7F05F6FB1:1210\r\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "B2E98.txt"), []byte(range5), 0600); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	files, err := NewPrefixFiles(dir)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	policy := DefaultPasswordPolicy
+	policy.Breaches = NewBreachChecker(files)
+
+	assert.Contains(t, policy.Check("Password123", nil), "password_breached")
+	//no range file for its prefix
+	assert.Empty(t, policy.Check("Correct4Horse", nil))
+
+	_, err = NewPrefixFiles(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//FileSystem keeps objects as files under a root directory, for development and single server setups
+type FileSystem struct {
+	root    string
+	baseURL string
+}
+
+var _ Storage = &FileSystem{}
+
+func NewFileSystem(root, baseURL string) (*FileSystem, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &FileSystem{root: root, baseURL: baseURL}, nil
+}
+
+func (fs *FileSystem) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(fs.root, filepath.FromSlash(key)), nil
+}
+
+//Put writes to a temporary file first, readers never see half an object
+func (fs *FileSystem) Put(key string, r io.Reader, size int64, contentType string) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("expected %d bytes, got %d", size, written)
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (fs *FileSystem) Get(key string) (io.ReadCloser, *Object, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fileObject(key, info), nil
+}
+
+//Move renames the file, readers see either the old object under dst or the new one
+func (fs *FileSystem) Move(src, dst string) error {
+	from, err := fs.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := fs.path(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	err = os.Rename(from, to)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (fs *FileSystem) Delete(key string) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (fs *FileSystem) Stat(key string) (*Object, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fileObject(key, info), nil
+}
+
+//List walks the root directory, skipping the temporary files of unfinished Puts
+func (fs *FileSystem) List(prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.Walk(fs.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(fs.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, *fileObject(key, info))
+		}
+		return nil
+	})
+	//a directory is walked before the files named like it with an extension, the keys are not in order yet
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, err
+}
+
+func (fs *FileSystem) URL(key string) string {
+	return fs.baseURL + key
+}
+
+//fileObject describes a file. There is nowhere to keep the content type, so it follows from the extension of the key.
+func fileObject(key string, info os.FileInfo) *Object {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &Object{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  contentType,
+		ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		LastModified: info.ModTime(),
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Memory keeps objects in memory. It is meant for tests and for trying the API without any storage set up.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	baseURL string
+}
+
+type memoryObject struct {
+	data []byte
+	info Object
+}
+
+var _ Storage = &Memory{}
+
+func NewMemory(baseURL string) *Memory {
+	return &Memory{objects: map[string]memoryObject{}, baseURL: baseURL}
+}
+
+func (m *Memory) Put(key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{
+		data: data,
+		info: Object{Key: key, Size: int64(len(data)), ContentType: contentType, ETag: hex.EncodeToString(sum[:]), LastModified: time.Now()},
+	}
+	return nil
+}
+
+func (m *Memory) Get(key string) (io.ReadCloser, *Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	info := obj.info
+	return memoryReader{bytes.NewReader(obj.data)}, &info, nil
+}
+
+//memoryReader can seek, like the files and S3 objects, so that ranges are served without reading the whole object
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+func (m *Memory) Move(src, dst string) error {
+	if err := validKey(dst); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[src]
+	if !ok {
+		return ErrNotFound
+	}
+	obj.info.Key = dst
+	m.objects[dst] = obj
+	delete(m.objects, src)
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) Stat(key string) (*Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	info := obj.info
+	return &info, nil
+}
+
+func (m *Memory) List(prefix string) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var objects []Object
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *Memory) URL(key string) string {
+	return m.baseURL + key
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+//Presigner is implemented by backends clients can upload to directly, without the bytes going through the API
+type Presigner interface {
+	//PresignPut returns a URL accepting one PUT of the object until it expires. The Content-Type header is signed too, the client must send contentType.
+	PresignPut(key, contentType string, expires time.Duration) (string, error)
+}
+
+//SignedUploads presigns uploads for backends that cannot do it themselves. The URLs point at an API route that checks them with Verify.
+type SignedUploads struct {
+	secret  []byte
+	baseURL string
+}
+
+var _ Presigner = &SignedUploads{}
+
+func NewSignedUploads(secret []byte, baseURL string) *SignedUploads {
+	return &SignedUploads{secret: secret, baseURL: baseURL}
+}
+
+//PresignPut puts the content type in the URL, the receiving route stores the object with the signed type whatever header it is sent
+func (s *SignedUploads) PresignPut(key, contentType string, expires time.Duration) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	exp := time.Now().Add(expires).Unix()
+	return fmt.Sprintf("%s%s?content_type=%s&expires=%d&signature=%s", s.baseURL, key, url.QueryEscape(contentType), exp, s.sign(key, contentType, exp)), nil
+}
+
+//Verify checks the content_type, expires and signature parameters of a URL made by PresignPut
+func (s *SignedUploads) Verify(key, contentType, expires, signature string) bool {
+	return verify(s.secret, http.MethodPut, key, contentType, expires, signature)
+}
+
+func (s *SignedUploads) sign(key, contentType string, expires int64) string {
+	return sign(s.secret, http.MethodPut, key, contentType, expires)
+}
+
+//SignedURLs signs download links, for images served by the API out of a bucket that is not public
+type SignedURLs struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewSignedURLs(secret []byte, ttl time.Duration) *SignedURLs {
+	return &SignedURLs{secret: secret, ttl: ttl}
+}
+
+//Sign returns the expires and signature parameters of a link to key. The expiry is rounded up, so a link stays the same,
+//and cached by browsers, for a while: it is valid for at least ttl and at most twice as long.
+func (s *SignedURLs) Sign(key string) (int64, string) {
+	ttl := int64(s.ttl / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	exp := (time.Now().Unix()/ttl + 2) * ttl
+	return exp, sign(s.secret, http.MethodGet, key, "", exp)
+}
+
+//Verify checks the expires and signature parameters given by Sign
+func (s *SignedURLs) Verify(key, expires, signature string) bool {
+	return verify(s.secret, http.MethodGet, key, "", expires, signature)
+}
+
+//sign covers the method too, a signed upload cannot be used to download and the other way around.
+//Downloads have no content type.
+func sign(secret []byte, method, key, contentType string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", method, key, contentType, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verify(secret []byte, method, key, contentType, expires, signature string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	expected, err := hex.DecodeString(sign(secret, method, key, contentType, exp))
+	if err != nil {
+		return false
+	}
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, given)
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/signer"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//S3 keeps objects in a bucket of an S3 compatible service, such as DigitalOcean Spaces or MinIO
+type S3 struct {
+	client    *minio.Client
+	bucket    string
+	baseURL   string
+	accessKey string
+	secretKey string
+	//private are the prefixes of the objects kept from the public, such as files waiting for their scan
+	private []string
+}
+
+var _ Storage = &S3{}
+var _ Presigner = &S3{}
+
+//NewS3 builds the client once, it is safe for concurrent use and shared by every request.
+//The objects under the private prefixes are stored private, every other object is public.
+func NewS3(endpoint, accessKey, secretKey, bucket, baseURL string, secure bool, private ...string) (*S3, error) {
+	client, err := minio.New(endpoint, accessKey, secretKey, secure)
+	if err != nil {
+		return nil, err
+	}
+	return &S3{client: client, bucket: bucket, baseURL: baseURL, accessKey: accessKey, secretKey: secretKey, private: private}, nil
+}
+
+//streamPartSize is the part buffered at a time when the size of an object is not known, the smallest S3 accepts.
+//Left to the client, the part would be sized for a 5TiB object.
+const streamPartSize = 5 * 1024 * 1024
+
+//Put makes the object cacheable for a year, a key is never reused for other content. It is public unless its prefix is private.
+func (s *S3) Put(key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{
+		ContentType:  contentType,
+		CacheControl: "max-age=31536000",
+		UserMetadata: map[string]string{"x-amz-acl": s.acl(key)},
+	}
+	if size < 0 {
+		opts.PartSize = streamPartSize
+	}
+	_, err := s.client.PutObject(s.bucket, key, r, size, opts)
+	return err
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, *Object, error) {
+	obj, err := s.client.GetObject(s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+	//GetObject does not reach the server, Stat is the first request
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, s3Error(err)
+	}
+	return obj, s3Object(info), nil
+}
+
+//Move copies the object on the server and removes src, S3 has no rename. The copy gets the headers Put gives objects.
+func (s *S3) Move(src, dst string) error {
+	if err := validKey(dst); err != nil {
+		return err
+	}
+	info, err := s.Stat(src)
+	if err != nil {
+		return err
+	}
+	dest, err := minio.NewDestinationInfo(s.bucket, dst, nil, map[string]string{
+		"Content-Type":  info.ContentType,
+		"Cache-Control": "max-age=31536000",
+		"x-amz-acl":     s.acl(dst),
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.client.CopyObject(dest, minio.NewSourceInfo(s.bucket, src, nil)); err != nil {
+		return s3Error(err)
+	}
+	return s.client.RemoveObject(s.bucket, src)
+}
+
+//acl is the canned ACL of the object under key. Private is set rather than left to the bucket, whose default may be public.
+func (s *S3) acl(key string) string {
+	for _, prefix := range s.private {
+		if strings.HasPrefix(key, prefix) {
+			return "private"
+		}
+	}
+	return "public-read"
+}
+
+func (s *S3) Delete(key string) error {
+	return s.client.RemoveObject(s.bucket, key)
+}
+
+func (s *S3) Stat(key string) (*Object, error) {
+	info, err := s.client.StatObject(s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return s3Object(info), nil
+}
+
+func (s *S3) List(prefix string) ([]Object, error) {
+	done := make(chan struct{})
+	defer close(done)
+	var objects []Object
+	for info := range s.client.ListObjectsV2(s.bucket, prefix, true, done) {
+		if info.Err != nil {
+			return nil, s3Error(info.Err)
+		}
+		objects = append(objects, *s3Object(info))
+	}
+	return objects, nil
+}
+
+//PresignPut lets a client upload straight to the bucket. Such objects get the default ACL of the bucket.
+//The client presigns only the host header, the request is signed here so that S3 refuses another Content-Type.
+func (s *S3) PresignPut(key, contentType string, expires time.Duration) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	location, err := s.client.GetBucketLocation(s.bucket)
+	if err != nil {
+		return "", s3Error(err)
+	}
+	endpoint := s.client.EndpointURL()
+	req, err := http.NewRequest(http.MethodPut, endpoint.Scheme+"://"+endpoint.Host+"/"+s.bucket+"/"+key, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return signer.PreSignV4(*req, s.accessKey, s.secretKey, "", location, int64(expires/time.Second)).URL.String(), nil
+}
+
+func (s *S3) URL(key string) string {
+	return s.baseURL + key
+}
+
+func s3Object(info minio.ObjectInfo) *Object {
+	return &Object{Key: info.Key, Size: info.Size, ContentType: info.ContentType, ETag: info.ETag, LastModified: info.LastModified}
+}
+
+func s3Error(err error) error {
+	if resp := minio.ToErrorResponse(err); resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+//ErrNotFound is returned by Get and Stat for a key that holds no object
+var ErrNotFound = errors.New("object not found")
+
+//ErrInvalidKey is returned for keys that could escape the storage, such as "../secret"
+var ErrInvalidKey = errors.New("invalid object key")
+
+//Object describes a stored object
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+//Storage keeps uploaded files. Keys are slash separated paths such as "avatars/<uuid>/64.jpg".
+type Storage interface {
+	//Put stores the object, replacing any object with the same key. A negative size means the size is not known in advance.
+	Put(key string, r io.Reader, size int64, contentType string) error
+	//Get opens the object, the caller closes it
+	Get(key string) (io.ReadCloser, *Object, error)
+	//Move renames the object src to dst, replacing any object under dst. ErrNotFound is returned when src holds nothing.
+	Move(src, dst string) error
+	//Delete removes the object, deleting a key that holds nothing is not an error
+	Delete(key string) error
+	Stat(key string) (*Object, error)
+	//List returns the objects whose key starts with prefix, sorted by key. An empty prefix lists them all.
+	List(prefix string) ([]Object, error)
+	//URL is where clients download the object from
+	URL(key string) string
+}
+
+//validKey rejects keys that are empty, absolute or climb out of their prefix
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
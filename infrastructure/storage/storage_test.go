@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//runStorageSuite is the behaviour every Storage backend has to provide
+func runStorageSuite(t *testing.T, newStorage func(t *testing.T) Storage) {
+	t.Run("PutAndGet", func(t *testing.T) {
+		s := newStorage(t)
+		assert.Nil(t, s.Put("products/a.png", strings.NewReader("picture"), 7, "image/png"))
+
+		r, obj, err := s.Get("products/a.png")
+		if err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		//the media route serves ranges by seeking
+		_, seekable := r.(io.ReadSeeker)
+		assert.True(t, seekable)
+		r.Close()
+		assert.EqualValues(t, "picture", string(data))
+		assert.EqualValues(t, 7, obj.Size)
+		assert.EqualValues(t, "image/png", obj.ContentType)
+		assert.NotEmpty(t, obj.ETag)
+	})
+	t.Run("UnknownSize", func(t *testing.T) {
+		s := newStorage(t)
+		assert.Nil(t, s.Put("products/b.png", strings.NewReader("streamed"), -1, "image/png"))
+		obj, err := s.Stat("products/b.png")
+		assert.Nil(t, err)
+		assert.EqualValues(t, 8, obj.Size)
+	})
+	t.Run("Replace", func(t *testing.T) {
+		s := newStorage(t)
+		assert.Nil(t, s.Put("products/c.png", strings.NewReader("first"), 5, "image/png"))
+		assert.Nil(t, s.Put("products/c.png", strings.NewReader("second"), 6, "image/png"))
+		obj, err := s.Stat("products/c.png")
+		assert.Nil(t, err)
+		assert.EqualValues(t, 6, obj.Size)
+	})
+	t.Run("Delete", func(t *testing.T) {
+		s := newStorage(t)
+		assert.Nil(t, s.Put("products/d.png", strings.NewReader("picture"), 7, "image/png"))
+		assert.Nil(t, s.Delete("products/d.png"))
+		_, err := s.Stat("products/d.png")
+		assert.EqualValues(t, ErrNotFound, err)
+		_, _, err = s.Get("products/d.png")
+		assert.EqualValues(t, ErrNotFound, err)
+		//deleting twice is fine
+		assert.Nil(t, s.Delete("products/d.png"))
+	})
+	t.Run("Move", func(t *testing.T) {
+		s := newStorage(t)
+		assert.Nil(t, s.Put("incoming/g.png", strings.NewReader("picture"), 7, "image/png"))
+		assert.Nil(t, s.Put("products/g.png", strings.NewReader("old"), 3, "image/png"))
+		assert.Nil(t, s.Move("incoming/g.png", "products/g.png"))
+
+		r, obj, err := s.Get("products/g.png")
+		if err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		r.Close()
+		assert.EqualValues(t, "picture", string(data))
+		assert.EqualValues(t, "products/g.png", obj.Key)
+		assert.EqualValues(t, "image/png", obj.ContentType)
+		_, err = s.Stat("incoming/g.png")
+		assert.EqualValues(t, ErrNotFound, err)
+		assert.EqualValues(t, ErrNotFound, s.Move("incoming/g.png", "products/h.png"))
+		assert.EqualValues(t, ErrInvalidKey, s.Move("products/g.png", "../g.png"))
+	})
+	t.Run("List", func(t *testing.T) {
+		s := newStorage(t)
+		for _, key := range []string{"products/e/150.jpg", "products/e.png", "avatars/f/64.jpg"} {
+			assert.Nil(t, s.Put(key, strings.NewReader("picture"), 7, "image/jpeg"))
+		}
+		objects, err := s.List("products/")
+		assert.Nil(t, err)
+		var keys []string
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+			assert.EqualValues(t, 7, obj.Size)
+		}
+		assert.EqualValues(t, []string{"products/e.png", "products/e/150.jpg"}, keys)
+		all, err := s.List("")
+		assert.Nil(t, err)
+		assert.EqualValues(t, 3, len(all))
+	})
+	t.Run("InvalidKey", func(t *testing.T) {
+		s := newStorage(t)
+		for _, key := range []string{"", "/etc/passwd", "../secret", "products/../../secret", "products//a.png"} {
+			assert.EqualValues(t, ErrInvalidKey, s.Put(key, strings.NewReader("x"), 1, "text/plain"), key)
+		}
+	})
+	t.Run("URL", func(t *testing.T) {
+		s := newStorage(t)
+		assert.EqualValues(t, "https://cdn.example.com/products/a.png", s.URL("products/a.png"))
+	})
+}
+
+func TestMemory(t *testing.T) {
+	runStorageSuite(t, func(t *testing.T) Storage {
+		return NewMemory("https://cdn.example.com/")
+	})
+}
+
+func TestFileSystem(t *testing.T) {
+	runStorageSuite(t, func(t *testing.T) Storage {
+		dir, err := ioutil.TempDir("", "storage")
+		if err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		fs, err := NewFileSystem(dir, "https://cdn.example.com/")
+		if err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+		return fs
+	})
+}
+
+//TestS3 runs against STORAGE_TEST_S3_BUCKET on STORAGE_TEST_S3_ENDPOINT, a throwaway bucket of a local MinIO for instance
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("no test bucket")
+	}
+	s3, err := NewS3(endpoint, os.Getenv("STORAGE_TEST_S3_KEY"), os.Getenv("STORAGE_TEST_S3_SECRET"), os.Getenv("STORAGE_TEST_S3_BUCKET"), "https://cdn.example.com/", os.Getenv("STORAGE_TEST_S3_INSECURE") != "true")
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	runStorageSuite(t, func(t *testing.T) Storage {
+		return s3
+	})
+}
+
+func TestSignedUploads(t *testing.T) {
+	signer := NewSignedUploads([]byte("secret"), "/storage/")
+	raw, err := signer.PresignPut("uploads/a.png", "image/png", time.Minute)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	assert.EqualValues(t, "/storage/uploads/a.png", u.Path)
+	q := u.Query()
+	assert.EqualValues(t, "image/png", q.Get("content_type"))
+	assert.True(t, signer.Verify("uploads/a.png", "image/png", q.Get("expires"), q.Get("signature")))
+	//the signature covers the key, the content type and the expiry
+	assert.False(t, signer.Verify("uploads/b.png", "image/png", q.Get("expires"), q.Get("signature")))
+	assert.False(t, signer.Verify("uploads/a.png", "text/html", q.Get("expires"), q.Get("signature")))
+	assert.False(t, signer.Verify("uploads/a.png", "image/png", q.Get("expires")+"0", q.Get("signature")))
+	assert.False(t, NewSignedUploads([]byte("other"), "/storage/").Verify("uploads/a.png", "image/png", q.Get("expires"), q.Get("signature")))
+
+	expired, _ := signer.PresignPut("uploads/a.png", "image/png", -time.Minute)
+	u, _ = url.Parse(expired)
+	assert.False(t, signer.Verify("uploads/a.png", "image/png", u.Query().Get("expires"), u.Query().Get("signature")))
+
+	_, err = signer.PresignPut("../a.png", "image/png", time.Minute)
+	assert.EqualValues(t, ErrInvalidKey, err)
+}
+
+func TestSignedURLs(t *testing.T) {
+	signer := NewSignedURLs([]byte("secret"), time.Hour)
+	exp, signature := signer.Sign("images/a.png")
+	expires := strconv.FormatInt(exp, 10)
+	assert.True(t, signer.Verify("images/a.png", expires, signature))
+	assert.False(t, signer.Verify("images/b.png", expires, signature))
+	//the link stays the same for a while, so that browsers keep it cached
+	_, again := signer.Sign("images/a.png")
+	assert.EqualValues(t, signature, again)
+	assert.True(t, exp-time.Now().Unix() >= int64(time.Hour/time.Second))
+
+	//a download link is no upload link
+	uploads := NewSignedUploads([]byte("secret"), "/storage/")
+	assert.False(t, uploads.Verify("images/a.png", "", expires, signature))
+}
@@ -0,0 +1,226 @@
+package tus
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/twinj/uuid"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Upload is a resumable upload, what tus calls an upload resource. The bytes received so far are kept next to it.
+type Upload struct {
+	ID string `json:"id"`
+	//Length is the size of the whole file, announced when the upload is created
+	Length int64 `json:"length"`
+	//Offset is how many bytes were received, it is read from the data itself and never stored
+	Offset         int64             `json:"-"`
+	Metadata       map[string]string `json:"metadata"`
+	UserID         uint64            `json:"user_id"`
+	OrganizationID uint64            `json:"organization_id"`
+	ExpiresAt      time.Time         `json:"expires_at"`
+}
+
+//Complete is true once every byte was received
+func (u *Upload) Complete() bool {
+	return u.Offset >= u.Length
+}
+
+var (
+	ErrNotFound = errors.New("upload not found")
+	ErrTooLong  = errors.New("the data goes past the length of the upload")
+	ErrLocked   = errors.New("the upload is being written by another request")
+)
+
+//Store keeps the uploads that are not complete yet, so that they survive dropped connections and restarts
+type Store interface {
+	//Create saves a new upload with no data, and gives it its ID
+	Create(upload *Upload) error
+	Get(id string) (*Upload, error)
+	//Lock keeps the upload to one request at a time, the returned function releases it. ErrLocked is returned while another request holds it.
+	Lock(id string) (func(), error)
+	//Append adds what r holds at the end of the data, up to the length of the upload, and returns the new offset.
+	//The bytes read before an error are kept: that is where the client resumes.
+	Append(id string, r io.Reader) (int64, error)
+	//Open reads the data back, the caller closes it
+	Open(id string) (io.ReadCloser, error)
+	Delete(id string) error
+	//Expired lists the uploads that expired before now
+	Expired(now time.Time) ([]string, error)
+}
+
+//FileStore keeps each upload as two files under a directory: <id>.info, its state as JSON, and <id>.bin, the bytes received.
+//The directory and the locks belong to one process, the instances of the API cannot share a FileStore.
+type FileStore struct {
+	dir    string
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+var _ Store = &FileStore{}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, locked: map[string]bool{}}, nil
+}
+
+//path refuses the IDs that were not made by Create, they come from the URL
+func (fs *FileStore) path(id, ext string) (string, error) {
+	if id == "" || strings.Trim(id, "0123456789abcdef-") != "" {
+		return "", ErrNotFound
+	}
+	return filepath.Join(fs.dir, id+ext), nil
+}
+
+func (fs *FileStore) Create(upload *Upload) error {
+	upload.ID = uuid.NewV4().String()
+	upload.Offset = 0
+	info, _ := fs.path(upload.ID, ".info")
+	data, _ := fs.path(upload.ID, ".bin")
+	f, err := os.OpenFile(data, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	encoded, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	//the state is written aside and renamed, Get never reads half of it
+	tmp, err := ioutil.TempFile(fs.dir, ".info-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(encoded)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), info)
+}
+
+func (fs *FileStore) Get(id string) (*Upload, error) {
+	info, err := fs.path(id, ".info")
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := ioutil.ReadFile(info)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload := &Upload{}
+	if err := json.Unmarshal(encoded, upload); err != nil {
+		return nil, err
+	}
+	data, _ := fs.path(id, ".bin")
+	stat, err := os.Stat(data)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset = stat.Size()
+	return upload, nil
+}
+
+//Lock only holds within this process, the uploads of a FileStore are written by a single server
+func (fs *FileStore) Lock(id string) (func(), error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.locked[id] {
+		return nil, ErrLocked
+	}
+	fs.locked[id] = true
+	return func() {
+		fs.mu.Lock()
+		delete(fs.locked, id)
+		fs.mu.Unlock()
+	}, nil
+}
+
+func (fs *FileStore) Append(id string, r io.Reader) (int64, error) {
+	upload, err := fs.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	data, _ := fs.path(id, ".bin")
+	f, err := os.OpenFile(data, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return upload.Offset, err
+	}
+	written, err := io.Copy(f, io.LimitReader(r, upload.Length-upload.Offset))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	offset := upload.Offset + written
+	if err != nil {
+		return offset, err
+	}
+	//whatever is left past the length is refused, what fitted is kept
+	var extra [1]byte
+	if n, _ := r.Read(extra[:]); n > 0 {
+		return offset, ErrTooLong
+	}
+	return offset, nil
+}
+
+func (fs *FileStore) Open(id string) (io.ReadCloser, error) {
+	data, err := fs.path(id, ".bin")
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(data)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (fs *FileStore) Delete(id string) error {
+	for _, ext := range []string{".info", ".bin"} {
+		p, err := fs.path(id, ext)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *FileStore) Expired(now time.Time) ([]string, error) {
+	infos, err := filepath.Glob(filepath.Join(fs.dir, "*.info"))
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	for _, info := range infos {
+		id := strings.TrimSuffix(filepath.Base(info), ".info")
+		upload, err := fs.Get(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if upload.ExpiresAt.Before(now) {
+			expired = append(expired, id)
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,76 @@
+package tus
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStore_Resume(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	upload := &Upload{Length: 10, Metadata: map[string]string{"filename": "photo.png"}, UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	assert.Nil(t, fs.Create(upload))
+	assert.NotEmpty(t, upload.ID)
+
+	offset, err := fs.Append(upload.ID, strings.NewReader("hello"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 5, offset)
+
+	//the state is read back from the directory, as after a restart
+	fs, _ = NewFileStore(dir)
+	got, err := fs.Get(upload.ID)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 5, got.Offset)
+	assert.EqualValues(t, "photo.png", got.Metadata["filename"])
+	assert.False(t, got.Complete())
+
+	//the bytes past the length are refused
+	offset, err = fs.Append(upload.ID, strings.NewReader("world and more"))
+	assert.EqualValues(t, ErrTooLong, err)
+	assert.EqualValues(t, 10, offset)
+
+	r, err := fs.Open(upload.ID)
+	assert.Nil(t, err)
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.EqualValues(t, "helloworld", string(data))
+
+	assert.Nil(t, fs.Delete(upload.ID))
+	_, err = fs.Get(upload.ID)
+	assert.EqualValues(t, ErrNotFound, err)
+}
+
+func TestFileStore_Lock(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir())
+	unlock, err := fs.Lock("a")
+	assert.Nil(t, err)
+	_, err = fs.Lock("a")
+	assert.EqualValues(t, ErrLocked, err)
+	unlock()
+	_, err = fs.Lock("a")
+	assert.Nil(t, err)
+}
+
+func TestFileStore_Expired(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir())
+	old := &Upload{Length: 4, ExpiresAt: time.Now().Add(-time.Minute)}
+	recent := &Upload{Length: 4, ExpiresAt: time.Now().Add(time.Hour)}
+	_ = fs.Create(old)
+	_ = fs.Create(recent)
+	expired, err := fs.Expired(time.Now())
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{old.ID}, expired)
+
+	//the IDs come from URLs, they never reach outside the directory
+	_, err = fs.Get("../" + recent.ID)
+	assert.EqualValues(t, ErrNotFound, err)
+	_, err = fs.Append("..", bytes.NewReader(nil))
+	assert.EqualValues(t, ErrNotFound, err)
+}
@@ -0,0 +1,81 @@
+package interfaces
+
+import (
+	"DDD/application"
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/interfaces/middleware"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+//Admin holds the support tooling that is only available with the users:admin scope
+type Admin struct {
+	us    application.UserAppInterface
+	orgs  application.OrganizationAppInterface
+	audit application.AuditAppInterface
+	rd    auth.AuthInterface
+	tk    auth.TokenInterface
+}
+
+//Admin constructor
+func NewAdmin(us application.UserAppInterface, orgs application.OrganizationAppInterface, audit application.AuditAppInterface, rd auth.AuthInterface, tk auth.TokenInterface) *Admin {
+	return &Admin{
+		us:    us,
+		orgs:  orgs,
+		audit: audit,
+		rd:    rd,
+		tk:    tk,
+	}
+}
+
+//Impersonate issues a short-lived token to act as another user, so support staff can reproduce their problems
+func (ad *Admin) Impersonate(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userId, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, "invalid request")
+		return
+	}
+	user, err := ad.us.GetUser(userId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, "user not found")
+		return
+	}
+	//impersonating an admin, or yourself, would only be a way around the audit trail
+	if user.Role == entity.RoleAdmin || user.ID == principal.User.ID {
+		c.JSON(http.StatusForbidden, "this user cannot be impersonated")
+		return
+	}
+	//the token gets the user's own scopes, never the admin's
+	ts, err := ad.tk.CreateImpersonationToken(principal.User.ID, user.ID, defaultOrganization(ad.orgs, user.ID), user.Scopes())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := ad.rd.CreateAuth(user.ID, ts); err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = ad.audit.SaveEvent(&entity.AuditEvent{
+		ActorID: principal.User.ID,
+		UserID:  user.ID,
+		Action:  "impersonation started",
+		Status:  http.StatusCreated,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"access_token": ts.AccessToken,
+		"expires_at":   ts.AtExpires,
+		"user_id":      user.ID,
+		"actor_id":     principal.User.ID,
+	})
+}
@@ -0,0 +1,83 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/storage"
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+//Reasons CheckUpload refuses a file
+var (
+	ErrUploadMissing  = errors.New("the file was not uploaded")
+	ErrUploadTooLarge = errors.New("the file is too large")
+	ErrUploadType     = errors.New("the file does not match its content type")
+)
+
+//CheckUpload validates a file a client put straight into the storage. A refused file is deleted, it could never be attached.
+//The file is not kept as it came: the image is decoded and stored again through the path of UploadFile, without its metadata.
+//The uploaded file itself stays until DeleteUpload, so a confirm that failed after the check can be retried.
+//The file is held to the size of its intent and to the policy of the route it is confirmed on, which may have changed since the intent.
+func (fu *fileUpload) CheckUpload(key, contentType string, maxBytes int64, policy *entity.UploadPolicy) (string, error) {
+	obj, err := fu.store.Stat(key)
+	if err == storage.ErrNotFound {
+		return "", ErrUploadMissing
+	}
+	if err != nil {
+		return "", err
+	}
+	if maxBytes > policy.MaxBytes {
+		maxBytes = policy.MaxBytes
+	}
+	if obj.Size > maxBytes {
+		fu.discard(key)
+		return "", ErrUploadTooLarge
+	}
+	//the type the object is kept with is the one it would be served with by the storage
+	if obj.ContentType != contentType || !policy.Allows(contentType, key) {
+		fu.discard(key)
+		return "", ErrUploadType
+	}
+	r, _, err := fu.store.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	//the client chose the Content-Type header, only the bytes tell what the file really is.
+	//The URL takes the file again until it expires, what is read is held to the size all the same.
+	br := bufio.NewReaderSize(&limitReader{r: r, n: maxBytes}, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if http.DetectContentType(head) != contentType {
+		fu.discard(key)
+		return "", ErrUploadType
+	}
+	img, format, err := decode(br, &policy.Image)
+	if err != nil {
+		fu.discard(key)
+		return "", err
+	}
+	//a file that could not be scanned stays where it is, the client may confirm it again
+	if err := fu.scan(key); err != nil {
+		if err == ErrInfected {
+			fu.discard(key)
+		}
+		return "", err
+	}
+	return fu.storeClean(img, format)
+}
+
+func (fu *fileUpload) DeleteUpload(key string) error {
+	return fu.store.Delete(key)
+}
+
+func (fu *fileUpload) discard(key string) {
+	if err := fu.store.Delete(key); err != nil {
+		log.Printf("upload: cannot delete refused file %s: %v", key, err)
+	}
+}
@@ -1,24 +1,57 @@
 package fileupload
 
 import (
+	"DDD/application"
+	"DDD/domain/entity"
+	"DDD/infrastructure/scanner"
+	"DDD/infrastructure/storage"
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
-	"github.com/minio/minio-go/v6"
+	"github.com/twinj/uuid"
+	"hash"
+	"image"
+	"io"
+	"io/ioutil"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"os"
-	"strings"
+	"sync"
 )
-func NewFileUpload() *fileUpload {
-	return &fileUpload{}
+
+//NewFileUpload stores the uploads in store, whichever backend it is, and counts who uses them in refs.
+//Without variants no resized copies are made. Every file is run through the scanners before it is stored.
+func NewFileUpload(store storage.Storage, refs application.ObjectRefAppInterface, variants *Variants, scanners ...scanner.Scanner) *fileUpload {
+	return &fileUpload{store: store, refs: refs, variants: variants, scanners: scanners}
 }
 
-type fileUpload struct{}
+type fileUpload struct {
+	store    storage.Storage
+	refs     application.ObjectRefAppInterface
+	variants *Variants
+	scanners []scanner.Scanner
+}
 
+//Files are stored under the hash of their content, so uploading the same picture twice stores it once.
+//Every upload takes a reference on its file for the caller, and deleting gives it back: the file is only removed with its last reference.
 type UploadFileInterface interface {
-	UploadFile(file *multipart.FileHeader) (string, error)
+	//UploadFile reads the image from r as it arrives and stores it encoded again, without its metadata.
+	//The content decides the format, the name of the file only has to agree with it. Files outside policy are refused.
+	UploadFile(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error)
+	//UploadAvatar stores the picture as square crops in every entity.AvatarSizes and returns the avatar key
+	UploadAvatar(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error)
+	DeleteAvatar(key string) error
+	//DeleteFile releases a file stored by UploadFile, its variants go with it
+	DeleteFile(key string) error
+	//GenerateVariants stores the resized copies of the image stored under key. It takes a while and is meant to run in a job.
+	GenerateVariants(key string) (entity.ImageVariants, error)
+	//CheckUpload validates and scans a file uploaded straight to the storage, see ErrUploadMissing, ErrUploadTooLarge, ErrUploadType
+	//and ErrInfected, and against the policy like the files of UploadFile. A file that passed is decoded and stored again like UploadFile
+	//does, CheckUpload returns its key.
+	CheckUpload(key, contentType string, maxBytes int64, policy *entity.UploadPolicy) (string, error)
+	//DeleteUpload deletes a file uploaded straight to the storage, once the upload is confirmed and its clean copy attached
+	DeleteUpload(key string) error
 }
 
 //So what is exposed is Uploader
@@ -75,49 +108,220 @@ var _ UploadFileInterface = &fileUpload{}
 //	return filePath, nil
 //}
 
-func (fu *fileUpload) UploadFile(file *multipart.FileHeader) (string, error) {
-	f, err := file.Open()
+//sniffLen is all http.DetectContentType looks at
+const sniffLen = 512
+
+//ErrImageTooLarge is returned as soon as an upload goes past the MaxBytes of its policy
+var ErrImageTooLarge = errors.New("the file is larger than allowed")
+
+//UploadFile decodes the image as it streams in and stores the clean copy, encoded into a pooled buffer so that the storage gets its size.
+//It is kept under IncomingPrefix until it is referenced, then moved under its content key.
+func (fu *fileUpload) UploadFile(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	if err := sniff(br, filename, policy); err != nil {
+		return "", err
+	}
+	img, format, err := fu.readScanned(br, ioutil.Discard, policy)
 	if err != nil {
-		return "", errors.New("cannot open file")
-	}
-	defer f.Close()
-
-	size := file.Size
-	//The image should not be more than 500KB
-	fmt.Println("the size: ", size)
-	if size > int64(512000) {
-		return "", errors.New("sorry, please upload an Image of 500KB or less")
-	}
-	//only the first 512 bytes are used to sniff the content type of a file,
-	//so, so no need to read the entire bytes of a file.
-	buffer := make([]byte, size)
-	f.Read(buffer)
-	fileType := http.DetectContentType(buffer)
-	//if the image is valid
-	if !strings.HasPrefix(fileType, "image") {
-		return "", errors.New("please upload a valid image")
-	}
-	filePath := FormatFile(file.Filename)
-
-	accessKey := os.Getenv("DO_SPACES_KEY")
-	secKey := os.Getenv("DO_SPACES_SECRET")
-	endpoint := os.Getenv("DO_SPACES_ENDPOINT")
-	ssl := true
-
-	// Initiate a client using DigitalOcean Spaces.
-	client, err := minio.New(endpoint, accessKey, secKey, ssl)
+		return "", err
+	}
+	return fu.storeClean(img, format)
+}
+
+//storeClean encodes the decoded image again with its cleanEncoder and stores it under its content key, taking a reference for the caller
+func (fu *fileUpload) storeClean(img image.Image, format string) (string, error) {
+	encoder := cleanEncoder(format)
+	ext := "." + encoder.Format()
+	buf := getBuffer()
+	defer putBuffer(buf)
+	hash := sha256.New()
+	if err := encoder.Encode(io.MultiWriter(buf, hash), img); err != nil {
+		log.Printf("upload: cannot encode a %s image: %v", format, err)
+		return "", errors.New("something went wrong")
+	}
+	incoming := entity.IncomingPrefix + uuid.NewV4().String() + ext
+	err := fu.store.Put(incoming, bytes.NewReader(buf.Bytes()), int64(buf.Len()), encoder.ContentType())
 	if err != nil {
-		log.Fatal(err)
+		fu.discard(incoming)
+		log.Printf("upload: cannot store %s: %v", incoming, err)
+		return "", errors.New("something went wrong")
+	}
+	filePath := contentKey(entity.ImagePrefix, hash, ext)
+	moved := false
+	err = fu.retain(filePath, func() error {
+		moved = true
+		return fu.store.Move(incoming, filePath)
+	}, func() error {
+		return fu.store.Delete(filePath)
+	})
+	//the file was stored before, the copy that came in is not needed
+	if !moved {
+		fu.discard(incoming)
 	}
-	fileBytes := bytes.NewReader(buffer)
-	cacheControl := "max-age=31536000"
-	// make it public
-	userMetaData := map[string]string{"x-amz-acl": "public-read"}
-	n, err := client.PutObject("chodapi", filePath, fileBytes, size, minio.PutObjectOptions{ContentType: fileType, CacheControl: cacheControl, UserMetadata: userMetaData})
 	if err != nil {
-		fmt.Println("the error", err)
+		log.Printf("upload: cannot move %s to %s: %v", incoming, filePath, err)
 		return "", errors.New("something went wrong")
 	}
-	fmt.Println("Successfully uploaded bytes: ", n)
 	return filePath, nil
-}
\ No newline at end of file
+}
+
+//sniff refuses the files whose type or name the policy does not allow, before anything is decoded.
+//Only the first 512 bytes are used to sniff the content type of a file, br keeps them for the decoder.
+func sniff(br *bufio.Reader, filename string, policy *entity.UploadPolicy) error {
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return errors.New("cannot open file")
+	}
+	if !policy.Allows(http.DetectContentType(head), filename) {
+		return ErrFileType
+	}
+	return nil
+}
+
+//readImage decodes the picture within the image limits of policy, copying what it reads to seen.
+//What follows the picture is read too: it counts against the MaxBytes of policy and is copied to seen all the same.
+func (fu *fileUpload) readImage(r io.Reader, seen io.Writer, policy *entity.UploadPolicy) (image.Image, string, error) {
+	limited := &limitReader{r: r, n: policy.MaxBytes}
+	content := io.TeeReader(limited, seen)
+	img, format, err := decode(content, &policy.Image)
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, content)
+	}
+	if limited.exceeded() {
+		return nil, "", ErrImageTooLarge
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return img, format, nil
+}
+
+//contentKey names a file after the SHA-256 of its content, identical files get the same key
+func contentKey(prefix string, sum hash.Hash, ext string) string {
+	return prefix + hex.EncodeToString(sum.Sum(nil)) + ext
+}
+
+//limitReader fails once more than n bytes were read. io.LimitReader would cut the file short without telling.
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	//one byte past the limit is enough to know
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.exceeded() {
+		return n, ErrImageTooLarge
+	}
+	return n, err
+}
+
+func (l *limitReader) exceeded() bool {
+	return l.n < 0
+}
+
+//buffers hold the files on their way to the storage. With its size known S3 takes a file in one PUT,
+//a stream of unknown size costs a multipart upload and a part buffer of 5MiB.
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	buf := buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	buffers.Put(buf)
+}
+
+//retain takes a reference on key, storing the object with put when nobody has stored it yet.
+//When put fails the reference is given back, and remove cleans up if it was the last one.
+func (fu *fileUpload) retain(key string, put func() error, remove func() error) error {
+	if err := fu.refs.RetainObject(key); err != nil {
+		return err
+	}
+	_, err := fu.store.Stat(key)
+	if err == storage.ErrNotFound {
+		err = put()
+	}
+	if err != nil {
+		if releaseErr := fu.release(key, remove); releaseErr != nil {
+			log.Printf("upload: cannot release %s: %v", key, releaseErr)
+		}
+		return err
+	}
+	return nil
+}
+
+//release gives a reference on key back and runs remove when it was the last one
+func (fu *fileUpload) release(key string, remove func() error) error {
+	_, err := fu.refs.ReleaseObject(key, remove)
+	return err
+}
+
+//UploadAvatar decodes the picture as it streams in, only the decoded image is kept in memory
+func (fu *fileUpload) UploadAvatar(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	if err := sniff(br, filename, policy); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	img, _, err := fu.readScanned(br, hash, policy)
+	if err != nil {
+		return "", err
+	}
+	//the crops are made from the picture alone, so the same picture always gives the same avatar
+	key := contentKey(entity.AvatarPrefix, hash, "")
+	//the largest size is stored last, when it is there the avatar is complete
+	largest := entity.AvatarKey(key, entity.AvatarSizes[len(entity.AvatarSizes)-1])
+	err = fu.retain(largest, func() error {
+		for _, size := range entity.AvatarSizes {
+			data, err := encodeJPEG(CropSquare(img, size))
+			if err != nil {
+				return err
+			}
+			err = fu.store.Put(entity.AvatarKey(key, size), bytes.NewReader(data), int64(len(data)), "image/jpeg")
+			if err != nil {
+				log.Printf("avatar: cannot upload %s: %v", entity.AvatarKey(key, size), err)
+				return err
+			}
+		}
+		return nil
+	}, func() error {
+		//do not leave the sizes uploaded so far behind
+		return fu.deleteAvatarSizes(key)
+	})
+	if err != nil {
+		return "", errors.New("something went wrong")
+	}
+	return key, nil
+}
+
+//DeleteAvatar releases the avatar, every size of it is removed with its last reference
+func (fu *fileUpload) DeleteAvatar(key string) error {
+	largest := entity.AvatarKey(key, entity.AvatarSizes[len(entity.AvatarSizes)-1])
+	return fu.release(largest, func() error {
+		return fu.deleteAvatarSizes(key)
+	})
+}
+
+func (fu *fileUpload) deleteAvatarSizes(key string) error {
+	for _, size := range entity.AvatarSizes {
+		if err := fu.store.Delete(entity.AvatarKey(key, size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fu *fileUpload) DeleteFile(key string) error {
+	return fu.release(key, func() error {
+		//the variants were made from this file, other files never share them
+		fu.deleteVariants(fu.variantsOf(key))
+		return fu.store.Delete(key)
+	})
+}
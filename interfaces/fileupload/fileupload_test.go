@@ -0,0 +1,258 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/scanner"
+	"DDD/infrastructure/storage"
+	"DDD/utils/mock"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func pngBytes(t *testing.T, w, h int) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadFile_Success(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil)
+
+	key, err := fu.UploadFile(bytes.NewReader(pngBytes(t, 64, 64)), "photo.png", &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(key, entity.ImagePrefix))
+	assert.True(t, strings.HasSuffix(key, ".png"))
+	obj, err := store.Stat(key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "image/png", obj.ContentType)
+	//the file streamed in under a temporary key
+	incoming, _ := store.List(entity.IncomingPrefix)
+	assert.Empty(t, incoming)
+
+	assert.Nil(t, fu.DeleteFile(key))
+	_, err = store.Stat(key)
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
+
+func TestUploadFile_Deduplicated(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, counts := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil)
+	data := pngBytes(t, 64, 64)
+
+	first, err := fu.UploadFile(bytes.NewReader(data), "photo.png", &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+	//the name of the file does not matter, only its content
+	second, err := fu.UploadFile(bytes.NewReader(data), "copy.png", &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+	assert.EqualValues(t, first, second)
+	assert.EqualValues(t, 2, counts[first])
+	other, err := fu.UploadFile(bytes.NewReader(pngBytes(t, 65, 65)), "photo.png", &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first, other)
+
+	//the second product still shows the picture
+	assert.Nil(t, fu.DeleteFile(first))
+	_, err = store.Stat(first)
+	assert.Nil(t, err)
+	assert.Nil(t, fu.DeleteFile(first))
+	_, err = store.Stat(first)
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
+
+func TestUploadFile_NotAnImage(t *testing.T) {
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(storage.NewMemory(""), refs, nil)
+
+	_, err := fu.UploadFile(bytes.NewReader([]byte("plain text")), "notes.png", &entity.ProductImagePolicy)
+	assert.EqualValues(t, ErrFileType, err)
+	_, err = fu.UploadAvatar(bytes.NewReader([]byte("plain text")), "notes.png", &entity.AvatarPolicy)
+	assert.EqualValues(t, ErrFileType, err)
+	//the name has to agree with the content
+	_, err = fu.UploadFile(bytes.NewReader(pngBytes(t, 64, 64)), "photo.gif", &entity.ProductImagePolicy)
+	assert.EqualValues(t, ErrFileType, err)
+}
+
+func TestUploadFile_TooLarge(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, counts := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil)
+
+	//a valid header does not make up for the size, the upload stops past the limit
+	data := append(pngBytes(t, 64, 64), make([]byte, entity.AvatarPolicy.MaxBytes)...)
+	_, err := fu.UploadFile(bytes.NewReader(data), "photo.png", &entity.AvatarPolicy)
+	assert.EqualValues(t, ErrImageTooLarge, err)
+	_, err = fu.UploadAvatar(bytes.NewReader(data), "me.png", &entity.AvatarPolicy)
+	assert.EqualValues(t, ErrImageTooLarge, err)
+	objects, _ := store.List("")
+	assert.Empty(t, objects)
+	assert.Empty(t, counts)
+}
+
+func TestUploadAvatar_Success(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil)
+
+	key, err := fu.UploadAvatar(bytes.NewReader(pngBytes(t, 300, 200)), "me.png", &entity.AvatarPolicy)
+	assert.Nil(t, err)
+	for _, size := range entity.AvatarSizes {
+		obj, err := store.Stat(entity.AvatarKey(key, size))
+		assert.Nil(t, err)
+		assert.EqualValues(t, "image/jpeg", obj.ContentType)
+	}
+
+	assert.Nil(t, fu.DeleteAvatar(key))
+	_, err = store.Stat(entity.AvatarKey(key, entity.AvatarSizes[0]))
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
+
+//fakeS3 answers the requests storage.S3 makes, enough to run uploads through the real client. It counts the multipart uploads
+//and keeps the ACL every key was last written with.
+type fakeS3 struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	types      map[string]string
+	acls       map[string]string
+	multiparts int
+}
+
+func newFakeS3(t testing.TB) (*storage.S3, *fakeS3) {
+	f := &fakeS3{objects: map[string][]byte{}, types: map[string]string{}, acls: map[string]string{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	store, err := storage.NewS3(strings.TrimPrefix(server.URL, "http://"), "key", "secret", "bucket", "", false, entity.QuarantinePrefix, entity.IncomingPrefix)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	return store, f
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	if acl := r.Header.Get("X-Amz-Acl"); acl != "" {
+		f.acls[key] = acl
+	}
+	_, location := query["location"]
+	_, initiate := query["uploads"]
+	switch {
+	case location:
+		fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+	case r.Method == http.MethodPost && initiate:
+		f.multiparts++
+		f.objects[key] = nil
+		f.types[key] = r.Header.Get("Content-Type")
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><UploadId>1</UploadId></InitiateMultipartUploadResult>", key)
+	case r.Method == http.MethodPost:
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src := strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/bucket/")
+		f.objects[key] = f.objects[src]
+		f.types[key] = r.Header.Get("Content-Type")
+		fmt.Fprint(w, `<CopyObjectResult><LastModified>2020-01-01T00:00:00.000Z</LastModified><ETag>"etag"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		if query.Get("partNumber") != "" {
+			f.objects[key] = append(f.objects[key], data...)
+		} else {
+			f.objects[key] = data
+			f.types[key] = r.Header.Get("Content-Type")
+		}
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Content-Type", f.types[key])
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	}
+}
+
+//noisyPNG does not compress, it is about the size of a photo of its dimensions
+func noisyPNG(t testing.TB, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rnd := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rnd.Intn(256))
+	}
+	img.Set(0, 0, color.RGBA{A: 255})
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadFile_S3(t *testing.T) {
+	store, s3 := newFakeS3(t)
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil, scanner.NewHashDenylist(nil))
+
+	key, err := fu.UploadFile(bytes.NewReader(noisyPNG(t, 256, 256)), "photo.png", &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+	obj, err := store.Stat(key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "image/png", obj.ContentType)
+	//the quarantined and the clean copy both went in one PUT of a known size
+	assert.EqualValues(t, 0, s3.multiparts)
+	assert.EqualValues(t, 1, len(s3.objects))
+	//only the clean copy was ever readable from the bucket
+	for written, acl := range s3.acls {
+		if written == key {
+			assert.EqualValues(t, "public-read", acl)
+		} else {
+			assert.EqualValues(t, "private", acl, written)
+		}
+	}
+	assert.EqualValues(t, 3, len(s3.acls))
+}
+
+//BenchmarkUploadFile_S3 runs a photo through the scan and the storage of the S3 client, against a local server
+func BenchmarkUploadFile_S3(b *testing.B) {
+	store, _ := newFakeS3(b)
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil, scanner.NewHashDenylist(nil))
+	data := noisyPNG(b, 512, 512)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key, err := fu.UploadFile(bytes.NewReader(data), "photo.png", &entity.ProductImagePolicy)
+		if err != nil {
+			b.Fatalf("want non error, got %#v", err)
+		}
+		if err := fu.DeleteFile(key); err != nil {
+			b.Fatalf("want non error, got %#v", err)
+		}
+	}
+}
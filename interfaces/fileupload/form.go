@@ -0,0 +1,81 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+//maxFieldBytes bounds the text fields of a form together, the file is the only large part
+const maxFieldBytes = 1 << 20
+
+//Reasons ReadForm refuses a form
+var (
+	ErrInvalidForm    = errors.New("the form is invalid")
+	ErrFieldsTooLarge = errors.New("the form fields are too large")
+)
+
+//StoreFunc keeps the file of a form within policy and returns its key, UploadFile and UploadAvatar are such functions
+type StoreFunc func(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error)
+
+//ReadForm reads a multipart form one part at a time. The files of the fields of form are handed to store while they arrive, with the policy of their field,
+//so they never sit in memory, and the other fields end up in r.PostForm where c.PostForm finds them. A file in any other field makes the form invalid.
+//The keys stored are returned by field even with an error, the caller gives them back. Forms that are not multipart are left alone.
+func ReadForm(r *http.Request, form Form, store StoreFunc) (map[string][]string, error) {
+	keys := map[string][]string{}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return keys, nil
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return keys, ErrInvalidForm
+	}
+	values := url.Values{}
+	//gin reads the fields from there, it does not parse the body again
+	r.PostForm = values
+	r.Form = values
+	remaining := int64(maxFieldBytes)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return keys, ErrInvalidForm
+		}
+		name := part.FormName()
+		if part.FileName() != "" {
+			policy, ok := form[name]
+			if !ok {
+				part.Close()
+				return keys, ErrInvalidForm
+			}
+			if len(keys[name]) >= policy.MaxCount {
+				part.Close()
+				return keys, ErrTooManyFiles
+			}
+			key, err := store(part, part.FileName(), policy)
+			part.Close()
+			if err != nil {
+				return keys, err
+			}
+			keys[name] = append(keys[name], key)
+			continue
+		}
+		value, err := ioutil.ReadAll(io.LimitReader(part, remaining+1))
+		part.Close()
+		if err != nil {
+			return keys, ErrInvalidForm
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return keys, ErrFieldsTooLarge
+		}
+		values.Add(name, string(value))
+	}
+}
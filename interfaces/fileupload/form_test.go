@@ -0,0 +1,181 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/scanner"
+	"DDD/infrastructure/storage"
+	"DDD/utils/mock"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+//formRequest builds a multipart request with the fields first and then the files, each file under the field of its name
+func formRequest(fields map[string]string, files map[string][]byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		_ = writer.WriteField(name, value)
+	}
+	for name, data := range files {
+		part, _ := writer.CreateFormFile(name, name+".png")
+		_, _ = part.Write(data)
+	}
+	_ = writer.Close()
+	req, _ := http.NewRequest(http.MethodPost, "/food", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+//testForm takes one small picture in product_image
+var testForm = Form{"product_image": &entity.UploadPolicy{MaxBytes: 1024, Types: entity.ImageTypes, MaxCount: 1}}
+
+func TestReadForm_Success(t *testing.T) {
+	req := formRequest(map[string]string{"title": "Pancakes"}, map[string][]byte{"product_image": []byte("picture")})
+	var stored string
+	keys, err := ReadForm(req, testForm, func(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error) {
+		assert.EqualValues(t, testForm["product_image"], policy)
+		data, _ := ioutil.ReadAll(r)
+		stored = string(data)
+		return "images/" + filename, nil
+	})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"images/product_image.png"}, keys["product_image"])
+	assert.EqualValues(t, "picture", stored)
+	assert.EqualValues(t, "Pancakes", req.PostForm.Get("title"))
+}
+
+func TestReadForm_Refused(t *testing.T) {
+	store := func(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error) {
+		return "images/a.png", nil
+	}
+
+	//only the fields of the form may hold a file
+	req := formRequest(nil, map[string][]byte{"other": []byte("picture")})
+	_, err := ReadForm(req, testForm, store)
+	assert.EqualValues(t, ErrInvalidForm, err)
+
+	//a field takes no more files than its policy allows, the ones stored are given back to be released
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for i := 0; i < 2; i++ {
+		part, _ := writer.CreateFormFile("product_image", "photo.png")
+		_, _ = part.Write([]byte("picture"))
+	}
+	_ = writer.Close()
+	req, _ = http.NewRequest(http.MethodPost, "/food", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	keys, err := ReadForm(req, testForm, store)
+	assert.EqualValues(t, ErrTooManyFiles, err)
+	assert.EqualValues(t, []string{"images/a.png"}, keys["product_image"])
+
+	//the fields are not read past their limit
+	req = formRequest(map[string]string{"description": strings.Repeat("a", maxFieldBytes+1)}, nil)
+	_, err = ReadForm(req, testForm, store)
+	assert.EqualValues(t, ErrFieldsTooLarge, err)
+
+	//other forms are parsed by gin as before
+	req, _ = http.NewRequest(http.MethodPost, "/food", strings.NewReader("title=Pancakes"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	keys, err = ReadForm(req, testForm, store)
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+}
+
+//benchmarkBody is a form holding a photo of about 4MB, the size the upload routes used to buffer
+func benchmarkBody(b *testing.B) ([]byte, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("title", "Pancakes")
+	part, _ := writer.CreateFormFile("product_image", "photo.png")
+	_, _ = part.Write(noisyPNG(b, 1024, 1024))
+	if err := writer.Close(); err != nil {
+		b.Fatalf("want non error, got %#v", err)
+	}
+	return body.Bytes(), writer.FormDataContentType()
+}
+
+//benchmarkUpload stores the form with UploadFile against the memory store, with a scanner or without any. The image is released
+//after each run so the store does not grow.
+func benchmarkUpload(b *testing.B, scanned bool, read func(req *http.Request, store StoreFunc) error) {
+	var scanners []scanner.Scanner
+	if scanned {
+		scanners = append(scanners, scanner.NewHashDenylist(nil))
+	}
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(storage.NewMemory(""), refs, nil, scanners...)
+	data, contentType := benchmarkBody(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest(http.MethodPost, "/food", bytes.NewReader(data))
+		req.Header.Set("Content-Type", contentType)
+		var keys []string
+		err := read(req, func(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error) {
+			key, err := fu.UploadFile(r, filename, policy)
+			keys = append(keys, key)
+			return key, err
+		})
+		if err != nil {
+			b.Fatalf("want non error, got %#v", err)
+		}
+		for _, key := range keys {
+			if err := fu.DeleteFile(key); err != nil {
+				b.Fatalf("want non error, got %#v", err)
+			}
+		}
+	}
+}
+
+//readBuffered is how the uploads were read before: the whole body in memory, parsed by gin, then the file copied into a buffer of its size
+func readBuffered(req *http.Request, store StoreFunc) error {
+	body, _ := ioutil.ReadAll(req.Body)
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	if err := req.ParseMultipartForm(32 << 20); err != nil {
+		return err
+	}
+	header := req.MultipartForm.File["product_image"][0]
+	f, _ := header.Open()
+	buffer := make([]byte, header.Size)
+	_, _ = io.ReadFull(f, buffer)
+	f.Close()
+	_, err := store(bytes.NewReader(buffer), header.Filename, &entity.ProductImagePolicy)
+	return err
+}
+
+func readStreamed(req *http.Request, store StoreFunc) error {
+	_, err := ReadForm(req, Form{"product_image": &entity.ProductImagePolicy}, store)
+	return err
+}
+
+//The form is a 4MB photo, decoded and encoded again whichever way it is read. With go test -bench Form -benchtime 20x:
+//
+//	BenchmarkForm_Buffered          56.8MB/op
+//	BenchmarkForm_Streamed          15.9MB/op
+//	BenchmarkForm_BufferedScanned   64.8MB/op
+//	BenchmarkForm_StreamedScanned   26.3MB/op
+//
+//Streaming saves the body, the parsed form and the copy of the file. What is left is the decoded picture and the copies the
+//memory store keeps, of the clean file and with a scanner of the quarantined one. The pooled buffers are reused from run to run.
+
+func BenchmarkForm_Buffered(b *testing.B) {
+	benchmarkUpload(b, false, readBuffered)
+}
+
+func BenchmarkForm_Streamed(b *testing.B) {
+	benchmarkUpload(b, false, readStreamed)
+}
+
+func BenchmarkForm_BufferedScanned(b *testing.B) {
+	benchmarkUpload(b, true, readBuffered)
+}
+
+func BenchmarkForm_StreamedScanned(b *testing.B) {
+	benchmarkUpload(b, true, readStreamed)
+}
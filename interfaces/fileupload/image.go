@@ -0,0 +1,40 @@
+package fileupload
+
+import (
+	"bytes"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	//decoders for the formats we accept
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+	_ "image/png"
+)
+
+//CropSquare cuts the largest centered square out of src and scales it to size x size
+func CropSquare(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	square := image.Rect(x0, y0, x0+side, y0+side)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	//JPEG has no transparency, so transparent pixels become white instead of black
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, square, draw.Over, nil)
+	return dst
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
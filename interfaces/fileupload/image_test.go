@@ -0,0 +1,39 @@
+package fileupload
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCropSquare(t *testing.T) {
+	//a landscape picture: red left third, green middle, blue right third
+	src := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for x := 0; x < 300; x++ {
+		c := color.RGBA{G: 255, A: 255}
+		if x < 100 {
+			c = color.RGBA{R: 255, A: 255}
+		} else if x >= 200 {
+			c = color.RGBA{B: 255, A: 255}
+		}
+		for y := 0; y < 100; y++ {
+			src.Set(x, y, c)
+		}
+	}
+	dst := CropSquare(src, 64)
+
+	assert.EqualValues(t, image.Rect(0, 0, 64, 64), dst.Bounds())
+	//only the green middle survives the crop
+	for _, p := range []image.Point{{0, 0}, {32, 32}, {63, 63}} {
+		r, g, b, _ := dst.At(p.X, p.Y).RGBA()
+		assert.True(t, g > 0xf000 && r < 0x1000 && b < 0x1000, "pixel %v is %v", p, dst.At(p.X, p.Y))
+	}
+}
+
+func TestCropSquare_Transparent(t *testing.T) {
+	dst := CropSquare(image.NewNRGBA(image.Rect(0, 0, 10, 20)), 8)
+
+	assert.EqualValues(t, image.Rect(0, 0, 8, 8), dst.Bounds())
+	assert.EqualValues(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, dst.At(4, 4))
+}
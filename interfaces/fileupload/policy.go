@@ -0,0 +1,15 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"errors"
+)
+
+//Form declares the policy of each field of a route that takes files. A file in any other field makes the form invalid.
+type Form map[string]*entity.UploadPolicy
+
+//Reasons a policy refuses a file, besides ErrImageTooLarge
+var (
+	ErrFileType     = errors.New("the type of the file is not allowed")
+	ErrTooManyFiles = errors.New("too many files were sent")
+)
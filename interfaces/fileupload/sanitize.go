@@ -0,0 +1,181 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"bytes"
+	"errors"
+	"golang.org/x/image/draw"
+	"image"
+	"image/png"
+	"io"
+)
+
+//Reasons an image is refused, besides those of its policy
+var (
+	ErrImageInvalid  = errors.New("the image is damaged or not what it claims to be")
+	ErrImagePixels   = errors.New("the image has too many pixels")
+	ErrImageTooSmall = errors.New("the image is too small")
+	ErrImageTooWide  = errors.New("the image is too wide or too tall")
+)
+
+//decode reads the image in full, so that files which only look like images are refused, and turns it upright following its EXIF orientation
+func decode(r io.Reader, l *entity.ImageLimits) (image.Image, string, error) {
+	//the bytes the header is read from are decoded again with the rest
+	var header bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, "", ErrImageInvalid
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(header.Bytes())
+	}
+	width, height := config.Width, config.Height
+	//orientations 5 to 8 turn the picture on its side
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	if int64(width)*int64(height) > l.MaxPixels {
+		return nil, "", ErrImagePixels
+	}
+	if width < l.MinWidth || height < l.MinHeight {
+		return nil, "", ErrImageTooSmall
+	}
+	if (l.MaxWidth > 0 && width > l.MaxWidth) || (l.MaxHeight > 0 && height > l.MaxHeight) {
+		return nil, "", ErrImageTooWide
+	}
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, "", ErrImageInvalid
+	}
+	return orient(img, orientation), format, nil
+}
+
+//cleanEncoder writes the decoded image again, leaving the metadata and anything hidden in the file behind.
+//Photos stay JPEG, every other format becomes PNG, which keeps transparency. Animated GIFs keep their first frame.
+func cleanEncoder(format string) Encoder {
+	if format == "jpeg" {
+		return NewJPEGEncoder(90)
+	}
+	return NewPNGEncoder()
+}
+
+type pngEncoder struct{}
+
+//NewPNGEncoder writes lossless images that keep their transparency
+func NewPNGEncoder() Encoder {
+	return pngEncoder{}
+}
+
+func (pngEncoder) Format() string      { return "png" }
+func (pngEncoder) ContentType() string { return "image/png" }
+func (pngEncoder) Opaque() bool        { return false }
+
+func (pngEncoder) Encode(w io.Writer, img image.Image) error {
+	return png.Encode(w, img)
+}
+
+//orient applies an EXIF orientation, 1 being upright. The pixels are copied so that the result is upright without its metadata.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			//the pixel of src that ends up at x, y
+			var sx, sy int
+			switch orientation {
+			case 2: //mirrored
+				sx, sy = w-1-x, y
+			case 3: //upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: //mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: //mirrored on its left side
+				sx, sy = y, x
+			case 6: //on its left side, turned clockwise
+				sx, sy = y, h-1-x
+			case 7: //mirrored on its right side
+				sx, sy = w-1-y, h-1-x
+			case 8: //on its right side, turned counterclockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+//exifOrientation finds the orientation tag in the EXIF segment of a JPEG file, 1 when there is none
+func exifOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		//the image data starts, the metadata comes before
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(data[i+2])<<8 | int(data[i+3])
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+//tiffOrientation reads tag 0x0112 of the first IFD of the TIFF structure EXIF is stored in
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var u16 func([]byte) int
+	var u32 func([]byte) int
+	switch string(tiff[:2]) {
+	case "II":
+		u16 = func(b []byte) int { return int(b[0]) | int(b[1])<<8 }
+		u32 = func(b []byte) int { return u16(b) | u16(b[2:])<<16 }
+	case "MM":
+		u16 = func(b []byte) int { return int(b[0])<<8 | int(b[1]) }
+		u32 = func(b []byte) int { return u16(b)<<16 | u16(b[2:]) }
+	default:
+		return 1
+	}
+	ifd := u32(tiff[4:])
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := u16(tiff[ifd:])
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if u16(tiff[entry:]) == 0x0112 {
+			//a SHORT, stored at the start of the value field
+			if orientation := u16(tiff[entry+8:]); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
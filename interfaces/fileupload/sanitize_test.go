@@ -0,0 +1,213 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/storage"
+	"DDD/utils/mock"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//exifJPEG encodes img as a JPEG with an EXIF segment holding the orientation and a made up GPS position
+func exifJPEG(t *testing.T, img image.Image, orientation byte) []byte {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08" +
+		//two entries: the orientation, a SHORT, and a pointer to the GPS IFD
+		"\x00\x02" +
+		"\x01\x12\x00\x03\x00\x00\x00\x01\x00" + string([]byte{orientation}) + "\x00\x00" +
+		"\x88\x25\x00\x04\x00\x00\x00\x01\x00\x00\x00\x26" +
+		"\x00\x00\x00\x00" +
+		"GPS 52.5200N 13.4050E")
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+	app1 := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, segment...)
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+//halves is red on the left and blue on the right
+func halves(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestExifOrientation(t *testing.T) {
+	for _, orientation := range []byte{1, 3, 6, 8} {
+		assert.EqualValues(t, orientation, exifOrientation(exifJPEG(t, halves(8, 4), orientation)))
+	}
+	plain := &bytes.Buffer{}
+	_ = jpeg.Encode(plain, halves(8, 4), nil)
+	assert.EqualValues(t, 1, exifOrientation(plain.Bytes()))
+	assert.EqualValues(t, 1, exifOrientation([]byte("not a jpeg")))
+	//a segment cut short is not read past its end
+	assert.EqualValues(t, 1, exifOrientation(exifJPEG(t, halves(8, 4), 6)[:30]))
+}
+
+func TestOrient(t *testing.T) {
+	src := halves(8, 4)
+	red := color.NRGBA{R: 255, A: 255}
+
+	//turned clockwise, the left half is on top
+	img := orient(src, 6)
+	assert.EqualValues(t, image.Rect(0, 0, 4, 8), img.Bounds())
+	assert.EqualValues(t, red, img.At(0, 0))
+	assert.EqualValues(t, red, img.At(3, 3))
+	assert.NotEqual(t, red, img.At(0, 7))
+
+	//turned counterclockwise, it is at the bottom
+	img = orient(src, 8)
+	assert.EqualValues(t, red, img.At(0, 7))
+	assert.NotEqual(t, red, img.At(0, 0))
+
+	img = orient(src, 3)
+	assert.EqualValues(t, image.Rect(0, 0, 8, 4), img.Bounds())
+	assert.EqualValues(t, red, img.At(7, 0))
+
+	assert.EqualValues(t, src, orient(src, 1))
+}
+
+func TestUploadFile_Sanitized(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil)
+
+	data := exifJPEG(t, halves(128, 64), 6)
+	assert.True(t, bytes.Contains(data, []byte("GPS")))
+	key, err := fu.UploadFile(bytes.NewReader(data), "photo.jpg", &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+
+	r, obj, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	stored, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.EqualValues(t, "image/jpeg", obj.ContentType)
+	assert.False(t, bytes.Contains(stored, []byte("Exif")))
+	assert.False(t, bytes.Contains(stored, []byte("GPS")))
+	//the picture was turned upright, it is no longer on its side
+	config, _, err := image.DecodeConfig(bytes.NewReader(stored))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 64, config.Width)
+	assert.EqualValues(t, 128, config.Height)
+}
+
+func TestCheckUpload_Sanitized(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, counts := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil)
+
+	//a picture with its GPS position and a page behind it, uploaded straight to the storage
+	data := append(exifJPEG(t, halves(128, 64), 6), []byte("<html><script>alert(1)</script>")...)
+	_ = store.Put("quarantine/a.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg")
+	key, err := fu.CheckUpload("quarantine/a.jpg", "image/jpeg", int64(len(data)), &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+	//the image is stored again like the form uploads are, and referenced for the product
+	assert.True(t, strings.HasPrefix(key, entity.ImagePrefix))
+	assert.EqualValues(t, 1, counts[key])
+	_, err = store.Stat("quarantine/a.jpg")
+	assert.Nil(t, err)
+
+	r, _, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	stored, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.False(t, bytes.Contains(stored, []byte("GPS")))
+	assert.False(t, bytes.Contains(stored, []byte("<script>")))
+	config, _, err := image.DecodeConfig(bytes.NewReader(stored))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 64, config.Width)
+
+	//the image limits hold for direct uploads too, the header is enough to refuse the file
+	policy := entity.ProductImagePolicy
+	policy.Image = entity.ImageLimits{MaxPixels: 100 * 100}
+	bomb := pngBytes(t, 200, 100)
+	_ = store.Put("quarantine/b.png", bytes.NewReader(bomb), int64(len(bomb)), "image/png")
+	_, err = fu.CheckUpload("quarantine/b.png", "image/png", int64(len(bomb)), &policy)
+	assert.EqualValues(t, ErrImagePixels, err)
+	_, err = store.Stat("quarantine/b.png")
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
+
+func TestCheckUpload_Policy(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil)
+	data := pngBytes(t, 100, 32)
+	put := func(key string) string {
+		_ = store.Put(key, bytes.NewReader(data), int64(len(data)), "image/png")
+		return key
+	}
+
+	//the dimensions are those of the policy, like for the form uploads
+	_, err := fu.CheckUpload(put("quarantine/a.png"), "image/png", 1024, &entity.ProductImagePolicy)
+	assert.EqualValues(t, ErrImageTooSmall, err)
+
+	policy := entity.ProductImagePolicy
+	policy.Image.MinHeight = 32
+	//the type has to be one of the policy, and the key has to end with one of its extensions
+	policy.Types = map[string][]string{"image/jpeg": {".jpg"}}
+	_, err = fu.CheckUpload(put("quarantine/b.png"), "image/png", 1024, &policy)
+	assert.EqualValues(t, ErrUploadType, err)
+	policy.Types = map[string][]string{"image/png": {".png"}}
+	_, err = fu.CheckUpload(put("quarantine/c.jpg"), "image/png", 1024, &policy)
+	assert.EqualValues(t, ErrUploadType, err)
+
+	//the policy may have become smaller than the intent
+	policy.MaxBytes = int64(len(data)) - 1
+	_, err = fu.CheckUpload(put("quarantine/d.png"), "image/png", 1024, &policy)
+	assert.EqualValues(t, ErrUploadTooLarge, err)
+
+	policy.MaxBytes = 1024
+	key, err := fu.CheckUpload(put("quarantine/e.png"), "image/png", 1024, &policy)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(key, entity.ImagePrefix))
+	//every refused file was deleted
+	quarantined, _ := store.List(entity.QuarantinePrefix)
+	assert.EqualValues(t, 1, len(quarantined))
+}
+
+func TestUploadFile_Dimensions(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, nil)
+	policy := entity.ProductImagePolicy
+	policy.Image = entity.ImageLimits{MaxPixels: 100 * 100, MinWidth: 64, MinHeight: 64}
+
+	_, err := fu.UploadFile(bytes.NewReader(pngBytes(t, 200, 100)), "wide.png", &policy)
+	assert.EqualValues(t, ErrImagePixels, err)
+	_, err = fu.UploadFile(bytes.NewReader(pngBytes(t, 100, 32)), "thin.png", &policy)
+	assert.EqualValues(t, ErrImageTooSmall, err)
+	_, err = fu.UploadAvatar(bytes.NewReader(pngBytes(t, 200, 100)), "me.png", &policy)
+	assert.EqualValues(t, ErrImagePixels, err)
+	//the signature of a PNG with anything behind it
+	_, err = fu.UploadFile(bytes.NewReader(append(pngBytes(t, 64, 64)[:16], make([]byte, 64)...)), "fake.png", &policy)
+	assert.EqualValues(t, ErrImageInvalid, err)
+	//within the pixels, but not the shape the policy asks for
+	policy.Image.MaxWidth, policy.Image.MaxHeight = 120, 80
+	_, err = fu.UploadFile(bytes.NewReader(pngBytes(t, 64, 96)), "tall.png", &policy)
+	assert.EqualValues(t, ErrImageTooWide, err)
+
+	objects, _ := store.List("")
+	assert.Empty(t, objects)
+}
@@ -0,0 +1,64 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"bytes"
+	"errors"
+	"github.com/twinj/uuid"
+	"image"
+	"io"
+	"log"
+)
+
+//Reasons the scanners refuse a file
+var (
+	ErrInfected   = errors.New("the file did not pass the virus scan")
+	ErrScanFailed = errors.New("the file could not be scanned, please try again later")
+)
+
+//readScanned is readImage for when scanners are set: the file, as it came, is held under QuarantinePrefix once it is read,
+//then every scanner reads it back. Nothing is stored under a public key before they all passed it.
+func (fu *fileUpload) readScanned(r io.Reader, seen io.Writer, policy *entity.UploadPolicy) (image.Image, string, error) {
+	if len(fu.scanners) == 0 {
+		return fu.readImage(r, seen, policy)
+	}
+	//readImage stops one byte past the limit, that is all the buffer holds
+	buf := getBuffer()
+	defer putBuffer(buf)
+	img, format, err := fu.readImage(r, io.MultiWriter(seen, buf), policy)
+	if err != nil {
+		return nil, "", err
+	}
+	quarantined := entity.QuarantinePrefix + uuid.NewV4().String()
+	defer fu.discard(quarantined)
+	if err := fu.store.Put(quarantined, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "application/octet-stream"); err != nil {
+		log.Printf("upload: cannot quarantine %s: %v", quarantined, err)
+		return nil, "", errors.New("something went wrong")
+	}
+	if err := fu.scan(quarantined); err != nil {
+		return nil, "", err
+	}
+	return img, format, nil
+}
+
+//scan runs every scanner over the object under key, the first one that finds something refuses the file
+func (fu *fileUpload) scan(key string) error {
+	for _, scanner := range fu.scanners {
+		r, _, err := fu.store.Get(key)
+		if err != nil {
+			log.Printf("upload: cannot read %s back for the scan: %v", key, err)
+			return ErrScanFailed
+		}
+		found, err := scanner.Scan(r)
+		r.Close()
+		if err != nil {
+			log.Printf("upload: cannot scan %s: %v", key, err)
+			return ErrScanFailed
+		}
+		if found != "" {
+			log.Printf("upload: %s refused, %s found", key, found)
+			return ErrInfected
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/scanner"
+	"DDD/infrastructure/storage"
+	"DDD/utils/mock"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//scannerFunc makes a scanner of a function
+type scannerFunc func(r io.Reader) (string, error)
+
+func (f scannerFunc) Scan(r io.Reader) (string, error) {
+	return f(r)
+}
+
+func TestUploadFile_Scanned(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	data := exifJPEG(t, halves(128, 64), 1)
+	var scanned []byte
+	recorder := scannerFunc(func(r io.Reader) (string, error) {
+		scanned, _ = ioutil.ReadAll(r)
+		//the file waits in quarantine, nothing is public yet
+		objects, _ := store.List("")
+		assert.EqualValues(t, 1, len(objects))
+		assert.True(t, strings.HasPrefix(objects[0].Key, entity.QuarantinePrefix))
+		return "", nil
+	})
+	fu := NewFileUpload(store, refs, nil, recorder)
+
+	key, err := fu.UploadFile(bytes.NewReader(data), "photo.jpg", &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+	//the scanner reads the file as it was sent, metadata included
+	assert.EqualValues(t, data, scanned)
+	quarantined, _ := store.List(entity.QuarantinePrefix)
+	assert.Empty(t, quarantined)
+	_, err = store.Stat(key)
+	assert.Nil(t, err)
+}
+
+func TestUploadFile_Infected(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	data := pngBytes(t, 64, 64)
+	sum := sha256.Sum256(data)
+	denylist := scanner.NewHashDenylist([]string{hex.EncodeToString(sum[:])})
+	fu := NewFileUpload(store, refs, nil, denylist)
+
+	_, err := fu.UploadFile(bytes.NewReader(data), "photo.png", &entity.ProductImagePolicy)
+	assert.EqualValues(t, ErrInfected, err)
+	_, err = fu.UploadAvatar(bytes.NewReader(data), "me.png", &entity.AvatarPolicy)
+	assert.EqualValues(t, ErrInfected, err)
+	objects, _ := store.List("")
+	assert.Empty(t, objects)
+
+	//a scanner that cannot be reached refuses everything
+	down := scannerFunc(func(r io.Reader) (string, error) {
+		return "", errors.New("connection refused")
+	})
+	fu = NewFileUpload(store, refs, nil, denylist, down)
+	_, err = fu.UploadFile(bytes.NewReader(pngBytes(t, 64, 80)), "photo.png", &entity.ProductImagePolicy)
+	assert.EqualValues(t, ErrScanFailed, err)
+	objects, _ = store.List("")
+	assert.Empty(t, objects)
+}
+
+func TestCheckUpload_Quarantine(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	clean, infected := pngBytes(t, 64, 64), pngBytes(t, 64, 80)
+	sum := sha256.Sum256(infected)
+	fu := NewFileUpload(store, refs, nil, scanner.NewHashDenylist([]string{hex.EncodeToString(sum[:])}))
+
+	_ = store.Put("quarantine/a.png", bytes.NewReader(clean), int64(len(clean)), "image/png")
+	key, err := fu.CheckUpload("quarantine/a.png", "image/png", 1024, &entity.ProductImagePolicy)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(key, entity.ImagePrefix))
+	//the uploaded file is kept until the upload is confirmed
+	_, err = store.Stat("quarantine/a.png")
+	assert.Nil(t, err)
+	assert.Nil(t, fu.DeleteUpload("quarantine/a.png"))
+	_, err = store.Stat("quarantine/a.png")
+	assert.EqualValues(t, storage.ErrNotFound, err)
+
+	//the object keeps the type it was put with, it has to be the type of the intent
+	_ = store.Put("quarantine/c.png", bytes.NewReader(clean), int64(len(clean)), "text/html")
+	_, err = fu.CheckUpload("quarantine/c.png", "image/png", 1024, &entity.ProductImagePolicy)
+	assert.EqualValues(t, ErrUploadType, err)
+	_, err = store.Stat("quarantine/c.png")
+	assert.EqualValues(t, storage.ErrNotFound, err)
+
+	_ = store.Put("quarantine/b.png", bytes.NewReader(infected), int64(len(infected)), "image/png")
+	_, err = fu.CheckUpload("quarantine/b.png", "image/png", 1024, &entity.ProductImagePolicy)
+	assert.EqualValues(t, ErrInfected, err)
+	_, err = store.Stat("quarantine/b.png")
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
@@ -0,0 +1,145 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"bytes"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+)
+
+//Encoder writes images in one format
+type Encoder interface {
+	//Format names the format in srcsets and is the extension of the variant keys
+	Format() string
+	ContentType() string
+	//Opaque encoders get the image on a white background
+	Opaque() bool
+	Encode(w io.Writer, img image.Image) error
+}
+
+type jpegEncoder struct {
+	quality int
+}
+
+func NewJPEGEncoder(quality int) Encoder {
+	return &jpegEncoder{quality: quality}
+}
+
+func (e *jpegEncoder) Format() string      { return "jpg" }
+func (e *jpegEncoder) ContentType() string { return "image/jpeg" }
+func (e *jpegEncoder) Opaque() bool        { return true }
+
+func (e *jpegEncoder) Encode(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: e.quality})
+}
+
+//Variants says which resized copies are made of every product image
+type Variants struct {
+	//Widths in pixels, an image is never enlarged so narrower images get fewer variants
+	Widths   []int
+	Encoders []Encoder
+	//MaxPixels is the largest image variants are made of, the MaxPixels of entity.DefaultImageLimits when 0.
+	//The stored images passed the limits of their upload, unless they were stored before there were any.
+	MaxPixels int64
+}
+
+//DefaultVariants are JPEG copies for thumbnails, phones and desktops
+var DefaultVariants = Variants{
+	Widths:   []int{150, 480, 1024},
+	Encoders: []Encoder{NewJPEGEncoder(85)},
+}
+
+func (v *Variants) limits() *entity.ImageLimits {
+	if v.MaxPixels > 0 {
+		return &entity.ImageLimits{MaxPixels: v.MaxPixels}
+	}
+	return &entity.ImageLimits{MaxPixels: entity.DefaultImageLimits.MaxPixels}
+}
+
+//Resize scales src to the given width, keeping its aspect ratio
+func Resize(src image.Image, width int, opaque bool) *image.RGBA {
+	b := src.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if opaque {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+//GenerateVariants reads the image stored under key and stores its variants next to it. Variants already stored, because
+//another product shares the image, are kept as they are. Nothing is left behind when it fails.
+func (fu *fileUpload) GenerateVariants(key string) (entity.ImageVariants, error) {
+	if fu.variants == nil {
+		return nil, nil
+	}
+	r, _, err := fu.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	//the size is read from the header first, an image too large is refused before its pixels are allocated
+	src, _, err := decode(r, fu.variants.limits())
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	var variants, stored entity.ImageVariants
+	for _, width := range fu.variants.Widths {
+		if width > src.Bounds().Dx() {
+			continue
+		}
+		for _, encoder := range fu.variants.Encoders {
+			variant := entity.ImageVariant{Width: width, Format: encoder.Format(), Key: entity.VariantKey(key, width, encoder.Format())}
+			if _, err := fu.store.Stat(variant.Key); err == nil {
+				variants = append(variants, variant)
+				continue
+			}
+			if err := fu.putVariant(variant, encoder, Resize(src, width, encoder.Opaque())); err != nil {
+				fu.deleteVariants(stored)
+				return nil, err
+			}
+			stored = append(stored, variant)
+			variants = append(variants, variant)
+		}
+	}
+	return variants, nil
+}
+
+//variantsOf lists every variant the current settings can make of the image stored under key, stored or not
+func (fu *fileUpload) variantsOf(key string) entity.ImageVariants {
+	if fu.variants == nil {
+		return nil
+	}
+	var variants entity.ImageVariants
+	for _, width := range fu.variants.Widths {
+		for _, encoder := range fu.variants.Encoders {
+			variants = append(variants, entity.ImageVariant{Width: width, Format: encoder.Format(), Key: entity.VariantKey(key, width, encoder.Format())})
+		}
+	}
+	return variants
+}
+
+func (fu *fileUpload) putVariant(variant entity.ImageVariant, encoder Encoder, img image.Image) error {
+	buf := &bytes.Buffer{}
+	if err := encoder.Encode(buf, img); err != nil {
+		return err
+	}
+	return fu.store.Put(variant.Key, buf, int64(buf.Len()), encoder.ContentType())
+}
+
+//deleteVariants removes the stored variants, failures are only logged since the variants are not referenced anymore
+func (fu *fileUpload) deleteVariants(variants entity.ImageVariants) {
+	for _, variant := range variants {
+		if err := fu.store.Delete(variant.Key); err != nil {
+			log.Printf("variants: cannot delete %s: %v", variant.Key, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package fileupload
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/storage"
+	"DDD/utils/mock"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateVariants(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, &Variants{Widths: []int{150, 480, 1024}, Encoders: []Encoder{NewJPEGEncoder(85)}})
+	data := pngBytes(t, 600, 300)
+	assert.Nil(t, store.Put("photo.png", bytes.NewReader(data), int64(len(data)), "image/png"))
+
+	variants, err := fu.GenerateVariants("photo.png")
+	assert.Nil(t, err)
+	//the picture is narrower than 1024 pixels, it is not enlarged
+	assert.EqualValues(t, entity.ImageVariants{
+		{Width: 150, Format: "jpg", Key: "photo/150.jpg"},
+		{Width: 480, Format: "jpg", Key: "photo/480.jpg"},
+	}, variants)
+
+	r, obj, err := store.Get("photo/480.jpg")
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	defer r.Close()
+	assert.EqualValues(t, "image/jpeg", obj.ContentType)
+	img, err := jpeg.Decode(r)
+	assert.Nil(t, err)
+	assert.EqualValues(t, image.Rect(0, 0, 480, 240), img.Bounds())
+
+	//the variants go with the image
+	assert.Nil(t, fu.DeleteFile("photo.png"))
+	_, err = store.Stat("photo/150.jpg")
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
+
+func TestGenerateVariants_NotAnImage(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, &DefaultVariants)
+	assert.Nil(t, store.Put("notes.png", strings.NewReader("plain text"), 10, "image/png"))
+
+	_, err := fu.GenerateVariants("notes.png")
+	assert.NotNil(t, err)
+}
+
+func TestGenerateVariants_TooManyPixels(t *testing.T) {
+	store := storage.NewMemory("")
+	refs, _ := mock.CountedRefs()
+	fu := NewFileUpload(store, refs, &Variants{Widths: []int{150}, Encoders: []Encoder{NewJPEGEncoder(85)}, MaxPixels: 100 * 100})
+	data := pngBytes(t, 600, 300)
+	assert.Nil(t, store.Put("photo.png", bytes.NewReader(data), int64(len(data)), "image/png"))
+
+	_, err := fu.GenerateVariants("photo.png")
+	assert.EqualValues(t, ErrImagePixels, err)
+	_, err = store.Stat("photo/150.jpg")
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
+
+//TestCWebPEncoder runs a stand-in for cwebp that checks its arguments and writes a fixed output
+func TestCWebPEncoder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwebp")
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "cwebp")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\n"+
+		"[ \"$3\" = 80 ] || exit 1\n"+
+		"head -c 8 \"$6\" | grep -q PNG || exit 1\n"+
+		"printf RIFFWEBP > \"$8\"\n"), 0755)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	encoder, err := NewCWebPEncoder(script, 80)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	buf := &bytes.Buffer{}
+	assert.Nil(t, encoder.Encode(buf, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+	assert.EqualValues(t, "RIFFWEBP", buf.String())
+	assert.EqualValues(t, "webp", encoder.Format())
+
+	_, err = NewCWebPEncoder(filepath.Join(dir, "missing"), 80)
+	assert.NotNil(t, err)
+}
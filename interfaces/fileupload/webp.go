@@ -0,0 +1,74 @@
+package fileupload
+
+import (
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+//cwebpEncoder makes WebP files with the cwebp tool of libwebp, the standard library has no WebP encoder
+type cwebpEncoder struct {
+	path    string
+	quality int
+}
+
+//NewCWebPEncoder finds cwebp at path, or on the PATH when path is empty
+func NewCWebPEncoder(path string, quality int) (Encoder, error) {
+	if path == "" {
+		path = "cwebp"
+	}
+	found, err := exec.LookPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return &cwebpEncoder{path: found, quality: quality}, nil
+}
+
+func (e *cwebpEncoder) Format() string      { return "webp" }
+func (e *cwebpEncoder) ContentType() string { return "image/webp" }
+func (e *cwebpEncoder) Opaque() bool        { return false }
+
+//Encode goes through temporary files, cwebp cannot read from a pipe everywhere
+func (e *cwebpEncoder) Encode(w io.Writer, img image.Image) error {
+	dir, err := ioutil.TempDir("", "cwebp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	in, err := os.Create(dir + "/in.png")
+	if err != nil {
+		return err
+	}
+	err = png.Encode(in, img)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	out := dir + "/out.webp"
+	cmd := exec.Command(e.path, "-quiet", "-q", strconv.Itoa(e.quality), "-metadata", "none", in.Name(), "-o", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return &encodeError{err: err, output: string(output)}
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+type encodeError struct {
+	err    error
+	output string
+}
+
+func (e *encodeError) Error() string {
+	return "cwebp: " + e.err.Error() + ": " + e.output
+}
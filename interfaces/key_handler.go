@@ -0,0 +1,23 @@
+package interfaces
+
+import (
+	"DDD/infrastructure/auth"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+//Keys publishes the public signing keys so other services can verify our tokens
+type Keys struct {
+	ks *auth.KeySet
+}
+
+//Keys constructor
+func NewKeys(ks *auth.KeySet) *Keys {
+	return &Keys{ks: ks}
+}
+
+func (k *Keys) JWKS(c *gin.Context) {
+	//verifiers may cache the set, but not for so long that they miss a rotation
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, k.ks.JWKS())
+}
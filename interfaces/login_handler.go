@@ -4,32 +4,32 @@ import (
 	"DDD/application"
 	"DDD/domain/entity"
 	"DDD/infrastructure/auth"
-	"fmt"
-	"github.com/dgrijalva/jwt-go"
+	"DDD/interfaces/middleware"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"os"
-	"strconv"
 )
 
 type Authenticate struct {
-	us application.UserAppInterface
-	rd auth.AuthInterface
-	tk auth.TokenInterface
+	us       application.UserAppInterface
+	orgs     application.OrganizationAppInterface
+	rd       auth.AuthInterface
+	tk       auth.TokenInterface
+	sessions *middleware.SessionCookies
 }
 
-//Authenticate constructor
-func NewAuthenticate(uApp application.UserAppInterface, rd auth.AuthInterface, tk auth.TokenInterface) *Authenticate {
+//Authenticate constructor, sessions may be nil when browser cookie sessions are disabled
+func NewAuthenticate(uApp application.UserAppInterface, orgs application.OrganizationAppInterface, rd auth.AuthInterface, tk auth.TokenInterface, sessions *middleware.SessionCookies) *Authenticate {
 	return &Authenticate{
-		us: uApp,
-		rd: rd,
-		tk: tk,
+		us:       uApp,
+		orgs:     orgs,
+		rd:       rd,
+		tk:       tk,
+		sessions: sessions,
 	}
 }
 
 func (au *Authenticate) Login(c *gin.Context) {
 	var user *entity.User
-	var tokenErr = map[string]string{}
 
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
@@ -46,107 +46,131 @@ func (au *Authenticate) Login(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, userErr)
 		return
 	}
-	ts, tErr := au.tk.CreateToken(u.ID)
+	issueTokens(c, au.tk, au.rd, au.sessions, u, defaultOrganization(au.orgs, u.ID))
+}
+
+//defaultOrganization is the organization a new session starts in: the oldest one the user belongs to, or none
+func defaultOrganization(orgs application.OrganizationAppInterface, userId uint64) uint64 {
+	memberships, err := orgs.GetMemberships(userId)
+	if err != nil || len(memberships) == 0 {
+		return 0
+	}
+	return memberships[0].OrganizationID
+}
+
+//issueTokens creates and stores a token pair for u acting in orgId and writes the login response, shared by every way of logging in
+func issueTokens(c *gin.Context, tk auth.TokenInterface, rd auth.AuthInterface, sessions *middleware.SessionCookies, u *entity.User, orgId uint64) {
+	ts, tErr := tk.CreateToken(u.ID, orgId, u.Scopes())
 	if tErr != nil {
-		tokenErr["token_error"] = tErr.Error()
 		c.JSON(http.StatusUnprocessableEntity, tErr.Error())
 		return
 	}
-	saveErr := au.rd.CreateAuth(u.ID, ts)
+	saveErr := rd.CreateAuth(u.ID, ts)
 	if saveErr != nil {
 		c.JSON(http.StatusInternalServerError, saveErr.Error())
 		return
 	}
+	if sessions != nil {
+		if err := sessions.SetTokens(c, ts); err != nil {
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
 	userData := make(map[string]interface{})
-	userData["access_token"] = ts.AccessToken
-	userData["refresh_token"] = ts.RefreshToken
+	//the tokens of browser sessions stay in their HttpOnly cookies
+	if !sessions.CookieSession(c.Request) {
+		userData["access_token"] = ts.AccessToken
+		userData["refresh_token"] = ts.RefreshToken
+	}
 	userData["id"] = u.ID
 	userData["first_name"] = u.FirstName
 	userData["last_name"] = u.LastName
+	userData["organization_id"] = orgId
 
 	c.JSON(http.StatusOK, userData)
 }
 
 func (au *Authenticate) Logout(c *gin.Context) {
-	//check is the user is authenticated first
-	metadata, err := au.tk.ExtractTokenMetadata(c.Request)
-	if err != nil {
+	//the auth middleware has already checked the token is valid and not revoked
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, "Unauthorized")
 		return
 	}
-	//if the access token exist and it is still valid, then delete both the access token and the refresh token
-	deleteErr := au.rd.DeleteTokens(metadata)
+	//delete both the access token and the refresh token
+	deleteErr := au.rd.DeleteTokens(principal.Access)
 	if deleteErr != nil {
 		c.JSON(http.StatusUnauthorized, deleteErr.Error())
 		return
 	}
+	if au.sessions != nil {
+		au.sessions.Clear(c)
+	}
 	c.JSON(http.StatusOK, "Successfully logged out")
 }
 
 //Refresh is the function that uses the refresh_token to generate new pairs of refresh and access tokens.
 func (au *Authenticate) Refresh(c *gin.Context) {
 	mapToken := map[string]string{}
-	if err := c.ShouldBindJSON(&mapToken); err != nil {
+	if err := c.ShouldBindJSON(&mapToken); err != nil && au.sessions == nil {
 		c.JSON(http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 	refreshToken := mapToken["refresh_token"]
-
-	//verify the token
-	token, err := jwt.Parse(refreshToken, func(token *jwt.Token) (interface{}, error) {
-		//Make sure that the token method conform to "SigningMethodHMAC"
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	fromCookie := refreshToken == "" && au.sessions != nil
+	if fromCookie {
+		//browsers send the refresh token as a cookie, which a cross-site form could do as well
+		if !au.sessions.ValidCSRF(c.Request) {
+			c.JSON(http.StatusForbidden, "invalid csrf token")
+			return
 		}
-		return []byte(os.Getenv("REFRESH_SECRET")), nil
-	})
-	//any error may be due to token expiration
+		refreshToken = au.sessions.RefreshToken(c.Request)
+	}
+
+	//verify the token, any error may be due to token expiration
+	refresh, err := au.tk.ExtractRefreshMetadata(refreshToken)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, err.Error())
 		return
 	}
-	//is token valid?
-	if _, ok := token.Claims.(jwt.Claims); !ok && !token.Valid {
-		c.JSON(http.StatusUnauthorized, err)
+	//Delete the previous Refresh Token
+	delErr := au.rd.DeleteRefresh(refresh.RefreshUuid)
+	if delErr != nil { //if any goes wrong
+		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	//Since token is valid, get the uuid:
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		refreshUuid, ok := claims["refresh_uuid"].(string) //convert the interface to string
-		if !ok {
-			c.JSON(http.StatusUnprocessableEntity, "Cannot get uuid")
-			return
-		}
-		userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
-		if err != nil {
-			c.JSON(http.StatusUnprocessableEntity, "Error occurred")
-			return
-		}
-		//Delete the previous Refresh Token
-		delErr := au.rd.DeleteRefresh(refreshUuid)
-		if delErr != nil { //if any goes wrong
-			c.JSON(http.StatusUnauthorized, "unauthorized")
-			return
-		}
-		//Create new pairs of refresh and access tokens
-		ts, createErr := au.tk.CreateToken(userId)
-		if createErr != nil {
-			c.JSON(http.StatusForbidden, createErr.Error())
-			return
-		}
-		//save the tokens metadata to redis
-		saveErr := au.rd.CreateAuth(userId, ts)
-		if saveErr != nil {
-			c.JSON(http.StatusForbidden, saveErr.Error())
+	//the scopes are derived again, so a role change takes effect on the next refresh
+	user, err := au.us.GetUser(refresh.UserId)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	//Create new pairs of refresh and access tokens, in the same organization. Membership is checked on every request, not here.
+	ts, createErr := au.tk.CreateToken(user.ID, refresh.OrgId, user.Scopes())
+	if createErr != nil {
+		c.JSON(http.StatusForbidden, createErr.Error())
+		return
+	}
+	//save the tokens metadata to redis
+	saveErr := au.rd.CreateAuth(refresh.UserId, ts)
+	if saveErr != nil {
+		c.JSON(http.StatusForbidden, saveErr.Error())
+		return
+	}
+	if au.sessions != nil {
+		if err := au.sessions.SetTokens(c, ts); err != nil {
+			c.JSON(http.StatusInternalServerError, err.Error())
 			return
 		}
-		tokens := map[string]string{
-			"access_token":  ts.AccessToken,
-			"refresh_token": ts.RefreshToken,
-		}
-		c.JSON(http.StatusCreated, tokens)
-	} else {
-		c.JSON(http.StatusUnauthorized, "refresh token expired")
 	}
+	//the tokens of browser sessions stay in their HttpOnly cookies
+	if fromCookie || au.sessions.CookieSession(c.Request) {
+		c.JSON(http.StatusCreated, "tokens refreshed")
+		return
+	}
+	tokens := map[string]string{
+		"access_token":  ts.AccessToken,
+		"refresh_token": ts.RefreshToken,
+	}
+	c.JSON(http.StatusCreated, tokens)
 }
@@ -1 +1,82 @@
 package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/interfaces/middleware"
+	"DDD/utils/mock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func loginRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	user := &entity.User{ID: 1, FirstName: "Sammi", LastName: "Dev", Email: "sammidev@gmail.com"}
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			return user, nil
+		},
+		GetUserByEmailAndPasswordFn: func(u *entity.User) (*entity.User, map[string]string) {
+			return user, nil
+		},
+	}
+	orgApp := &mock.OrganizationAppInterface{
+		GetMembershipsFn: func(userId uint64) ([]entity.Membership, error) {
+			return nil, nil
+		},
+	}
+	sessions := &middleware.SessionCookies{Secure: true, SameSite: http.SameSiteLaxMode}
+	au := NewAuthenticate(userApp, orgApp, auth.NewMemoryAuth(), auth.NewToken(keys), sessions)
+
+	r := gin.New()
+	r.POST("/login", au.Login)
+	r.POST("/refresh", au.Refresh)
+	return r
+}
+
+func TestLogin_Bearer(t *testing.T) {
+	r := loginRouter(t)
+
+	//clients that keep the tokens themselves get them as before, the cookies are ignored
+	rr := serve(r, http.MethodPost, "/login", `{"email": "sammidev@gmail.com", "password": "password"}`)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "access_token")
+	assert.Contains(t, rr.Body.String(), "refresh_token")
+}
+
+func TestLogin_CookieSession(t *testing.T) {
+	r := loginRouter(t)
+
+	rr := serve(r, http.MethodPost, "/login?session=cookie", `{"email": "sammidev@gmail.com", "password": "password"}`)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	//the tokens only live in the HttpOnly cookies, no script reads them
+	assert.NotContains(t, rr.Body.String(), "access_token")
+	assert.NotContains(t, rr.Body.String(), "refresh_token")
+	cookies := rr.Result().Cookies()
+	var csrf string
+	for _, cookie := range cookies {
+		if cookie.Name == middleware.CSRFCookie {
+			csrf = cookie.Value
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/refresh", strings.NewReader(""))
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	req.Header.Set(middleware.CSRFHeader, csrf)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.EqualValues(t, http.StatusCreated, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "access_token")
+	assert.NotContains(t, rr.Body.String(), "refresh_token")
+	assert.NotEmpty(t, rr.Result().Cookies())
+}
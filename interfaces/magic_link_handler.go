@@ -0,0 +1,110 @@
+package interfaces
+
+import (
+	"DDD/application"
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/infrastructure/mailer"
+	"DDD/interfaces/middleware"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//magicLinkTTL is how long an emailed login link stays usable
+const magicLinkTTL = time.Minute * 15
+
+//MagicLink logs users in with a single-use link sent to their email address
+type MagicLink struct {
+	us          application.UserAppInterface
+	orgs        application.OrganizationAppInterface
+	rd          auth.AuthInterface
+	tk          auth.TokenInterface
+	sessions    *middleware.SessionCookies
+	mail        mailer.Mailer
+	callbackURL string
+}
+
+//MagicLink constructor. callbackURL is where the emailed links point, the token is appended as a query parameter.
+//It never comes from the request: a spoofed Host would send the login token of someone else to the sender.
+func NewMagicLink(us application.UserAppInterface, orgs application.OrganizationAppInterface, rd auth.AuthInterface, tk auth.TokenInterface, sessions *middleware.SessionCookies, mail mailer.Mailer, callbackURL string) *MagicLink {
+	return &MagicLink{
+		us:          us,
+		orgs:        orgs,
+		rd:          rd,
+		tk:          tk,
+		sessions:    sessions,
+		mail:        mail,
+		callbackURL: callbackURL,
+	}
+}
+
+//Send emails a login link. The response is the same whether or not the email belongs to a user, so it cannot be used to probe accounts.
+func (ml *MagicLink) Send(c *gin.Context) {
+	var user *entity.User
+	if err := c.ShouldBindJSON(&user); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+	validateUser := user.Validate("forgotpassword")
+	if len(validateUser) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, validateUser)
+		return
+	}
+	accepted := "if the email belongs to an account, a login link has been sent"
+	u, err := ml.us.GetUserByEmail(user.Email)
+	if err != nil {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	link, err := ml.tk.CreateLinkToken(auth.LinkLogin, u.ID, magicLinkTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := ml.rd.CreateLink(link); err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	body := fmt.Sprintf("Hi %s,\n\nUse this link to log in. It works once and expires in %d minutes:\n\n%s\n\nIf you did not ask for it, you can ignore this email.\n",
+		u.FirstName, int(magicLinkTTL.Minutes()), ml.callbackURL+"?token="+url.QueryEscape(link.Token))
+	if err := ml.mail.Send(u.Email, "Your login link", body); err != nil {
+		log.Printf("magic link: cannot send mail to user %d: %v", u.ID, err)
+		c.JSON(http.StatusInternalServerError, "cannot send the login link, please try again")
+		return
+	}
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+//requestBaseURL is the scheme and host the request was sent to, for links back to the API.
+//The login links no longer use it, the Host of a request can be spoofed.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "https"
+	if c.Request.TLS == nil && c.GetHeader("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+//Callback exchanges a link for the usual token pair. The link is consumed atomically, so it logs in only once.
+func (ml *MagicLink) Callback(c *gin.Context) {
+	link, err := ml.tk.ExtractLinkMetadata(auth.LinkLogin, c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "invalid or expired link")
+		return
+	}
+	userId, err := ml.rd.ConsumeLink(link.LinkUuid)
+	if err != nil || userId != link.UserId {
+		c.JSON(http.StatusUnauthorized, "invalid or expired link")
+		return
+	}
+	u, err := ml.us.GetUser(userId)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "user not found")
+		return
+	}
+	issueTokens(c, ml.tk, ml.rd, ml.sessions, u, defaultOrganization(ml.orgs, u.ID))
+}
@@ -0,0 +1,99 @@
+package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/utils/mock"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type sentMail struct {
+	to, subject, body string
+}
+
+type captureMailer struct {
+	sent []sentMail
+}
+
+func (m *captureMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, sentMail{to, subject, body})
+	return nil
+}
+
+func magicLinkRouter(t *testing.T) (*gin.Engine, *captureMailer) {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	user := &entity.User{ID: 1, FirstName: "Sammi", LastName: "Dev", Email: "sammidev@gmail.com"}
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			return user, nil
+		},
+		GetUserByEmailFn: func(email string) (*entity.User, error) {
+			if email != user.Email {
+				return nil, errors.New("user not found")
+			}
+			return user, nil
+		},
+	}
+	orgApp := &mock.OrganizationAppInterface{
+		GetMembershipsFn: func(userId uint64) ([]entity.Membership, error) {
+			return []entity.Membership{{OrganizationID: 4, UserID: userId, Role: entity.OrgRoleOwner}}, nil
+		},
+	}
+	mail := &captureMailer{}
+	ml := NewMagicLink(userApp, orgApp, auth.NewMemoryAuth(), auth.NewToken(keys), nil, mail, "https://app.example.com/magic")
+
+	r := gin.New()
+	r.POST("/login/magic", ml.Send)
+	r.GET("/login/magic/callback", ml.Callback)
+	return r, mail
+}
+
+func serve(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMagicLink_Success(t *testing.T) {
+	r, mail := magicLinkRouter(t)
+
+	rr := serve(r, http.MethodPost, "/login/magic", `{"email": "sammidev@gmail.com"}`)
+	assert.EqualValues(t, http.StatusAccepted, rr.Code)
+	if assert.EqualValues(t, 1, len(mail.sent)) {
+		assert.EqualValues(t, "sammidev@gmail.com", mail.sent[0].to)
+	}
+	link := regexp.MustCompile(`https://app\.example\.com/magic\?token=(\S+)`).FindStringSubmatch(mail.sent[0].body)
+	if link == nil {
+		t.Fatalf("no link in %q", mail.sent[0].body)
+	}
+
+	rr = serve(r, http.MethodGet, "/login/magic/callback?token="+link[1], "")
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "access_token")
+	assert.Contains(t, rr.Body.String(), `"organization_id":4`)
+
+	//the link works only once
+	rr = serve(r, http.MethodGet, "/login/magic/callback?token="+link[1], "")
+	assert.EqualValues(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestMagicLink_UnknownEmail(t *testing.T) {
+	r, mail := magicLinkRouter(t)
+
+	rr := serve(r, http.MethodPost, "/login/magic", `{"email": "nobody@gmail.com"}`)
+	assert.EqualValues(t, http.StatusAccepted, rr.Code)
+	assert.EqualValues(t, 0, len(mail.sent))
+}
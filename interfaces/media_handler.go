@@ -0,0 +1,314 @@
+package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/storage"
+	"DDD/interfaces/fileupload"
+	"bytes"
+	"container/list"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"image"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//mediaPrefixes are the objects the media route serves: images, their variants, avatars and confirmed direct uploads.
+//Files still streaming in under entity.IncomingPrefix are not.
+var mediaPrefixes = []string{entity.ImagePrefix, entity.AvatarPrefix, entity.UploadPrefix}
+
+//Media serves the stored images through the API, whatever the storage backend is and whether or not the bucket is public
+type Media struct {
+	store  storage.Storage
+	limits *entity.ImageLimits
+	//widths are the sizes ?w= may ask for, any other width would let clients fill the cache
+	widths []int
+	cache  *mediaCache
+	//signer is nil when the media are public, otherwise every link has to be signed
+	signer *storage.SignedURLs
+	//baseURL is where the route is mounted, URL builds the links from it
+	baseURL string
+	//resizing holds the resizes in progress by cache key, a copy many clients ask for at once is made once
+	mu       sync.Mutex
+	resizing map[string]*resizing
+}
+
+//Media constructor
+func NewMedia(store storage.Storage, limits *entity.ImageLimits, widths []int, cacheBytes int64, signer *storage.SignedURLs, baseURL string) *Media {
+	return &Media{
+		store:   store,
+		limits:  limits,
+		widths:  widths,
+		cache:    newMediaCache(cacheBytes),
+		signer:   signer,
+		baseURL:  baseURL,
+		resizing: map[string]*resizing{},
+	}
+}
+
+//URL is the link to the object under key, signed when the media are not public
+func (m *Media) URL(key string) string {
+	if m.signer == nil {
+		return m.baseURL + key
+	}
+	exp, signature := m.signer.Sign(key)
+	return fmt.Sprintf("%s%s?expires=%d&signature=%s", m.baseURL, key, exp, signature)
+}
+
+//Serve streams the object named by the key parameter. Range and conditional requests are answered by http.ServeContent,
+//and ?w= returns a copy resized to one of the allowed widths.
+//The content type comes from the extension of the key, never from the object: the client chose it for direct uploads.
+//Browsers are told not to sniff it and to run nothing, a file that is also a page is never shown as one.
+func (m *Media) Serve(c *gin.Context) {
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	contentType := mediaType(key)
+	if !servedMedia(key) || contentType == "" {
+		c.JSON(http.StatusNotFound, "not found")
+		return
+	}
+	cacheControl := "public, max-age=31536000, immutable"
+	if m.signer != nil {
+		expires := c.Query("expires")
+		if !m.signer.Verify(key, expires, c.Query("signature")) {
+			c.JSON(http.StatusForbidden, "invalid or expired media url")
+			return
+		}
+		//the link stops working when it expires, the copies kept by browsers should too
+		exp, _ := strconv.ParseInt(expires, 10, 64)
+		cacheControl = fmt.Sprintf("private, max-age=%d", exp-time.Now().Unix())
+	}
+	width := 0
+	if raw := c.Query("w"); raw != "" {
+		w, err := strconv.Atoi(raw)
+		if err != nil || !m.allowedWidth(w) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"invalid_width": fmt.Sprintf("the width must be one of %v", m.widths),
+			})
+			return
+		}
+		width = w
+	}
+	//a stat first, so that deleted images are not served from the cache
+	obj, err := m.store.Stat(key)
+	if err == storage.ErrNotFound || err == storage.ErrInvalidKey {
+		c.JSON(http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header("Cache-Control", cacheControl)
+	if width > 0 {
+		m.serveResized(c, obj, width)
+		return
+	}
+	r, obj, err := m.store.Get(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer r.Close()
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", quoteETag(obj.ETag))
+	if rs, ok := r.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, "", obj.LastModified, rs)
+		return
+	}
+	//without seeking there are no ranges, the whole object is a valid answer to them
+	if c.GetHeader("If-None-Match") == quoteETag(obj.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Header("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+	c.Header("Content-Length", strconv.FormatInt(obj.Size, 10))
+	c.Status(http.StatusOK)
+	if c.Request.Method != http.MethodHead {
+		_, _ = io.Copy(c.Writer, r)
+	}
+}
+
+//serveResized answers from the cache, or resizes the object and keeps the result
+func (m *Media) serveResized(c *gin.Context, obj *storage.Object, width int) {
+	etag := fmt.Sprintf("%s-w%d", obj.ETag, width)
+	cacheKey := obj.Key + "\n" + etag
+	cached, ok := m.cache.get(cacheKey)
+	if !ok {
+		var status int
+		var err error
+		cached, status, err = m.resizeOnce(cacheKey, obj, width)
+		if err != nil {
+			c.JSON(status, err.Error())
+			return
+		}
+	}
+	c.Header("Content-Type", cached.contentType)
+	c.Header("ETag", quoteETag(etag))
+	http.ServeContent(c.Writer, c.Request, "", obj.LastModified, bytes.NewReader(cached.data))
+}
+
+//resizing is a resize in progress, the requests for the same copy wait for its result
+type resizing struct {
+	done   chan struct{}
+	entry  *mediaEntry
+	status int
+	err    error
+}
+
+//resizeOnce resizes the object and keeps the result in the cache. Decoding is what costs, concurrent requests for the
+//same copy share one resize.
+func (m *Media) resizeOnce(cacheKey string, obj *storage.Object, width int) (*mediaEntry, int, error) {
+	m.mu.Lock()
+	if current, ok := m.resizing[cacheKey]; ok {
+		m.mu.Unlock()
+		<-current.done
+		return current.entry, current.status, current.err
+	}
+	//the copy may have been cached since the caller looked
+	if cached, ok := m.cache.get(cacheKey); ok {
+		m.mu.Unlock()
+		return cached, 0, nil
+	}
+	current := &resizing{done: make(chan struct{})}
+	m.resizing[cacheKey] = current
+	m.mu.Unlock()
+
+	current.entry, current.status, current.err = m.resize(obj, width)
+	if current.err == nil {
+		m.cache.add(cacheKey, current.entry)
+	}
+	m.mu.Lock()
+	delete(m.resizing, cacheKey)
+	m.mu.Unlock()
+	close(current.done)
+	return current.entry, current.status, current.err
+}
+
+func (m *Media) resize(obj *storage.Object, width int) (*mediaEntry, int, error) {
+	r, _, err := m.store.Get(obj.Key)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	defer r.Close()
+	//the header is read through a buffer, it is decoded again with the rest
+	var header bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, fileupload.ErrImageInvalid
+	}
+	if int64(config.Width)*int64(config.Height) > m.limits.MaxPixels {
+		return nil, http.StatusUnprocessableEntity, fileupload.ErrImagePixels
+	}
+	src, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, fileupload.ErrImageInvalid
+	}
+	encoder := fileupload.NewPNGEncoder()
+	if format == "jpeg" {
+		encoder = fileupload.NewJPEGEncoder(85)
+	}
+	//images are never enlarged, a narrower one is encoded at its own width
+	if width > src.Bounds().Dx() {
+		width = src.Bounds().Dx()
+	}
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, fileupload.Resize(src, width, encoder.Opaque())); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return &mediaEntry{data: buf.Bytes(), contentType: encoder.ContentType()}, 0, nil
+}
+
+func (m *Media) allowedWidth(width int) bool {
+	for _, w := range m.widths {
+		if w == width {
+			return true
+		}
+	}
+	return false
+}
+
+//mediaType is the content type of the allowed image type the extension of key stands for, empty for any other extension
+func mediaType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	for contentType, extensions := range entity.ImageTypes {
+		for _, e := range extensions {
+			if e == ext {
+				return contentType
+			}
+		}
+	}
+	return ""
+}
+
+func servedMedia(key string) bool {
+	for _, prefix := range mediaPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//quoteETag makes an entity tag of the ETag of a backend, S3 quotes them but the other backends do not
+func quoteETag(etag string) string {
+	return `"` + strings.Trim(etag, `"`) + `"`
+}
+
+type mediaEntry struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+//mediaCache keeps the resized images up to maxBytes, dropping the least recently used ones first.
+//The keys hold the ETags of the images, so a replaced object is never answered from the cache.
+type mediaCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func newMediaCache(maxBytes int64) *mediaCache {
+	return &mediaCache{maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (mc *mediaCache) get(key string) (*mediaEntry, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	el, ok := mc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	mc.order.MoveToFront(el)
+	return el.Value.(*mediaEntry), true
+}
+
+func (mc *mediaCache) add(key string, entry *mediaEntry) {
+	//an entry larger than the cache would only empty it
+	if int64(len(entry.data)) > mc.maxBytes {
+		return
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if _, ok := mc.entries[key]; ok {
+		return
+	}
+	entry.key = key
+	mc.entries[key] = mc.order.PushFront(entry)
+	mc.size += int64(len(entry.data))
+	for mc.size > mc.maxBytes {
+		oldest := mc.order.Back()
+		evicted := mc.order.Remove(oldest).(*mediaEntry)
+		delete(mc.entries, evicted.key)
+		mc.size -= int64(len(evicted.data))
+	}
+}
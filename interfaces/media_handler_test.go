@@ -0,0 +1,183 @@
+package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/storage"
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newMediaRouter(store storage.Storage, signer *storage.SignedURLs) (*gin.Engine, *Media) {
+	gin.SetMode(gin.TestMode)
+	media := NewMedia(store, &entity.DefaultImageLimits, []int{150}, 1<<20, signer, "https://api.example.com/media/")
+	r := gin.New()
+	r.GET("/media/*key", media.Serve)
+	return r, media
+}
+
+func getMedia(r *gin.Engine, target string, header map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMedia_Serve(t *testing.T) {
+	store := storage.NewMemory("")
+	data := encodePNG(image.NewRGBA(image.Rect(0, 0, 300, 200)))
+	_ = store.Put("images/a.png", bytes.NewReader(data), int64(len(data)), "image/png")
+	_ = store.Put("incoming/b.png", bytes.NewReader(data), int64(len(data)), "image/png")
+	r, _ := newMediaRouter(store, nil)
+
+	rr := getMedia(r, "/media/images/a.png", nil)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, data, rr.Body.Bytes())
+	assert.EqualValues(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.EqualValues(t, "public, max-age=31536000, immutable", rr.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, rr.Header().Get("Last-Modified"))
+	etag := rr.Header().Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `"`))
+
+	rr = getMedia(r, "/media/images/a.png", map[string]string{"If-None-Match": etag})
+	assert.EqualValues(t, http.StatusNotModified, rr.Code)
+
+	rr = getMedia(r, "/media/images/a.png", map[string]string{"Range": "bytes=0-3"})
+	assert.EqualValues(t, http.StatusPartialContent, rr.Code)
+	assert.EqualValues(t, data[:4], rr.Body.Bytes())
+
+	//only the objects of products and users are served
+	assert.EqualValues(t, http.StatusNotFound, getMedia(r, "/media/incoming/b.png", nil).Code)
+	assert.EqualValues(t, http.StatusNotFound, getMedia(r, "/media/images/missing.png", nil).Code)
+	assert.EqualValues(t, http.StatusNotFound, getMedia(r, "/media/images/../incoming/b.png", nil).Code)
+}
+
+func TestMedia_ServeType(t *testing.T) {
+	store := storage.NewMemory("")
+	//the client chose the type of a direct upload, and its file may be a page too
+	data := append(encodePNG(image.NewRGBA(image.Rect(0, 0, 64, 64))), []byte("<html><script>alert(1)</script>")...)
+	_ = store.Put("uploads/a.png", bytes.NewReader(data), int64(len(data)), "text/html")
+	_ = store.Put("uploads/b.html", bytes.NewReader(data), int64(len(data)), "text/html")
+	r, _ := newMediaRouter(store, nil)
+
+	rr := getMedia(r, "/media/uploads/a.png", nil)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.EqualValues(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	assert.EqualValues(t, "default-src 'none'; sandbox", rr.Header().Get("Content-Security-Policy"))
+	//only the extensions of the allowed images are served
+	assert.EqualValues(t, http.StatusNotFound, getMedia(r, "/media/uploads/b.html", nil).Code)
+}
+
+func TestMedia_Resize(t *testing.T) {
+	store := storage.NewMemory("")
+	data := encodePNG(image.NewRGBA(image.Rect(0, 0, 300, 200)))
+	_ = store.Put("images/a.png", bytes.NewReader(data), int64(len(data)), "image/png")
+	r, media := newMediaRouter(store, nil)
+
+	rr := getMedia(r, "/media/images/a.png?w=150", nil)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	config, _, err := image.DecodeConfig(bytes.NewReader(rr.Body.Bytes()))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 150, config.Width)
+	assert.EqualValues(t, 100, config.Height)
+	assert.NotEqual(t, getMedia(r, "/media/images/a.png", nil).Header().Get("ETag"), rr.Header().Get("ETag"))
+	assert.EqualValues(t, 1, media.cache.order.Len())
+
+	//the second time comes from the cache
+	again := getMedia(r, "/media/images/a.png?w=150", nil)
+	assert.EqualValues(t, rr.Body.Bytes(), again.Body.Bytes())
+	assert.EqualValues(t, 1, media.cache.order.Len())
+
+	assert.EqualValues(t, http.StatusBadRequest, getMedia(r, "/media/images/a.png?w=151", nil).Code)
+
+	//a deleted image is not served from the cache
+	_ = store.Delete("images/a.png")
+	assert.EqualValues(t, http.StatusNotFound, getMedia(r, "/media/images/a.png?w=150", nil).Code)
+}
+
+//countingStore counts the objects read, a resize reads its image once
+type countingStore struct {
+	storage.Storage
+	gets int32
+}
+
+func (s *countingStore) Get(key string) (io.ReadCloser, *storage.Object, error) {
+	atomic.AddInt32(&s.gets, 1)
+	return s.Storage.Get(key)
+}
+
+func TestMedia_ResizeOnce(t *testing.T) {
+	store := &countingStore{Storage: storage.NewMemory("")}
+	data := encodePNG(image.NewRGBA(image.Rect(0, 0, 1200, 800)))
+	_ = store.Put("images/a.png", bytes.NewReader(data), int64(len(data)), "image/png")
+	r, _ := newMediaRouter(store, nil)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = getMedia(r, "/media/images/a.png?w=150", nil).Code
+		}(i)
+	}
+	wg.Wait()
+	for _, code := range codes {
+		assert.EqualValues(t, http.StatusOK, code)
+	}
+	//the requests that came during the resize waited for it, the later ones found the copy in the cache
+	assert.EqualValues(t, 1, atomic.LoadInt32(&store.gets))
+}
+
+func TestMedia_Signed(t *testing.T) {
+	store := storage.NewMemory("")
+	data := encodePNG(image.NewRGBA(image.Rect(0, 0, 64, 64)))
+	_ = store.Put("avatars/a/64.jpg", bytes.NewReader(data), int64(len(data)), "image/png")
+	r, media := newMediaRouter(store, storage.NewSignedURLs([]byte("secret"), time.Hour))
+
+	assert.EqualValues(t, http.StatusForbidden, getMedia(r, "/media/avatars/a/64.jpg", nil).Code)
+
+	link, err := url.Parse(media.URL("avatars/a/64.jpg"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "api.example.com", link.Host)
+	rr := getMedia(r, link.RequestURI(), nil)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.True(t, strings.HasPrefix(rr.Header().Get("Cache-Control"), "private, max-age="))
+
+	//the signature is for one object
+	other := strings.Replace(link.RequestURI(), "64.jpg", "128.jpg", 1)
+	assert.EqualValues(t, http.StatusForbidden, getMedia(r, other, nil).Code)
+}
+
+func TestMediaCache_Evicts(t *testing.T) {
+	cache := newMediaCache(10)
+	cache.add("a", &mediaEntry{data: make([]byte, 4)})
+	cache.add("b", &mediaEntry{data: make([]byte, 4)})
+	//a was used last, b goes first
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+	cache.add("c", &mediaEntry{data: make([]byte, 4)})
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	_, ok = cache.get("a")
+	assert.True(t, ok)
+	//too large to be kept at all
+	cache.add("d", &mediaEntry{data: make([]byte, 11)})
+	_, ok = cache.get("d")
+	assert.False(t, ok)
+	assert.EqualValues(t, 8, cache.size)
+}
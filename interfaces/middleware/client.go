@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+//clientIDKey is the gin context key holding the id of the authenticated OAuth client
+const clientIDKey = "client_id"
+
+//ClientCredentials authenticates service clients with client_secret_basic, or client_secret_post as a fallback (RFC 6749 section 2.3.1).
+//clients maps client ids to secrets.
+func ClientCredentials(clients map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, secret, ok := c.Request.BasicAuth()
+		if !ok {
+			id, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+		}
+		expected, known := clients[id]
+		if id == "" || !known || subtle.ConstantTimeCompare([]byte(expected), []byte(secret)) != 1 {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "invalid_client",
+			})
+			c.Abort()
+			return
+		}
+		c.Set(clientIDKey, id)
+		c.Next()
+	}
+}
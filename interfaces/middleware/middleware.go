@@ -1,20 +1,93 @@
 package middleware
 
 import (
+	"DDD/application"
+	"DDD/domain/entity"
 	"DDD/infrastructure/auth"
-	"bytes"
+	"fmt"
 	"github.com/gin-gonic/gin"
-	"io/ioutil"
+	"log"
 	"net/http"
+	"strings"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+//Principal is the authenticated caller, resolved once by AuthMiddleware for the handlers that follow
+type Principal struct {
+	User   *entity.User
+	Access *auth.AccessDetails
+	//Actor is the admin impersonating User, nil for normal sessions
+	Actor *entity.User
+	//Membership is the user's role in the organization of the request, set by RequireOrganization
+	Membership *entity.Membership
+}
+
+//ActorID is the id of the person really behind the request
+func (p *Principal) ActorID() uint64 {
+	if p.Actor != nil {
+		return p.Actor.ID
+	}
+	return p.User.ID
+}
+
+//principalKey is the gin context key holding the *Principal
+const principalKey = "principal"
+
+//AuthMiddleware verifies the token, checks it has not been revoked and loads the user it belongs to.
+//Bearer tokens are used when present. Otherwise, if sessions is not nil, the access cookie is used and state-changing requests must pass the CSRF check.
+func AuthMiddleware(tk auth.TokenInterface, rd auth.AuthInterface, us application.UserAppInterface, sessions *SessionCookies) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		err := auth.TokenValid(c.Request)
+		accessToken := auth.ExtractToken(c.Request)
+		if accessToken == "" && sessions != nil {
+			accessToken = sessions.AccessToken(c.Request)
+			if accessToken != "" && !sessions.ValidCSRF(c.Request) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"status": http.StatusForbidden,
+					"error":  "invalid csrf token",
+				})
+				c.Abort()
+				return
+			}
+		}
+		metadata, err := tk.ExtractAccessMetadata(accessToken)
+		if err != nil {
+			unauthorized(c, err.Error())
+			return
+		}
+		//lookup the metadata in the token store, a logged out token is no longer there
+		userId, err := rd.FetchAuth(metadata.TokenUuid)
+		if err != nil || userId != metadata.UserId {
+			unauthorized(c, "token has been revoked")
+			return
+		}
+		user, err := us.GetUser(userId)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status": http.StatusUnauthorized,
-				"error":  err.Error(),
+			unauthorized(c, "user not found")
+			return
+		}
+		principal := &Principal{User: user, Access: metadata}
+		if metadata.Impersonated() {
+			//the token outlives the role it was issued for: the actor's current role must still grant the scope of Admin.Impersonate,
+			//an admin who was demoted or removed cannot go on impersonating
+			actor, err := us.GetUser(metadata.ActorId)
+			if err != nil || !actor.HasScope(entity.ScopeUsersAdmin) {
+				unauthorized(c, "impersonation is no longer allowed")
+				return
+			}
+			principal.Actor = actor
+		}
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+//DenyImpersonation must run after AuthMiddleware. It keeps impersonated sessions away from credentials such as passwords and 2FA.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentPrincipal(c)
+		if ok && principal.Access.Impersonated() {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status": http.StatusForbidden,
+				"error":  "not allowed while impersonating a user",
 			})
 			c.Abort()
 			return
@@ -23,38 +96,105 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-func CORSMiddleware() gin.HandlerFunc {
+//AuditImpersonation records every request made with an impersonation token, with the admin behind it.
+//It is installed globally and inspects the principal once the route's own middleware has run.
+func AuditImpersonation(audit application.AuditAppInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		c.Next()
+		principal, ok := CurrentPrincipal(c)
+		if !ok || !principal.Access.Impersonated() {
 			return
 		}
-		c.Next()
+		err := audit.SaveEvent(&entity.AuditEvent{
+			ActorID: principal.ActorID(),
+			UserID:  principal.User.ID,
+			Action:  c.Request.Method + " " + c.FullPath(),
+			Status:  c.Writer.Status(),
+		})
+		if err != nil {
+			log.Printf("audit: cannot record %s %s by %d as %d: %v", c.Request.Method, c.FullPath(), principal.ActorID(), principal.User.ID, err)
+		}
+	}
+}
+
+//CurrentPrincipal returns the caller stored by AuthMiddleware
+func CurrentPrincipal(c *gin.Context) (*Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return nil, false
 	}
+	principal, ok := value.(*Principal)
+	return principal, ok
 }
 
-//Avoid a large file from loading into memory
-//If the file size is greater than 8MB dont allow it to even load into memory and waste our time.
-func MaxSizeAllowed(n int64) gin.HandlerFunc {
+func unauthorized(c *gin.Context, message string) {
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"status": http.StatusUnauthorized,
+		"error":  message,
+	})
+	c.Abort()
+}
+
+//RequireScopes must run after AuthMiddleware. It rejects tokens that lack any of the scopes with a 403 naming the missing ones.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
-		buff, errRead := c.GetRawData()
-		if errRead != nil {
-			//c.JSON(http.StatusRequestEntityTooLarge,"too large")
-			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
-				"status":     http.StatusRequestEntityTooLarge,
-				"upload_err": "too large: upload an image less than 8MB",
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			unauthorized(c, "unauthorized")
+			return
+		}
+		var missing []string
+		for _, scope := range scopes {
+			if !principal.Access.HasScope(scope) {
+				missing = append(missing, scope)
+			}
+		}
+		if len(missing) > 0 {
+			//RFC 6750 section 3.1
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+			c.JSON(http.StatusForbidden, gin.H{
+				"status":         http.StatusForbidden,
+				"error":          "insufficient scope",
+				"missing_scopes": missing,
 			})
 			c.Abort()
 			return
 		}
-		buf := bytes.NewBuffer(buff)
-		c.Request.Body = ioutil.NopCloser(buf)
+		c.Next()
 	}
 }
 
+//CORSMiddleware lets the pages of origins call the API with their cookies, the origin of the request is echoed back when it is one of them.
+//Browsers refuse credentials along with the wildcard, so "*" lets any other origin in without them, as bearer token clients need.
+func CORSMiddleware(origins ...string) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	anyOrigin := false
+	for _, origin := range origins {
+		if origin == "*" {
+			anyOrigin = true
+		} else {
+			allowed[origin] = true
+		}
+	}
+	return func(c *gin.Context) {
+		//the answer depends on the origin, caches must not hand it to another one
+		c.Writer.Header().Add("Vary", "Origin")
+		if origin := c.GetHeader("Origin"); allowed[origin] {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		} else if anyOrigin {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+		//the tus headers are read by the resumable upload clients
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, HEAD, PUT, PATCH, DELETE")
+
+		//only the preflights are answered here, the other OPTIONS requests go to their route, such as the discovery of tus
+		if c.Request.Method == "OPTIONS" && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,270 @@
+package middleware
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/utils/mock"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type authSetup struct {
+	tk     *auth.Token
+	rd     *auth.MemoryAuth
+	router *gin.Engine
+}
+
+func newAuthSetup(t *testing.T, scopes ...string) *authSetup {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	s := &authSetup{tk: auth.NewToken(keys), rd: auth.NewMemoryAuth()}
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			return &entity.User{ID: id, FirstName: "Sammi", LastName: "Dev"}, nil
+		},
+	}
+	sessions := &SessionCookies{Secure: true, SameSite: http.SameSiteLaxMode}
+	handler := func(c *gin.Context) {
+		principal, _ := CurrentPrincipal(c)
+		c.JSON(http.StatusOK, principal.User.ID)
+	}
+	s.router = gin.New()
+	s.router.GET("/", AuthMiddleware(s.tk, s.rd, userApp, sessions), RequireScopes(scopes...), handler)
+	s.router.POST("/", AuthMiddleware(s.tk, s.rd, userApp, sessions), RequireScopes(scopes...), handler)
+	return s
+}
+
+func (s *authSetup) login(t *testing.T, userId uint64, scopes []string) *auth.TokenDetails {
+	td, err := s.tk.CreateToken(userId, 0, scopes)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	if err := s.rd.CreateAuth(userId, td); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	return td
+}
+
+func (s *authSetup) get(accessToken string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestAuthMiddleware_Success(t *testing.T) {
+	s := newAuthSetup(t, entity.ScopeProductsWrite)
+	td := s.login(t, 1, []string{entity.ScopeProductsWrite})
+
+	rr := s.get(td.AccessToken)
+
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, "1", rr.Body.String())
+}
+
+func TestAuthMiddleware_RevokedToken(t *testing.T) {
+	s := newAuthSetup(t)
+	td := s.login(t, 1, nil)
+	if err := s.rd.DeleteTokens(&auth.AccessDetails{TokenUuid: td.TokenUuid, UserId: 1}); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+
+	rr := s.get(td.AccessToken)
+
+	assert.EqualValues(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestRequireScopes_MissingScope(t *testing.T) {
+	s := newAuthSetup(t, entity.ScopeProductsWrite, entity.ScopeUsersAdmin)
+	td := s.login(t, 1, []string{entity.ScopeProductsWrite})
+
+	rr := s.get(td.AccessToken)
+
+	var body map[string]interface{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &body)
+	assert.EqualValues(t, http.StatusForbidden, rr.Code)
+	assert.EqualValues(t, []interface{}{entity.ScopeUsersAdmin}, body["missing_scopes"])
+	assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "insufficient_scope")
+}
+
+func TestAuthMiddleware_Impersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys, _ := auth.GenerateKeySet()
+	tk, rd := auth.NewToken(keys), auth.NewMemoryAuth()
+	roles := map[uint64]string{1: entity.RoleAdmin, 2: entity.RoleUser}
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			role, ok := roles[id]
+			if !ok {
+				return nil, errors.New("user not found")
+			}
+			return &entity.User{ID: id, Role: role}, nil
+		},
+	}
+	router := gin.New()
+	router.GET("/", AuthMiddleware(tk, rd, userApp, nil), func(c *gin.Context) {
+		principal, _ := CurrentPrincipal(c)
+		c.JSON(http.StatusOK, principal.ActorID())
+	})
+	td, _ := tk.CreateImpersonationToken(1, 2, 0, nil)
+	_ = rd.CreateAuth(2, td)
+	request := func() int {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+td.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	assert.EqualValues(t, http.StatusOK, request())
+	//the admin was demoted, the token still runs but is refused
+	roles[1] = entity.RoleUser
+	assert.EqualValues(t, http.StatusUnauthorized, request())
+	//or removed
+	delete(roles, 1)
+	assert.EqualValues(t, http.StatusUnauthorized, request())
+}
+
+func TestAuthMiddleware_SessionCookies(t *testing.T) {
+	s := newAuthSetup(t)
+	td := s.login(t, 1, nil)
+
+	//collect the cookies a login response would set
+	login := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(login)
+	if err := (&SessionCookies{}).SetTokens(c, td); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	cookies := login.Result().Cookies()
+	var csrf string
+	for _, cookie := range cookies {
+		if cookie.Name == CSRFCookie {
+			csrf = cookie.Value
+			assert.False(t, cookie.HttpOnly)
+		} else {
+			assert.True(t, cookie.HttpOnly)
+		}
+	}
+
+	request := func(method, csrfHeader string) int {
+		r, _ := http.NewRequest(method, "/", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		if csrfHeader != "" {
+			r.Header.Set(CSRFHeader, csrfHeader)
+		}
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	assert.EqualValues(t, http.StatusOK, request(http.MethodGet, ""))
+	assert.EqualValues(t, http.StatusForbidden, request(http.MethodPost, ""))
+	assert.EqualValues(t, http.StatusForbidden, request(http.MethodPost, "forged"))
+	assert.EqualValues(t, http.StatusOK, request(http.MethodPost, csrf))
+}
+
+func TestRequireOrganization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys, _ := auth.GenerateKeySet()
+	tk, rd := auth.NewToken(keys), auth.NewMemoryAuth()
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			return &entity.User{ID: id}, nil
+		},
+	}
+	//user 1 edits organization 7 and only views organization 8
+	orgApp := &mock.OrganizationAppInterface{
+		GetMembershipFn: func(orgId, userId uint64) (*entity.Membership, error) {
+			switch {
+			case userId == 1 && orgId == 7:
+				return &entity.Membership{OrganizationID: 7, UserID: 1, Role: entity.OrgRoleEditor}, nil
+			case userId == 1 && orgId == 8:
+				return &entity.Membership{OrganizationID: 8, UserID: 1, Role: entity.OrgRoleViewer}, nil
+			}
+			return nil, errors.New("membership not found")
+		},
+	}
+	handler := func(c *gin.Context) {
+		principal, _ := CurrentPrincipal(c)
+		c.JSON(http.StatusOK, principal.Membership.OrganizationID)
+	}
+	router := gin.New()
+	authenticated := AuthMiddleware(tk, rd, userApp, nil)
+	router.GET("/products", authenticated, RequireOrganization(orgApp, entity.OrgRoleOwner, entity.OrgRoleEditor), handler)
+	router.GET("/organizations/:org_id", authenticated, RequireOrganization(orgApp), handler)
+
+	request := func(orgId uint64, path string) *httptest.ResponseRecorder {
+		td, _ := tk.CreateToken(1, orgId, nil)
+		_ = rd.CreateAuth(1, td)
+		r, _ := http.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer "+td.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := request(7, "/products")
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, "7", rr.Body.String())
+
+	//viewers cannot reach editor routes
+	assert.EqualValues(t, http.StatusForbidden, request(8, "/products").Code)
+	//a token for an organization the user does not belong to
+	assert.EqualValues(t, http.StatusForbidden, request(9, "/products").Code)
+	//no active organization
+	assert.EqualValues(t, http.StatusForbidden, request(0, "/products").Code)
+
+	//the route parameter wins over the token's organization
+	rr = request(7, "/organizations/8")
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, "8", rr.Body.String())
+	assert.EqualValues(t, http.StatusForbidden, request(7, "/organizations/9").Code)
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORSMiddleware("https://app.example.com", "*"))
+	router.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, "ok")
+	})
+	request := func(origin string) http.Header {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		assert.EqualValues(t, "Origin", rr.Header().Get("Vary"))
+		return rr.Header()
+	}
+
+	//the frontend gets its own origin back, with its cookies
+	header := request("https://app.example.com")
+	assert.EqualValues(t, "https://app.example.com", header.Get("Access-Control-Allow-Origin"))
+	assert.EqualValues(t, "true", header.Get("Access-Control-Allow-Credentials"))
+	//any other origin comes without them, browsers refuse the wildcard with credentials
+	header = request("https://evil.example.com")
+	assert.EqualValues(t, "*", header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, header.Get("Access-Control-Allow-Credentials"))
+
+	//without the wildcard the other origins are not let in at all
+	router = gin.New()
+	router.Use(CORSMiddleware("https://app.example.com"))
+	router.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, "ok")
+	})
+	header = request("https://evil.example.com")
+	assert.Empty(t, header.Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"DDD/application"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+//RequireOrganization must run after AuthMiddleware. It resolves the organization of the request, the org_id route parameter when
+//the route has one and the token's active organization otherwise, and lets the request through only for members holding one of roles.
+//No roles means any member. Membership is checked on every request, so removing a member takes effect before their token expires.
+func RequireOrganization(orgs application.OrganizationAppInterface, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentPrincipal(c)
+		if !ok {
+			unauthorized(c, "unauthorized")
+			return
+		}
+		orgId := principal.Access.OrgId
+		if param := c.Param("org_id"); param != "" {
+			var err error
+			orgId, err = strconv.ParseUint(param, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, "invalid request")
+				c.Abort()
+				return
+			}
+		}
+		if orgId == 0 {
+			forbidden(c, "no active organization")
+			return
+		}
+		membership, err := orgs.GetMembership(orgId, principal.User.ID)
+		if err != nil {
+			forbidden(c, "not a member of this organization")
+			return
+		}
+		if len(roles) > 0 && !hasRole(membership.Role, roles) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status":         http.StatusForbidden,
+				"error":          "insufficient organization role",
+				"required_roles": roles,
+			})
+			c.Abort()
+			return
+		}
+		principal.Membership = membership
+		c.Next()
+	}
+}
+
+func hasRole(role string, roles []string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func forbidden(c *gin.Context, message string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"status": http.StatusForbidden,
+		"error":  message,
+	})
+	c.Abort()
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"DDD/infrastructure/auth"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const (
+	AccessCookie  = "access_token"
+	RefreshCookie = "refresh_token"
+	CSRFCookie    = "csrf_token"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+//SessionCookies configures browser sessions, where the tokens live in HttpOnly cookies instead of script-readable storage.
+//A nil *SessionCookies means the API only accepts bearer tokens.
+type SessionCookies struct {
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+//SetTokens stores the token pair in HttpOnly cookies and issues a fresh CSRF token the frontend can read and echo back
+func (sc *SessionCookies) SetTokens(c *gin.Context, td *auth.TokenDetails) error {
+	csrf := make([]byte, 32)
+	if _, err := rand.Read(csrf); err != nil {
+		return err
+	}
+	sc.set(c, AccessCookie, td.AccessToken, "/", time.Unix(td.AtExpires, 0), true)
+	//the refresh cookie is only ever needed by the refresh endpoint
+	sc.set(c, RefreshCookie, td.RefreshToken, "/refresh", time.Unix(td.RtExpires, 0), true)
+	sc.set(c, CSRFCookie, base64.RawURLEncoding.EncodeToString(csrf), "/", time.Unix(td.RtExpires, 0), false)
+	return nil
+}
+
+//Clear removes the session cookies, for example on logout
+func (sc *SessionCookies) Clear(c *gin.Context) {
+	sc.set(c, AccessCookie, "", "/", time.Unix(0, 0), true)
+	sc.set(c, RefreshCookie, "", "/refresh", time.Unix(0, 0), true)
+	sc.set(c, CSRFCookie, "", "/", time.Unix(0, 0), false)
+}
+
+func (sc *SessionCookies) set(c *gin.Context, name, value, path string, expires time.Time, httpOnly bool) {
+	maxAge := int(time.Until(expires).Seconds())
+	if maxAge <= 0 {
+		maxAge = -1
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   sc.Domain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   sc.Secure,
+		HttpOnly: httpOnly,
+		SameSite: sc.SameSite,
+	})
+}
+
+func (sc *SessionCookies) AccessToken(r *http.Request) string {
+	return cookieValue(r, AccessCookie)
+}
+
+func (sc *SessionCookies) RefreshToken(r *http.Request) string {
+	return cookieValue(r, RefreshCookie)
+}
+
+//ValidCSRF implements the double-submit check: the header must repeat the CSRF cookie, which a cross-site page cannot read.
+//Safe methods never change state and are always allowed.
+func (sc *SessionCookies) ValidCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie := cookieValue(r, CSRFCookie)
+	header := r.Header.Get(CSRFHeader)
+	if cookie == "" || header == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+//CookieSession tells whether r belongs to a browser session: the browser asks for one when it logs in with ?session=cookie,
+//afterwards its requests come with the session cookies and without a bearer token. The tokens of a browser session are
+//only ever set as cookies, never written in a response a script could read. Always false when sc is nil.
+func (sc *SessionCookies) CookieSession(r *http.Request) bool {
+	if sc == nil {
+		return false
+	}
+	if r.URL.Query().Get("session") == "cookie" {
+		return true
+	}
+	return r.Header.Get("Authorization") == "" && (sc.AccessToken(r) != "" || sc.RefreshToken(r) != "")
+}
+
+func cookieValue(r *http.Request, name string) string {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"DDD/interfaces/fileupload"
+	"github.com/gin-gonic/gin"
+)
+
+//uploadPoliciesKey is the gin context key holding the fileupload.Form of the route
+const uploadPoliciesKey = "upload_policies"
+
+//UploadPolicies declares what the files of a route may be, field by field. The handlers that store files read it with CurrentUploadPolicies,
+//so the same handler takes different files on different routes.
+func UploadPolicies(form fileupload.Form) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(uploadPoliciesKey, form)
+		c.Next()
+	}
+}
+
+//CurrentUploadPolicies returns the form declared by UploadPolicies, nil when the route declared none
+func CurrentUploadPolicies(c *gin.Context) fileupload.Form {
+	value, exists := c.Get(uploadPoliciesKey)
+	if !exists {
+		return nil
+	}
+	form, _ := value.(fileupload.Form)
+	return form
+}
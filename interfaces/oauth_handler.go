@@ -0,0 +1,106 @@
+package interfaces
+
+import (
+	"DDD/infrastructure/auth"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//OAuth lets other services check and revoke the tokens we issue
+type OAuth struct {
+	rd auth.AuthInterface
+	tk auth.TokenInterface
+}
+
+//OAuth constructor
+func NewOAuth(rd auth.AuthInterface, tk auth.TokenInterface) *OAuth {
+	return &OAuth{
+		rd: rd,
+		tk: tk,
+	}
+}
+
+//Introspect implements RFC 7662. A token is active when its signature and expiry are valid and it has not been revoked from the token store.
+func (o *OAuth) Introspect(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	//the hint only decides which kind is tried first
+	if c.PostForm("token_type_hint") != "refresh_token" {
+		if response, ok := o.introspectAccess(token); ok {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+	}
+	if response, ok := o.introspectRefresh(token); ok {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if response, ok := o.introspectAccess(token); ok {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"active": false})
+}
+
+func (o *OAuth) introspectAccess(token string) (gin.H, bool) {
+	access, err := o.tk.ExtractAccessMetadata(token)
+	if err != nil {
+		return nil, false
+	}
+	userId, err := o.rd.FetchAuth(access.TokenUuid)
+	if err != nil || userId != access.UserId {
+		return nil, false
+	}
+	response := gin.H{
+		"active":     true,
+		"token_type": "Bearer",
+		"sub":        strconv.FormatUint(access.UserId, 10),
+		"scope":      strings.Join(access.Scopes, " "),
+		"exp":        access.Expires,
+	}
+	if access.OrgId != 0 {
+		response["org_id"] = strconv.FormatUint(access.OrgId, 10)
+	}
+	if access.Impersonated() {
+		response["act"] = gin.H{"sub": strconv.FormatUint(access.ActorId, 10)}
+	}
+	return response, true
+}
+
+func (o *OAuth) introspectRefresh(token string) (gin.H, bool) {
+	refresh, err := o.tk.ExtractRefreshMetadata(token)
+	if err != nil {
+		return nil, false
+	}
+	userId, err := o.rd.FetchAuth(refresh.RefreshUuid)
+	if err != nil || userId != refresh.UserId {
+		return nil, false
+	}
+	return gin.H{
+		"active":     true,
+		"token_type": "refresh_token",
+		"sub":        strconv.FormatUint(refresh.UserId, 10),
+		"exp":        refresh.Expires,
+	}, true
+}
+
+//Revoke implements RFC 7009. Revoking either token of a pair revokes both.
+//Unknown, expired or already revoked tokens still get a 200, as the spec requires.
+func (o *OAuth) Revoke(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	if access, err := o.tk.ExtractAccessMetadata(token); err == nil {
+		_ = o.rd.DeleteTokens(access)
+	} else if refresh, err := o.tk.ExtractRefreshMetadata(token); err == nil {
+		_ = o.rd.DeleteTokens(&auth.AccessDetails{TokenUuid: refresh.AccessUuid(), UserId: refresh.UserId})
+	}
+	c.Status(http.StatusOK)
+}
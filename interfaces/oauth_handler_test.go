@@ -0,0 +1,91 @@
+package interfaces
+
+import (
+	"DDD/infrastructure/auth"
+	"DDD/interfaces/middleware"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func oauthRouter(t *testing.T) (*gin.Engine, *auth.Token, *auth.MemoryAuth) {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	tk := auth.NewToken(keys)
+	rd := auth.NewMemoryAuth()
+	o := NewOAuth(rd, tk)
+	clients := middleware.ClientCredentials(map[string]string{"billing": "secret"})
+
+	r := gin.New()
+	r.POST("/oauth/introspect", clients, o.Introspect)
+	r.POST("/oauth/revoke", clients, o.Revoke)
+	return r, tk, rd
+}
+
+func oauthRequest(r *gin.Engine, path, secret string, form url.Values) (int, map[string]interface{}) {
+	req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("billing", secret)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	body := map[string]interface{}{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &body)
+	return rr.Code, body
+}
+
+func TestIntrospect_Success(t *testing.T) {
+	r, tk, rd := oauthRouter(t)
+	td, _ := tk.CreateToken(1, 0, []string{"products:write"})
+	if err := rd.CreateAuth(1, td); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+
+	code, body := oauthRequest(r, "/oauth/introspect", "secret", url.Values{"token": {td.AccessToken}})
+	assert.EqualValues(t, http.StatusOK, code)
+	assert.EqualValues(t, true, body["active"])
+	assert.EqualValues(t, "1", body["sub"])
+	assert.EqualValues(t, "products:write", body["scope"])
+	assert.EqualValues(t, td.AtExpires, body["exp"])
+
+	code, body = oauthRequest(r, "/oauth/introspect", "secret", url.Values{"token": {td.RefreshToken}, "token_type_hint": {"refresh_token"}})
+	assert.EqualValues(t, http.StatusOK, code)
+	assert.EqualValues(t, true, body["active"])
+	assert.EqualValues(t, "refresh_token", body["token_type"])
+}
+
+func TestIntrospect_InvalidClient(t *testing.T) {
+	r, _, _ := oauthRouter(t)
+
+	code, body := oauthRequest(r, "/oauth/introspect", "wrong", url.Values{"token": {"anything"}})
+	assert.EqualValues(t, http.StatusUnauthorized, code)
+	assert.EqualValues(t, "invalid_client", body["error"])
+}
+
+func TestRevoke_RefreshTokenRevokesPair(t *testing.T) {
+	r, tk, rd := oauthRouter(t)
+	td, _ := tk.CreateToken(1, 0, nil)
+	if err := rd.CreateAuth(1, td); err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+
+	code, _ := oauthRequest(r, "/oauth/revoke", "secret", url.Values{"token": {td.RefreshToken}})
+	assert.EqualValues(t, http.StatusOK, code)
+
+	_, body := oauthRequest(r, "/oauth/introspect", "secret", url.Values{"token": {td.AccessToken}})
+	assert.EqualValues(t, false, body["active"])
+	_, body = oauthRequest(r, "/oauth/introspect", "secret", url.Values{"token": {td.RefreshToken}})
+	assert.EqualValues(t, false, body["active"])
+
+	//revoking again is not an error
+	code, _ = oauthRequest(r, "/oauth/revoke", "secret", url.Values{"token": {td.RefreshToken}})
+	assert.EqualValues(t, http.StatusOK, code)
+}
@@ -0,0 +1,277 @@
+package interfaces
+
+import (
+	"DDD/application"
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/infrastructure/mailer"
+	"DDD/interfaces/middleware"
+	"fmt"
+	"github.com/badoux/checkmail"
+	"github.com/gin-gonic/gin"
+	"github.com/twinj/uuid"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//invitationTTL is how long an invitation to join an organization can be accepted
+const invitationTTL = time.Hour * 24 * 7
+
+//Organizations manages organizations, their members and invitations
+type Organizations struct {
+	orgs          application.OrganizationAppInterface
+	us            application.UserAppInterface
+	rd            auth.AuthInterface
+	tk            auth.TokenInterface
+	sessions      *middleware.SessionCookies
+	mail          mailer.Mailer
+	invitationURL string
+}
+
+//Organizations constructor. invitationURL is the frontend page that accepts invitations, the token is appended as a query parameter.
+func NewOrganizations(orgs application.OrganizationAppInterface, us application.UserAppInterface, rd auth.AuthInterface, tk auth.TokenInterface, sessions *middleware.SessionCookies, mail mailer.Mailer, invitationURL string) *Organizations {
+	return &Organizations{
+		orgs:          orgs,
+		us:            us,
+		rd:            rd,
+		tk:            tk,
+		sessions:      sessions,
+		mail:          mail,
+		invitationURL: invitationURL,
+	}
+}
+
+//SaveOrganization creates an organization with the caller as its owner
+func (o *Organizations) SaveOrganization(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var org entity.Organization
+	if err := c.ShouldBindJSON(&org); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_json": "invalid json",
+		})
+		return
+	}
+	org.Prepare()
+	validateErr := org.Validate()
+	if len(validateErr) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, validateErr)
+		return
+	}
+	newOrg, err := o.orgs.SaveOrganization(&org, principal.User.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusCreated, newOrg)
+}
+
+//GetOrganizations lists the organizations the caller belongs to
+func (o *Organizations) GetOrganizations(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	orgs, err := o.orgs.GetOrganizationsByUser(principal.User.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"active_organization_id": principal.Access.OrgId,
+		"organizations":          orgs,
+	})
+}
+
+//SwitchOrganization replaces the caller's token pair with one acting in the organization of the route, which RequireOrganization has checked
+func (o *Organizations) SwitchOrganization(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := o.rd.DeleteTokens(principal.Access); err != nil {
+		c.JSON(http.StatusUnauthorized, err.Error())
+		return
+	}
+	issueTokens(c, o.tk, o.rd, o.sessions, principal.User, principal.Membership.OrganizationID)
+}
+
+type member struct {
+	UserID    uint64 `json:"user_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Role      string `json:"role"`
+}
+
+func (o *Organizations) GetMembers(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	memberships, err := o.orgs.GetMembers(principal.Membership.OrganizationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	members := make([]member, 0, len(memberships))
+	for _, m := range memberships {
+		user, err := o.us.GetUser(m.UserID)
+		if err != nil {
+			continue
+		}
+		members = append(members, member{UserID: m.UserID, FirstName: user.FirstName, LastName: user.LastName, Role: m.Role})
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+//UpdateMember changes the role of a member, the route is restricted to owners
+func (o *Organizations) UpdateMember(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userId, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, "invalid request")
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_json": "invalid json",
+		})
+		return
+	}
+	if updateErr := o.orgs.UpdateMemberRole(principal.Membership.OrganizationID, userId, body.Role); updateErr != nil {
+		c.JSON(memberErrorStatus(updateErr), updateErr)
+		return
+	}
+	c.JSON(http.StatusOK, "member updated")
+}
+
+//RemoveMember lets owners remove anyone and every member leave
+func (o *Organizations) RemoveMember(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userId, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, "invalid request")
+		return
+	}
+	if userId != principal.User.ID && principal.Membership.Role != entity.OrgRoleOwner {
+		c.JSON(http.StatusForbidden, "only owners can remove other members")
+		return
+	}
+	if removeErr := o.orgs.RemoveMember(principal.Membership.OrganizationID, userId); removeErr != nil {
+		c.JSON(memberErrorStatus(removeErr), removeErr)
+		return
+	}
+	c.JSON(http.StatusOK, "member removed")
+}
+
+func memberErrorStatus(errs map[string]string) int {
+	if _, ok := errs["no_member"]; ok {
+		return http.StatusNotFound
+	}
+	if _, ok := errs["db_error"]; ok {
+		return http.StatusInternalServerError
+	}
+	return http.StatusUnprocessableEntity
+}
+
+//Invite emails an invitation to join the organization, the route is restricted to owners
+func (o *Organizations) Invite(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var invitation entity.Invitation
+	if err := c.ShouldBindJSON(&invitation); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_json": "invalid json",
+		})
+		return
+	}
+	if err := checkmail.ValidateFormat(invitation.Email); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_email": "please provide a valid email",
+		})
+		return
+	}
+	org, err := o.orgs.GetOrganization(principal.Membership.OrganizationID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, err.Error())
+		return
+	}
+	invitation.OrganizationID = org.ID
+	invitation.InvitedBy = principal.User.ID
+	invitation.Token = uuid.NewV4().String()
+	invitation.ExpiresAt = time.Now().Add(invitationTTL)
+	invitation.AcceptedAt = nil
+	invitation.CreatedAt = time.Now()
+	saved, saveErr := o.orgs.SaveInvitation(&invitation)
+	if saveErr != nil {
+		c.JSON(http.StatusUnprocessableEntity, saveErr)
+		return
+	}
+	body := fmt.Sprintf("Hi,\n\n%s %s invited you to join %s as %s. Log in or sign up with this email address, then open this link within %d days:\n\n%s\n",
+		principal.User.FirstName, principal.User.LastName, org.Name, saved.Role, int(invitationTTL.Hours()/24), o.invitationURL+"?token="+url.QueryEscape(saved.Token))
+	if err := o.mail.Send(saved.Email, "You are invited to "+org.Name, body); err != nil {
+		log.Printf("invitation: cannot send mail for invitation %d: %v", saved.ID, err)
+		c.JSON(http.StatusInternalServerError, "cannot send the invitation, please try again")
+		return
+	}
+	c.JSON(http.StatusCreated, saved)
+}
+
+//AcceptInvitation adds the caller to the organization. The invitation only works for the email address it was sent to.
+func (o *Organizations) AcceptInvitation(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_json": "invalid json",
+		})
+		return
+	}
+	invitation, err := o.orgs.GetInvitationByToken(body.Token)
+	if err != nil || !strings.EqualFold(invitation.Email, principal.User.Email) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"invalid_invitation": "invitation is invalid, expired or already used",
+		})
+		return
+	}
+	membership, acceptErr := o.orgs.AcceptInvitation(body.Token, principal.User.ID)
+	if acceptErr != nil {
+		if _, ok := acceptErr["db_error"]; ok {
+			c.JSON(http.StatusInternalServerError, acceptErr)
+			return
+		}
+		c.JSON(http.StatusNotFound, acceptErr)
+		return
+	}
+	c.JSON(http.StatusOK, membership)
+}
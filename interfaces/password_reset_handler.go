@@ -0,0 +1,121 @@
+package interfaces
+
+import (
+	"DDD/application"
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/infrastructure/mailer"
+	"DDD/infrastructure/security"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//passwordResetTTL is how long an emailed reset link stays usable
+const passwordResetTTL = time.Minute * 30
+
+//PasswordReset lets users who forgot their password choose a new one through a single-use link sent to their email address
+type PasswordReset struct {
+	us       application.UserAppInterface
+	rd       auth.AuthInterface
+	tk       auth.TokenInterface
+	mail     mailer.Mailer
+	policy   *security.PasswordPolicy
+	resetURL string
+}
+
+//PasswordReset constructor. resetURL is the frontend page that asks for the new password, the token is appended as a query parameter.
+func NewPasswordReset(us application.UserAppInterface, rd auth.AuthInterface, tk auth.TokenInterface, mail mailer.Mailer, policy *security.PasswordPolicy, resetURL string) *PasswordReset {
+	return &PasswordReset{
+		us:       us,
+		rd:       rd,
+		tk:       tk,
+		mail:     mail,
+		policy:   policy,
+		resetURL: resetURL,
+	}
+}
+
+//Forgot emails a reset link. Like MagicLink.Send, the response does not tell whether the email belongs to a user.
+func (pr *PasswordReset) Forgot(c *gin.Context) {
+	var user *entity.User
+	if err := c.ShouldBindJSON(&user); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+	validateUser := user.Validate("forgotpassword")
+	if len(validateUser) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, validateUser)
+		return
+	}
+	accepted := "if the email belongs to an account, a password reset link has been sent"
+	u, err := pr.us.GetUserByEmail(user.Email)
+	if err != nil {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	link, err := pr.tk.CreateLinkToken(auth.LinkPasswordReset, u.ID, passwordResetTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := pr.rd.CreateLink(link); err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	body := fmt.Sprintf("Hi %s,\n\nUse this link to choose a new password. It works once and expires in %d minutes:\n\n%s\n\nIf you did not ask for it, you can ignore this email and your password stays the same.\n",
+		u.FirstName, int(passwordResetTTL.Minutes()), pr.resetURL+"?token="+url.QueryEscape(link.Token))
+	if err := pr.mail.Send(u.Email, "Reset your password", body); err != nil {
+		log.Printf("password reset: cannot send mail to user %d: %v", u.ID, err)
+		c.JSON(http.StatusInternalServerError, "cannot send the reset link, please try again")
+		return
+	}
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+type passwordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+//Reset sets the new password. The policy is checked before the link is consumed, so a rejected password can be retried with the same link.
+//Every session of the user ends with the old password, someone who knew it may be logged in.
+func (pr *PasswordReset) Reset(c *gin.Context) {
+	var req passwordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, "Invalid json provided")
+		return
+	}
+	link, err := pr.tk.ExtractLinkMetadata(auth.LinkPasswordReset, req.Token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "invalid or expired link")
+		return
+	}
+	u, err := pr.us.GetUser(link.UserId)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "invalid or expired link")
+		return
+	}
+	if policyErr := pr.policy.Check(req.Password, u); len(policyErr) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, policyErr)
+		return
+	}
+	userId, err := pr.rd.ConsumeLink(link.LinkUuid)
+	if err != nil || userId != link.UserId {
+		c.JSON(http.StatusUnauthorized, "invalid or expired link")
+		return
+	}
+	if updateErr := pr.us.UpdatePassword(u.ID, req.Password); updateErr != nil {
+		c.JSON(http.StatusInternalServerError, updateErr)
+		return
+	}
+	if err := pr.rd.DeleteUserTokens(u.ID); err != nil {
+		log.Printf("password reset: cannot end the sessions of user %d: %v", u.ID, err)
+		c.JSON(http.StatusInternalServerError, "the password was changed but the other sessions could not be ended, please try again")
+		return
+	}
+	c.JSON(http.StatusOK, "password changed")
+}
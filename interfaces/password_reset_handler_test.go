@@ -0,0 +1,105 @@
+package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/infrastructure/security"
+	"DDD/utils/mock"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func passwordResetRouter(t *testing.T) (*gin.Engine, *captureMailer, *string, *auth.MemoryAuth) {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	user := &entity.User{ID: 1, FirstName: "Sammi", LastName: "Dev", Email: "sammidev@gmail.com"}
+	var updated string
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			return user, nil
+		},
+		GetUserByEmailFn: func(email string) (*entity.User, error) {
+			if email != user.Email {
+				return nil, errors.New("user not found")
+			}
+			return user, nil
+		},
+		UpdatePasswordFn: func(id uint64, password string) map[string]string {
+			updated = password
+			return nil
+		},
+	}
+	mail := &captureMailer{}
+	policy := security.DefaultPasswordPolicy
+	rd := auth.NewMemoryAuth()
+	pr := NewPasswordReset(userApp, rd, auth.NewToken(keys), mail, &policy, "https://app.example.com/reset")
+
+	r := gin.New()
+	r.POST("/password/forgot", pr.Forgot)
+	r.POST("/password/reset", pr.Reset)
+	return r, mail, &updated, rd
+}
+
+func TestPasswordReset_Success(t *testing.T) {
+	r, mail, updated, rd := passwordResetRouter(t)
+	//a session opened with the old password
+	keys, _ := auth.GenerateKeySet()
+	td, _ := auth.NewToken(keys).CreateToken(1, 0, nil)
+	_ = rd.CreateAuth(1, td)
+
+	rr := serve(r, http.MethodPost, "/password/forgot", `{"email": "sammidev@gmail.com"}`)
+	assert.EqualValues(t, http.StatusAccepted, rr.Code)
+	if !assert.EqualValues(t, 1, len(mail.sent)) {
+		return
+	}
+	link := regexp.MustCompile(`https://app\.example\.com/reset\?token=(\S+)`).FindStringSubmatch(mail.sent[0].body)
+	if link == nil {
+		t.Fatalf("no link in %q", mail.sent[0].body)
+	}
+
+	//a password the policy rejects does not use up the link
+	rr = serve(r, http.MethodPost, "/password/reset", `{"token": "`+link[1]+`", "password": "sammidev"}`)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "password_too_short")
+	assert.Contains(t, rr.Body.String(), "password_contains_name")
+	assert.EqualValues(t, "", *updated)
+	tokens, _ := rd.ListTokens(1)
+	assert.NotEmpty(t, tokens)
+
+	rr = serve(r, http.MethodPost, "/password/reset", `{"token": "`+link[1]+`", "password": "Correct4Horse"}`)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, "Correct4Horse", *updated)
+	//the sessions opened with the old password are over
+	tokens, _ = rd.ListTokens(1)
+	assert.Empty(t, tokens)
+
+	//the link works once
+	rr = serve(r, http.MethodPost, "/password/reset", `{"token": "`+link[1]+`", "password": "Another4Horse"}`)
+	assert.EqualValues(t, http.StatusUnauthorized, rr.Code)
+	assert.EqualValues(t, "Correct4Horse", *updated)
+}
+
+func TestPasswordReset_UnknownEmail(t *testing.T) {
+	r, mail, _, _ := passwordResetRouter(t)
+
+	rr := serve(r, http.MethodPost, "/password/forgot", `{"email": "nobody@gmail.com"}`)
+	assert.EqualValues(t, http.StatusAccepted, rr.Code)
+	assert.EqualValues(t, 0, len(mail.sent))
+}
+
+func TestPasswordReset_LoginLinkRejected(t *testing.T) {
+	r, _, updated, _ := passwordResetRouter(t)
+	keys, _ := auth.GenerateKeySet()
+	login, _ := auth.NewToken(keys).CreateLinkToken(auth.LinkLogin, 1, magicLinkTTL)
+
+	rr := serve(r, http.MethodPost, "/password/reset", `{"token": "`+login.Token+`", "password": "Correct4Horse"}`)
+	assert.EqualValues(t, http.StatusUnauthorized, rr.Code)
+	assert.EqualValues(t, "", *updated)
+}
@@ -0,0 +1,362 @@
+package interfaces
+
+import (
+	"DDD/application"
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/infrastructure/jobs"
+	"DDD/interfaces/fileupload"
+	"DDD/interfaces/middleware"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+//What an erasure does with the products the user created
+const (
+	//ErasureReassignProducts hands the products over to another member of their organization, they are deleted when nobody is left
+	ErasureReassignProducts = "reassign"
+	ErasureDeleteProducts   = "delete"
+)
+
+//Privacy lets users take their data with them and have it erased
+type Privacy struct {
+	us         application.UserAppInterface
+	products   application.ProductAppInterface
+	orgs       application.OrganizationAppInterface
+	audit      application.AuditAppInterface
+	rd         auth.AuthInterface
+	fileUpload fileupload.UploadFileInterface
+	queue      jobs.Queue
+	//productPolicy is ErasureReassignProducts or ErasureDeleteProducts
+	productPolicy string
+}
+
+//Privacy constructor
+func NewPrivacy(us application.UserAppInterface, products application.ProductAppInterface, orgs application.OrganizationAppInterface, audit application.AuditAppInterface, rd auth.AuthInterface, fd fileupload.UploadFileInterface, queue jobs.Queue, productPolicy string) *Privacy {
+	if productPolicy != ErasureDeleteProducts {
+		productPolicy = ErasureReassignProducts
+	}
+	return &Privacy{
+		us:            us,
+		products:      products,
+		orgs:          orgs,
+		audit:         audit,
+		rd:            rd,
+		fileUpload:    fd,
+		queue:         queue,
+		productPolicy: productPolicy,
+	}
+}
+
+//exportFile is one JSON document of the archive
+type exportFile struct {
+	name string
+	data interface{}
+}
+
+//Export sends everything kept about the user as a zip of JSON documents
+func (p *Privacy) Export(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	user := principal.User
+	//everything is gathered before the archive is written, so a failure can still be reported as JSON
+	products, err := p.products.GetProductsByUser(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	tokens, err := p.rd.ListTokens(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	events, err := p.audit.GetEventsByUser(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	memberships, err := p.orgs.GetMemberships(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	organizations, err := p.orgs.GetOrganizationsByUser(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	archive, err := writeExport([]exportFile{
+		{"profile.json", exportProfile(user)},
+		{"products.json", products},
+		{"images.json", exportImages(user, products)},
+		{"sessions.json", exportSessions(tokens)},
+		{"audit_events.json", events},
+		{"organizations.json", exportOrganizations(organizations, memberships)},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = p.audit.SaveEvent(&entity.AuditEvent{
+		ActorID: principal.ActorID(),
+		UserID:  user.ID,
+		Action:  "data exported",
+		Status:  http.StatusOK,
+	})
+	if err != nil {
+		log.Printf("export: cannot record the export of user %d: %v", user.ID, err)
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%d.zip"`, user.ID))
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+func writeExport(files []exportFile) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//exportProfile lists the fields of the user one by one, the password hash is not personal data worth handing out
+func exportProfile(user *entity.User) gin.H {
+	return gin.H{
+		"id":         user.ID,
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"email":      user.Email,
+		"role":       user.Role,
+		"avatar":     user.Avatar,
+		"created_at": user.CreatedAt,
+		"updated_at": user.UpdatedAt,
+	}
+}
+
+func exportImages(user *entity.User, products []entity.Product) []gin.H {
+	images := []gin.H{}
+	if user.Avatar != "" {
+		for _, size := range entity.AvatarSizes {
+			images = append(images, gin.H{"kind": "avatar", "size": size, "url": entity.AvatarURL(entity.AvatarKey(user.Avatar, size))})
+		}
+	}
+	for _, product := range products {
+		if product.ProductImage != "" {
+			images = append(images, gin.H{"kind": "product", "product_id": product.ID, "url": entity.ImageURL(product.ProductImage)})
+		}
+	}
+	return images
+}
+
+func exportSessions(tokens []auth.StoredToken) []gin.H {
+	sessions := []gin.H{}
+	for _, token := range tokens {
+		kind := "access"
+		if token.Refresh() {
+			kind = "refresh"
+		}
+		sessions = append(sessions, gin.H{"id": token.Uuid, "type": kind, "expires_at": token.ExpiresAt})
+	}
+	return sessions
+}
+
+func exportOrganizations(organizations []entity.Organization, memberships []entity.Membership) []gin.H {
+	names := map[uint64]string{}
+	for _, org := range organizations {
+		names[org.ID] = org.Name
+	}
+	result := []gin.H{}
+	for _, m := range memberships {
+		result = append(result, gin.H{"organization_id": m.OrganizationID, "name": names[m.OrganizationID], "role": m.Role, "joined_at": m.CreatedAt})
+	}
+	return result
+}
+
+//RequestErasure starts the erasure of the user's own account. The work is done by a job, the response tells how to follow it.
+func (p *Privacy) RequestErasure(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	p.enqueueErasure(c, principal, principal.User.ID)
+}
+
+//EraseUser starts the erasure of any account, for admins handling requests made through support
+func (p *Privacy) EraseUser(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userId, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, "invalid request")
+		return
+	}
+	if _, err := p.us.GetUser(userId); err != nil {
+		c.JSON(http.StatusNotFound, "user not found")
+		return
+	}
+	p.enqueueErasure(c, principal, userId)
+}
+
+func (p *Privacy) enqueueErasure(c *gin.Context, principal *middleware.Principal, userId uint64) {
+	job, jobErr := p.queue.Enqueue(&entity.Job{
+		Kind:        entity.JobUserErasure,
+		SubjectID:   userId,
+		RequestedBy: principal.ActorID(),
+	})
+	if jobErr != nil {
+		c.JSON(http.StatusInternalServerError, jobErr)
+		return
+	}
+	err := p.audit.SaveEvent(&entity.AuditEvent{
+		ActorID: principal.ActorID(),
+		UserID:  userId,
+		Action:  "erasure requested",
+		Status:  http.StatusAccepted,
+	})
+	if err != nil {
+		log.Printf("erasure: cannot record the request for user %d: %v", userId, err)
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+//GetJob shows the progress of a job to whoever requested it, the user it is about and admins
+func (p *Privacy) GetJob(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	jobId, err := strconv.ParseUint(c.Param("job_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, "invalid request")
+		return
+	}
+	job, err := p.queue.Get(jobId)
+	//other people's jobs are not found, so their ids cannot be probed
+	if err != nil || (job.RequestedBy != principal.User.ID && job.SubjectID != principal.User.ID && !principal.Access.HasScope(entity.ScopeUsersAdmin)) {
+		c.JSON(http.StatusNotFound, "job not found")
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+//Erase is the handler of entity.JobUserErasure jobs. Every step can be repeated, so a failed job is simply run again.
+func (p *Privacy) Erase(job *entity.Job) error {
+	userId := job.SubjectID
+	user, err := p.us.GetUser(userId)
+	if err != nil {
+		return fmt.Errorf("cannot load user %d: %v", userId, err)
+	}
+	if err := p.rd.DeleteUserTokens(userId); err != nil {
+		return fmt.Errorf("cannot revoke the tokens: %v", err)
+	}
+	products, err := p.products.GetProductsByUser(userId)
+	if err != nil {
+		return fmt.Errorf("cannot list the products: %v", err)
+	}
+	var orgIds []uint64
+	byOrg := map[uint64][]entity.Product{}
+	for _, product := range products {
+		if _, ok := byOrg[product.OrganizationID]; !ok {
+			orgIds = append(orgIds, product.OrganizationID)
+		}
+		byOrg[product.OrganizationID] = append(byOrg[product.OrganizationID], product)
+	}
+	for _, orgId := range orgIds {
+		if err := p.eraseProducts(orgId, userId, byOrg[orgId]); err != nil {
+			return err
+		}
+	}
+	if errs := p.orgs.RemoveUserMemberships(userId); errs != nil {
+		return fmt.Errorf("cannot remove the memberships: %v", errs)
+	}
+	if errs := p.us.AnonymizeUser(userId); errs != nil {
+		return fmt.Errorf("cannot anonymize the user: %v", errs)
+	}
+	//the avatar is released once the row no longer holds it, a retried job would release it twice otherwise.
+	//What a failed release leaves behind is collected with the orphans.
+	if user.Avatar != "" {
+		if err := p.fileUpload.DeleteAvatar(user.Avatar); err != nil {
+			log.Printf("erasure: cannot delete the avatar %s: %v", user.Avatar, err)
+		}
+	}
+	//a login between the first revocation and the anonymisation could have issued new tokens
+	if err := p.rd.DeleteUserTokens(userId); err != nil {
+		return fmt.Errorf("cannot revoke the tokens: %v", err)
+	}
+	return p.audit.SaveEvent(&entity.AuditEvent{
+		ActorID: job.RequestedBy,
+		UserID:  userId,
+		Action:  "user erased",
+		Status:  http.StatusOK,
+	})
+}
+
+//eraseProducts applies the product policy to the products the user created in one organization
+func (p *Privacy) eraseProducts(orgId, userId uint64, products []entity.Product) error {
+	if p.productPolicy == ErasureReassignProducts {
+		successor, err := p.successor(orgId, userId)
+		if err != nil {
+			return fmt.Errorf("cannot list the members of organization %d: %v", orgId, err)
+		}
+		if successor != 0 {
+			if err := p.products.ReassignProducts(orgId, userId, successor); err != nil {
+				return fmt.Errorf("cannot reassign the products of organization %d: %v", orgId, err)
+			}
+			return nil
+		}
+	}
+	for _, product := range products {
+		//the row goes first, like in Product.DeleteProduct: a retried job does not find the product again and cannot release its
+		//image twice, which other products may share. What a failed release leaves behind is collected with the orphans.
+		if err := p.products.DeleteProduct(orgId, product.ID); err != nil {
+			return fmt.Errorf("cannot delete product %d: %v", product.ID, err)
+		}
+		if product.ProductImage != "" {
+			if err := p.fileUpload.DeleteFile(product.ProductImage); err != nil {
+				log.Printf("erasure: cannot delete the image of product %d: %v", product.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+//successor picks who takes over the products: the oldest owner, else the oldest editor, else the oldest member. Zero means nobody is left.
+func (p *Privacy) successor(orgId, userId uint64) (uint64, error) {
+	members, err := p.orgs.GetMembers(orgId)
+	if err != nil {
+		return 0, err
+	}
+	for _, role := range []string{entity.OrgRoleOwner, entity.OrgRoleEditor, entity.OrgRoleViewer} {
+		for _, m := range members {
+			if m.UserID != userId && m.Role == role {
+				return m.UserID, nil
+			}
+		}
+	}
+	return 0, nil
+}
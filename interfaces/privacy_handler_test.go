@@ -0,0 +1,299 @@
+package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/interfaces/middleware"
+	"DDD/utils/mock"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//fakeQueue keeps enqueued jobs without running them
+type fakeQueue struct {
+	jobs []*entity.Job
+}
+
+func (q *fakeQueue) Enqueue(job *entity.Job) (*entity.Job, map[string]string) {
+	job.ID = uint64(len(q.jobs) + 1)
+	job.Status = entity.JobPending
+	q.jobs = append(q.jobs, job)
+	return job, nil
+}
+
+func (q *fakeQueue) Get(id uint64) (*entity.Job, error) {
+	if id == 0 || id > uint64(len(q.jobs)) {
+		return nil, errors.New("job not found")
+	}
+	return q.jobs[id-1], nil
+}
+
+type privacySetup struct {
+	router      *gin.Engine
+	privacy     *Privacy
+	tk          *auth.Token
+	rd          *auth.MemoryAuth
+	queue       *fakeQueue
+	user        *entity.User
+	products    []entity.Product
+	members     map[uint64][]entity.Membership
+	reassigned  [][3]uint64
+	deleted     []uint64
+	files       []string
+	avatars     []string
+	anonymized  []uint64
+	memberships []uint64
+	events      []entity.AuditEvent
+	//deleteFails and anonymizeFails are the number of the next calls failing like the database was down
+	deleteFails    int
+	anonymizeFails int
+}
+
+func newPrivacySetup(t *testing.T, policy string) *privacySetup {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	s := &privacySetup{
+		tk:    auth.NewToken(keys),
+		rd:    auth.NewMemoryAuth(),
+		queue: &fakeQueue{},
+		user:  &entity.User{ID: 1, FirstName: "Sammi", LastName: "Dev", Email: "sammidev@gmail.com", Password: "secret-hash", Avatar: "avatars/me"},
+		products: []entity.Product{
+			{ID: 10, OrganizationID: 4, UserID: 1, Title: "shared", ProductImage: "shared.png"},
+			{ID: 11, OrganizationID: 5, UserID: 1, Title: "solo", ProductImage: "solo.png"},
+		},
+		members: map[uint64][]entity.Membership{
+			4: {{OrganizationID: 4, UserID: 1, Role: entity.OrgRoleOwner}, {OrganizationID: 4, UserID: 3, Role: entity.OrgRoleViewer}, {OrganizationID: 4, UserID: 2, Role: entity.OrgRoleEditor}},
+			5: {{OrganizationID: 5, UserID: 1, Role: entity.OrgRoleOwner}},
+		},
+	}
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			if id != s.user.ID {
+				return nil, errors.New("user not found")
+			}
+			u := *s.user
+			return &u, nil
+		},
+		AnonymizeUserFn: func(id uint64) map[string]string {
+			if s.anonymizeFails > 0 {
+				s.anonymizeFails--
+				return map[string]string{"db_error": "database error"}
+			}
+			s.anonymized = append(s.anonymized, id)
+			s.user.Avatar = ""
+			return nil
+		},
+	}
+	productApp := &mock.ProductAppInterface{
+		GetProductsByUserFn: func(userId uint64) ([]entity.Product, error) {
+			var products []entity.Product
+			for _, product := range s.products {
+				deleted := false
+				for _, id := range s.deleted {
+					deleted = deleted || id == product.ID
+				}
+				if !deleted {
+					products = append(products, product)
+				}
+			}
+			return products, nil
+		},
+		ReassignProductsFn: func(orgId, from, to uint64) error {
+			s.reassigned = append(s.reassigned, [3]uint64{orgId, from, to})
+			return nil
+		},
+		DeleteProductFn: func(orgId, productId uint64) error {
+			if s.deleteFails > 0 {
+				s.deleteFails--
+				return errors.New("database error")
+			}
+			s.deleted = append(s.deleted, productId)
+			return nil
+		},
+	}
+	orgApp := &mock.OrganizationAppInterface{
+		GetMembersFn: func(orgId uint64) ([]entity.Membership, error) {
+			return s.members[orgId], nil
+		},
+		GetMembershipsFn: func(userId uint64) ([]entity.Membership, error) {
+			return []entity.Membership{s.members[4][0], s.members[5][0]}, nil
+		},
+		GetOrganizationsByUserFn: func(userId uint64) ([]entity.Organization, error) {
+			return []entity.Organization{{ID: 4, Name: "Acme"}, {ID: 5, Name: "Solo"}}, nil
+		},
+		RemoveUserMembershipsFn: func(userId uint64) map[string]string {
+			s.memberships = append(s.memberships, userId)
+			return nil
+		},
+	}
+	auditApp := &mock.AuditAppInterface{
+		SaveEventFn: func(event *entity.AuditEvent) error {
+			s.events = append(s.events, *event)
+			return nil
+		},
+		GetEventsByUserFn: func(userId uint64) ([]entity.AuditEvent, error) {
+			return []entity.AuditEvent{{ID: 1, ActorID: 1, UserID: 1, Action: "password changed"}}, nil
+		},
+	}
+	upload := &mock.UploadFileInterface{
+		DeleteFileFn: func(key string) error {
+			s.files = append(s.files, key)
+			return nil
+		},
+		DeleteAvatarFn: func(key string) error {
+			s.avatars = append(s.avatars, key)
+			return nil
+		},
+	}
+	s.privacy = NewPrivacy(userApp, productApp, orgApp, auditApp, s.rd, upload, s.queue, policy)
+	authenticated := middleware.AuthMiddleware(s.tk, s.rd, userApp, nil)
+	s.router = gin.New()
+	s.router.GET("/users/me/export", authenticated, s.privacy.Export)
+	s.router.POST("/users/me/erasure", authenticated, s.privacy.RequestErasure)
+	s.router.GET("/jobs/:job_id", authenticated, s.privacy.GetJob)
+	return s
+}
+
+func (s *privacySetup) request(t *testing.T, method, path string) *httptest.ResponseRecorder {
+	td, err := s.tk.CreateToken(s.user.ID, 4, nil)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	_ = s.rd.CreateAuth(s.user.ID, td)
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+td.AccessToken)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestExport_Success(t *testing.T) {
+	s := newPrivacySetup(t, ErasureReassignProducts)
+
+	rr := s.request(t, http.MethodGet, "/users/me/export")
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, "application/zip", rr.Header().Get("Content-Type"))
+
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		r, _ := f.Open()
+		files[f.Name], _ = ioutil.ReadAll(r)
+		r.Close()
+	}
+	assert.EqualValues(t, 6, len(files))
+
+	var profile map[string]interface{}
+	assert.Nil(t, json.Unmarshal(files["profile.json"], &profile))
+	assert.EqualValues(t, "sammidev@gmail.com", profile["email"])
+	_, hasPassword := profile["password"]
+	assert.False(t, hasPassword)
+
+	var products []entity.Product
+	assert.Nil(t, json.Unmarshal(files["products.json"], &products))
+	assert.EqualValues(t, 2, len(products))
+
+	var images []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(files["images.json"], &images))
+	assert.EqualValues(t, len(entity.AvatarSizes)+2, len(images))
+
+	//the token used for this request is one of the sessions
+	var sessions []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(files["sessions.json"], &sessions))
+	assert.EqualValues(t, 2, len(sessions))
+
+	var events []entity.AuditEvent
+	assert.Nil(t, json.Unmarshal(files["audit_events.json"], &events))
+	assert.EqualValues(t, "password changed", events[0].Action)
+
+	var organizations []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(files["organizations.json"], &organizations))
+	assert.EqualValues(t, "Acme", organizations[0]["name"])
+
+	assert.EqualValues(t, "data exported", s.events[0].Action)
+}
+
+func TestRequestErasure_Success(t *testing.T) {
+	s := newPrivacySetup(t, ErasureReassignProducts)
+
+	rr := s.request(t, http.MethodPost, "/users/me/erasure")
+	assert.EqualValues(t, http.StatusAccepted, rr.Code)
+	var job entity.Job
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	assert.EqualValues(t, entity.JobUserErasure, job.Kind)
+	assert.EqualValues(t, 1, job.SubjectID)
+	//nothing is erased until the job runs
+	assert.Empty(t, s.anonymized)
+
+	rr = s.request(t, http.MethodGet, "/jobs/1")
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+
+	//jobs of other users are not found
+	s.queue.jobs[0].SubjectID, s.queue.jobs[0].RequestedBy = 2, 2
+	rr = s.request(t, http.MethodGet, "/jobs/1")
+	assert.EqualValues(t, http.StatusNotFound, rr.Code)
+}
+
+func TestErase_ReassignProducts(t *testing.T) {
+	s := newPrivacySetup(t, ErasureReassignProducts)
+	td, _ := s.tk.CreateToken(s.user.ID, 4, nil)
+	_ = s.rd.CreateAuth(s.user.ID, td)
+
+	assert.Nil(t, s.privacy.Erase(&entity.Job{Kind: entity.JobUserErasure, SubjectID: 1, RequestedBy: 9}))
+
+	//the editor takes over in Acme, nobody is left in Solo
+	assert.EqualValues(t, [][3]uint64{{4, 1, 2}}, s.reassigned)
+	assert.EqualValues(t, []uint64{11}, s.deleted)
+	assert.EqualValues(t, []string{"solo.png"}, s.files)
+	assert.EqualValues(t, []string{"avatars/me"}, s.avatars)
+	assert.EqualValues(t, []uint64{1}, s.memberships)
+	assert.EqualValues(t, []uint64{1}, s.anonymized)
+	tokens, err := s.rd.ListTokens(1)
+	assert.Nil(t, err)
+	assert.Empty(t, tokens)
+	assert.EqualValues(t, "user erased", s.events[0].Action)
+	assert.EqualValues(t, 9, s.events[0].ActorID)
+}
+
+func TestErase_DeleteProducts(t *testing.T) {
+	s := newPrivacySetup(t, ErasureDeleteProducts)
+
+	assert.Nil(t, s.privacy.Erase(&entity.Job{Kind: entity.JobUserErasure, SubjectID: 1, RequestedBy: 1}))
+
+	assert.Empty(t, s.reassigned)
+	assert.EqualValues(t, []uint64{10, 11}, s.deleted)
+	assert.EqualValues(t, []string{"shared.png", "solo.png"}, s.files)
+}
+
+func TestErase_Retried(t *testing.T) {
+	s := newPrivacySetup(t, ErasureDeleteProducts)
+	job := &entity.Job{Kind: entity.JobUserErasure, SubjectID: 1, RequestedBy: 1}
+
+	//the runner repeats a failed job, whatever it did already is not done twice
+	s.deleteFails = 1
+	assert.NotNil(t, s.privacy.Erase(job))
+	assert.Empty(t, s.files)
+	s.anonymizeFails = 1
+	assert.NotNil(t, s.privacy.Erase(job))
+	assert.Empty(t, s.avatars)
+	assert.Nil(t, s.privacy.Erase(job))
+
+	assert.EqualValues(t, []uint64{10, 11}, s.deleted)
+	//each image and the avatar were released once, another product may share them
+	assert.EqualValues(t, []string{"shared.png", "solo.png"}, s.files)
+	assert.EqualValues(t, []string{"avatars/me"}, s.avatars)
+}
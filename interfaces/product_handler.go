@@ -3,12 +3,13 @@ package interfaces
 import (
 	"DDD/application"
 	"DDD/domain/entity"
-	"DDD/infrastructure/auth"
+	"DDD/infrastructure/jobs"
 	"DDD/interfaces/fileupload"
+	"DDD/interfaces/middleware"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 )
@@ -16,41 +17,42 @@ import (
 type Product struct {
 	productApp application.ProductAppInterface
 	userApp    application.UserAppInterface
+	uploadApp  application.UploadAppInterface
 	fileUpload fileupload.UploadFileInterface
-	tk         auth.TokenInterface
-	rd         auth.AuthInterface
+	queue      jobs.Queue
 }
 
 //Product constructor
-func NewProduct(fApp application.ProductAppInterface, uApp application.UserAppInterface, fd fileupload.UploadFileInterface, rd auth.AuthInterface, tk auth.TokenInterface) *Product {
+func NewProduct(fApp application.ProductAppInterface, uApp application.UserAppInterface, upApp application.UploadAppInterface, fd fileupload.UploadFileInterface, queue jobs.Queue) *Product {
 	return &Product{
-		productApp:    fApp,
+		productApp: fApp,
 		userApp:    uApp,
+		uploadApp:  upApp,
 		fileUpload: fd,
-		rd:         rd,
-		tk:         tk,
+		queue:      queue,
 	}
 }
 
+//the product routes run behind RequireOrganization, every handler works inside principal.Membership's organization
 func (fo *Product) SaveProduct(c *gin.Context) {
-	//check is the user is authenticated first
-	metadata, err := fo.tk.ExtractTokenMetadata(c.Request)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, "unauthorized")
-		return
-	}
-	//lookup the metadata in redis:
-	userId, err := fo.rd.FetchAuth(metadata.TokenUuid)
-	if err != nil {
+	//the auth middleware has already verified the token and loaded the user
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
 		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
 	//We we are using a frontend(vuejs), our errors need to have keys for easy checking, so we use a map to hold our errors
 	var saveProductError = make(map[string]string)
 
+	streamed, status, formErr := fo.readForm(c)
+	if formErr != nil {
+		c.JSON(status, formErr)
+		return
+	}
 	title := c.PostForm("title")
 	description := c.PostForm("description")
 	if fmt.Sprintf("%T", title) != "string" || fmt.Sprintf("%T", description) != "string" {
+		fo.releaseImage(streamed)
 		c.JSON(http.StatusUnprocessableEntity, gin.H{
 			"invalid_json": "Invalid json",
 		})
@@ -62,50 +64,41 @@ func (fo *Product) SaveProduct(c *gin.Context) {
 	emptyProduct.Description = description
 	saveProductError = emptyProduct.Validate("")
 	if len(saveProductError) > 0 {
+		fo.releaseImage(streamed)
 		c.JSON(http.StatusUnprocessableEntity, saveProductError)
 		return
 	}
-	file, err := c.FormFile("product_image")
-	if err != nil {
-		saveProductError["invalid_file"] = "a valid file is required"
-		c.JSON(http.StatusUnprocessableEntity, saveProductError)
-		return
-	}
-	//check if the user exist
-	_, err = fo.userApp.GetUser(userId)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, "user not found, unauthorized")
+	uploadedFile, status, imageErr := fo.productImage(c, principal, streamed)
+	if imageErr != nil {
+		c.JSON(status, imageErr)
 		return
 	}
-	uploadedFile, err := fo.fileUpload.UploadFile(file)
-	if err != nil {
-		saveProductError["upload_err"] = err.Error() //this error can be any we defined in the UploadFile method
-		c.JSON(http.StatusUnprocessableEntity, saveProductError)
+	if uploadedFile == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_file": "a valid file is required",
+		})
 		return
 	}
 	var product = entity.Product{}
-	product.UserID = userId
+	product.OrganizationID = principal.Membership.OrganizationID
+	product.UserID = principal.User.ID
 	product.Title = title
 	product.Description = description
 	product.ProductImage = uploadedFile
 	savedProduct, saveErr := fo.productApp.SaveProduct(&product)
 	if saveErr != nil {
+		//the reference taken by the upload is not handed to any product
+		fo.releaseImage(uploadedFile)
 		c.JSON(http.StatusInternalServerError, saveErr)
 		return
 	}
+	fo.enqueueVariants(principal, savedProduct)
 	c.JSON(http.StatusCreated, savedProduct)
 }
 
 func (fo *Product) UpdateProduct(c *gin.Context) {
-	//Check if the user is authenticated first
-	metadata, err := fo.tk.ExtractTokenMetadata(c.Request)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-	//lookup the metadata in redis:
-	userId, err := fo.rd.FetchAuth(metadata.TokenUuid)
-	if err != nil {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
 		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
@@ -117,6 +110,11 @@ func (fo *Product) UpdateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, "invalid request")
 		return
 	}
+	streamed, status, formErr := fo.readForm(c)
+	if formErr != nil {
+		c.JSON(status, formErr)
+		return
+	}
 	//Since it is a multipart form data we sent, we will do a manual check on each item
 	title := c.PostForm("title")
 	description := c.PostForm("description")
@@ -129,43 +127,26 @@ func (fo *Product) UpdateProduct(c *gin.Context) {
 	emptyProduct.Description = description
 	updateProductError = emptyProduct.Validate("update")
 	if len(updateProductError) > 0 {
+		fo.releaseImage(streamed)
 		c.JSON(http.StatusUnprocessableEntity, updateProductError)
 		return
 	}
-	user, err := fo.userApp.GetUser(userId)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, "user not found, unauthorized")
-		return
-	}
-
-	//check if the product exist:
-	product, err := fo.productApp.GetProduct(productId)
+	//check if the product exist. Products of other organizations are not found, so they cannot be updated using postman, curl, etc
+	product, err := fo.productApp.GetProduct(principal.Membership.OrganizationID, productId)
 	if err != nil {
+		fo.releaseImage(streamed)
 		c.JSON(http.StatusNotFound, err.Error())
 		return
 	}
-	//if the user id doesnt match with the one we have, dont update. This is the case where an authenticated user tries to update someone else post using postman, curl, etc
-	if user.ID != product.UserID {
-		c.JSON(http.StatusUnauthorized, "you are not the owner of this product")
+	uploadedFile, status, imageErr := fo.productImage(c, principal, streamed)
+	if imageErr != nil {
+		c.JSON(status, imageErr)
 		return
 	}
-	file, _ := c.FormFile("product_image")
-	if file != nil {
-		product.ProductImage, err = fo.fileUpload.UploadFile(file)
-		//since i am using Digital Ocean(DO) Spaces to save image, i am appending my DO url here. You can comment this line since you may be using Digital Ocean Spaces.
-		product.ProductImage = os.Getenv("DO_SPACES_URL") + product.ProductImage
-		if err != nil {
-			c.JSON(http.StatusUnprocessableEntity, gin.H{
-				"upload_err": err.Error(),
-			})
-			return
-		}
-	}
-	//we dont need to update user's id
+	//we dont need to update the creator or the organization
 	product.Title = title
 	product.Description = description
-	product.UpdatedAt = time.Now()
-	updatedProduct, dbUpdateErr := fo.productApp.UpdateProduct(product)
+	updatedProduct, dbUpdateErr := fo.updateProduct(principal, product, uploadedFile)
 	if dbUpdateErr != nil {
 		c.JSON(http.StatusInternalServerError, dbUpdateErr)
 		return
@@ -174,7 +155,12 @@ func (fo *Product) UpdateProduct(c *gin.Context) {
 }
 
 func (fo *Product) GetAllProduct(c *gin.Context) {
-	allproduct, err := fo.productApp.GetAllProduct()
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	allproduct, err := fo.productApp.GetAllProduct(principal.Membership.OrganizationID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
@@ -183,14 +169,19 @@ func (fo *Product) GetAllProduct(c *gin.Context) {
 }
 
 func (fo *Product) GetProductAndCreator(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
 	productId, err := strconv.ParseUint(c.Param("product_id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, "invalid request")
 		return
 	}
-	product, err := fo.productApp.GetProduct(productId)
+	product, err := fo.productApp.GetProduct(principal.Membership.OrganizationID, productId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		c.JSON(http.StatusNotFound, err.Error())
 		return
 	}
 	user, err := fo.userApp.GetUser(product.UserID)
@@ -206,9 +197,9 @@ func (fo *Product) GetProductAndCreator(c *gin.Context) {
 }
 
 func (fo *Product) DeleteProduct(c *gin.Context) {
-	metadata, err := fo.tk.ExtractTokenMetadata(c.Request)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, "Unauthorized")
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
 	productId, err := strconv.ParseUint(c.Param("product_id"), 10, 64)
@@ -216,15 +207,187 @@ func (fo *Product) DeleteProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, "invalid request")
 		return
 	}
-	_, err = fo.userApp.GetUser(metadata.UserId)
+	//a product of another organization is not found
+	product, err := fo.productApp.GetProduct(principal.Membership.OrganizationID, productId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		c.JSON(http.StatusNotFound, err.Error())
 		return
 	}
-	err = fo.productApp.DeleteProduct(productId)
+	err = fo.productApp.DeleteProduct(principal.Membership.OrganizationID, productId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
+	if product.ProductImage != "" {
+		fo.releaseImage(product.ProductImage)
+	}
 	c.JSON(http.StatusOK, "product deleted")
 }
+
+
+//AttachImage makes the stored file under key the image of the product, as the resumable uploads do once they are complete.
+//The reference taken on key belongs to the product afterwards, or is given back when the product cannot be updated.
+func (fo *Product) AttachImage(principal *middleware.Principal, productId uint64, key string) (*entity.Product, int, map[string]string) {
+	product, err := fo.productApp.GetProduct(principal.Membership.OrganizationID, productId)
+	if err != nil {
+		fo.releaseImage(key)
+		return nil, http.StatusNotFound, map[string]string{"no_product": err.Error()}
+	}
+	updatedProduct, errs := fo.updateProduct(principal, product, key)
+	if errs != nil {
+		return nil, http.StatusInternalServerError, errs
+	}
+	return updatedProduct, 0, nil
+}
+
+//updateProduct saves the changes made to product, with the file under key as its new image unless key is empty.
+//The previous image is released once the product no longer uses it, and the variants of the new one are asked for.
+func (fo *Product) updateProduct(principal *middleware.Principal, product *entity.Product, key string) (*entity.Product, map[string]string) {
+	replaced := key != ""
+	previousImage := product.ProductImage
+	if replaced {
+		//the variants of the previous image do not apply anymore, new ones are made once the product is saved
+		product.ImageVariants = nil
+		product.ProductImage = key
+	}
+	product.UpdatedAt = time.Now()
+	updatedProduct, dbUpdateErr := fo.productApp.UpdateProduct(product)
+	if dbUpdateErr != nil {
+		if replaced {
+			fo.releaseImage(key)
+		}
+		return nil, dbUpdateErr
+	}
+	if replaced {
+		//the previous image goes unless another product shares it
+		if previousImage != "" {
+			fo.releaseImage(previousImage)
+		}
+		fo.enqueueVariants(principal, updatedProduct)
+	}
+	return updatedProduct, nil
+}
+
+//readForm reads the fields of the form and streams its product_image file to the storage within the policy of the route, nothing is buffered.
+//It returns the key of the stored file, which the caller releases when the product is not saved after all.
+func (fo *Product) readForm(c *gin.Context) (string, int, map[string]string) {
+	keys, err := fileupload.ReadForm(c.Request, middleware.CurrentUploadPolicies(c), fo.fileUpload.UploadFile)
+	if err != nil {
+		for _, key := range keys["product_image"] {
+			fo.releaseImage(key)
+		}
+		status, errs := uploadError(err)
+		return "", status, errs
+	}
+	if len(keys["product_image"]) == 0 {
+		return "", 0, nil
+	}
+	return keys["product_image"][0], 0, nil
+}
+
+//productImage returns the product_image file streamed by readForm, or confirms the direct upload named by upload_id.
+//It returns an empty key when the form has neither.
+func (fo *Product) productImage(c *gin.Context, principal *middleware.Principal, streamed string) (string, int, map[string]string) {
+	if uploadId := c.PostForm("upload_id"); uploadId != "" {
+		//the direct upload wins, as it always has
+		fo.releaseImage(streamed)
+		policy, ok := middleware.CurrentUploadPolicies(c)["product_image"]
+		if !ok {
+			log.Printf("product: %s declares no upload policy for product_image", c.FullPath())
+			return "", http.StatusInternalServerError, map[string]string{"upload_err": "no upload policy"}
+		}
+		return fo.confirmUpload(principal, uploadId, policy)
+	}
+	return streamed, 0, nil
+}
+
+//confirmUpload is the confirm step of direct uploads: the stored file is checked against the intent and against policy
+//before it is attached to a product
+func (fo *Product) confirmUpload(principal *middleware.Principal, rawId string, policy *entity.UploadPolicy) (string, int, map[string]string) {
+	invalid := map[string]string{"invalid_upload": "upload not found or already used"}
+	uploadId, err := strconv.ParseUint(rawId, 10, 64)
+	if err != nil {
+		return "", http.StatusUnprocessableEntity, invalid
+	}
+	upload, err := fo.uploadApp.GetUpload(uploadId)
+	//the uploads of other users, or of another organization, are not found
+	if err != nil || upload.UserID != principal.User.ID || upload.OrganizationID != principal.Membership.OrganizationID || upload.Status != entity.UploadPending {
+		return "", http.StatusUnprocessableEntity, invalid
+	}
+	//an expired upload is not attached, the garbage collector deletes its file
+	if upload.ExpiresAt.Before(time.Now()) {
+		return "", http.StatusUnprocessableEntity, map[string]string{"upload_expired": "the upload expired, create another one"}
+	}
+	key, err := fo.fileUpload.CheckUpload(upload.Key, upload.ContentType, upload.MaxBytes, policy)
+	switch err {
+	case nil:
+	case fileupload.ErrUploadMissing:
+		return "", http.StatusUnprocessableEntity, map[string]string{"upload_missing": err.Error()}
+	case fileupload.ErrUploadTooLarge, fileupload.ErrUploadType, fileupload.ErrImageInvalid, fileupload.ErrImagePixels, fileupload.ErrImageTooSmall, fileupload.ErrImageTooWide:
+		status, errs := uploadError(err)
+		return "", status, errs
+	case fileupload.ErrInfected:
+		//the upload is marked, it can never be attached to a product
+		if errs := fo.uploadApp.RejectUpload(upload.ID); errs != nil {
+			log.Printf("product: cannot reject upload %d: %v", upload.ID, errs)
+		}
+		return "", http.StatusUnprocessableEntity, map[string]string{"upload_infected": err.Error()}
+	case fileupload.ErrScanFailed:
+		return "", http.StatusServiceUnavailable, map[string]string{"scan_failed": err.Error()}
+	default:
+		return "", http.StatusInternalServerError, map[string]string{"upload_err": err.Error()}
+	}
+	if errs := fo.uploadApp.ConfirmUpload(upload.ID); errs != nil {
+		//the clean copy is referenced like a streamed image, the reference is given back
+		fo.releaseImage(key)
+		if _, used := errs["no_upload"]; used {
+			return "", http.StatusUnprocessableEntity, invalid
+		}
+		return "", http.StatusInternalServerError, errs
+	}
+	//the uploaded file is only deleted now, until the upload is confirmed the client may confirm it again
+	if err := fo.fileUpload.DeleteUpload(upload.Key); err != nil {
+		log.Printf("product: cannot delete upload %s: %v", upload.Key, err)
+	}
+	return key, 0, nil
+}
+
+//releaseImage gives back the reference a product held on its image. Failures are only logged, the garbage collector removes what is left.
+func (fo *Product) releaseImage(key string) {
+	if key == "" {
+		return
+	}
+	if err := fo.fileUpload.DeleteFile(key); err != nil {
+		log.Printf("product: cannot release image %s: %v", key, err)
+	}
+}
+
+//enqueueVariants asks for the variants of the product image. The product is usable without them, so a failure is only logged.
+func (fo *Product) enqueueVariants(principal *middleware.Principal, product *entity.Product) {
+	_, jobErr := fo.queue.Enqueue(&entity.Job{
+		Kind:        entity.JobImageVariants,
+		SubjectID:   product.ID,
+		Target:      product.ProductImage,
+		RequestedBy: principal.ActorID(),
+	})
+	if jobErr != nil {
+		log.Printf("variants: cannot enqueue the variants of product %d: %v", product.ID, jobErr)
+	}
+}
+
+//GenerateVariants is the handler of entity.JobImageVariants jobs
+func (fo *Product) GenerateVariants(job *entity.Job) error {
+	variants, err := fo.fileUpload.GenerateVariants(job.Target)
+	if err != nil {
+		return err
+	}
+	if errs := fo.productApp.SetImageVariants(job.SubjectID, job.Target, variants); errs != nil {
+		//the image was replaced, or the product deleted, while the variants were made.
+		//Other products may share the image, its variants are removed with its last reference.
+		if _, gone := errs["no_product"]; gone {
+			return nil
+		}
+		return fmt.Errorf("cannot record the variants: %v", errs)
+	}
+	return nil
+}
\ No newline at end of file
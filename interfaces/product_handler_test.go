@@ -1 +1,104 @@
 package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/storage"
+	"DDD/utils/mock"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type variantsSetup struct {
+	product  *Product
+	recorded []entity.ImageVariants
+	keys     []string
+	current  string
+}
+
+func newVariantsSetup() *variantsSetup {
+	s := &variantsSetup{current: "photo.png"}
+	productApp := &mock.ProductAppInterface{
+		SetImageVariantsFn: func(productId uint64, image string, variants entity.ImageVariants) map[string]string {
+			if image != s.current {
+				return map[string]string{"no_product": "product not found"}
+			}
+			s.recorded = append(s.recorded, variants)
+			return nil
+		},
+	}
+	upload := &mock.UploadFileInterface{
+		GenerateVariantsFn: func(key string) (entity.ImageVariants, error) {
+			s.keys = append(s.keys, key)
+			if key == "broken.png" {
+				return nil, errors.New("the image cannot be decoded")
+			}
+			return entity.ImageVariants{{Width: 150, Format: "jpg", Key: "photo/150.jpg"}}, nil
+		},
+	}
+	s.product = NewProduct(productApp, &mock.UserAppInterface{}, &mock.UploadAppInterface{}, upload, &fakeQueue{})
+	return s
+}
+
+func TestGenerateVariants_Success(t *testing.T) {
+	s := newVariantsSetup()
+
+	assert.Nil(t, s.product.GenerateVariants(&entity.Job{Kind: entity.JobImageVariants, SubjectID: 1, Target: s.current}))
+	//the variants are made from the key the job names
+	assert.EqualValues(t, []string{"photo.png"}, s.keys)
+	assert.EqualValues(t, 1, len(s.recorded))
+}
+
+func TestGenerateVariants_ImageReplaced(t *testing.T) {
+	s := newVariantsSetup()
+	s.current = "newer.png"
+
+	//there is nothing left to do, the job succeeds. The variants stay with the image, other products may share it.
+	assert.Nil(t, s.product.GenerateVariants(&entity.Job{Kind: entity.JobImageVariants, SubjectID: 1, Target: "photo.png"}))
+	assert.Empty(t, s.recorded)
+}
+
+func TestGenerateVariants_Failure(t *testing.T) {
+	s := newVariantsSetup()
+
+	assert.NotNil(t, s.product.GenerateVariants(&entity.Job{Kind: entity.JobImageVariants, SubjectID: 1, Target: "broken.png"}))
+	assert.Empty(t, s.recorded)
+}
+
+func TestUpdateProduct_ReleasesPreviousImage(t *testing.T) {
+	s := newUploadSetup(t)
+	first, _ := s.upload(t, smallPNG())
+	assert.EqualValues(t, http.StatusCreated, s.saveProduct(t, 1, first).Code)
+	firstKey := s.products[0].ProductImage
+
+	//direct uploads are stored under their content key too, the same picture would be the same image
+	second, _ := s.upload(t, encodePNG(image.NewRGBA(image.Rect(0, 0, 80, 64))))
+	rr := s.sendProduct(t, http.MethodPut, "/food/1", 1, second)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	secondKey := s.products[0].ProductImage
+	assert.NotEqual(t, firstKey, secondKey)
+	_, err := s.store.Stat(firstKey)
+	assert.EqualValues(t, storage.ErrNotFound, err)
+	_, err = s.store.Stat(secondKey)
+	assert.Nil(t, err)
+}
+
+func TestDeleteProduct_ReleasesImage(t *testing.T) {
+	s := newUploadSetup(t)
+	uploadId, _ := s.upload(t, smallPNG())
+	assert.EqualValues(t, http.StatusCreated, s.saveProduct(t, 1, uploadId).Code)
+	key := s.products[0].ProductImage
+	_, err := s.store.Stat(key)
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest(http.MethodDelete, "/food/1", nil)
+	s.authorize(t, req, 1)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	_, err = s.store.Stat(key)
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
@@ -0,0 +1,224 @@
+package interfaces
+
+import (
+	"DDD/application"
+	"DDD/domain/entity"
+	"DDD/infrastructure/jobs"
+	"DDD/infrastructure/storage"
+	"DDD/interfaces/middleware"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+//GarbageCollector deletes the stored objects nothing refers to anymore: images of products that were replaced or deleted,
+//files uploaded for a product that was never saved, direct uploads that were never confirmed, and variants of images that are gone.
+type GarbageCollector struct {
+	products application.ProductAppInterface
+	us       application.UserAppInterface
+	uploads  application.UploadAppInterface
+	refs     application.ObjectRefAppInterface
+	store    storage.Storage
+	queue    jobs.Queue
+	//grace spares the recent objects, such as a file uploaded for a product that is being saved
+	grace time.Duration
+	//legacy collects the objects at the root of the bucket too, where product images were stored before they had a prefix
+	legacy bool
+}
+
+//gcPrefixes are where the API stores objects. The bucket may be shared, nothing else in it is looked at.
+var gcPrefixes = []string{entity.ImagePrefix, entity.AvatarPrefix, entity.UploadPrefix, entity.IncomingPrefix, entity.QuarantinePrefix}
+
+//GarbageCollector constructor
+func NewGarbageCollector(products application.ProductAppInterface, us application.UserAppInterface, uploads application.UploadAppInterface, refs application.ObjectRefAppInterface, store storage.Storage, queue jobs.Queue, grace time.Duration, legacy bool) *GarbageCollector {
+	return &GarbageCollector{
+		products: products,
+		us:       us,
+		uploads:  uploads,
+		refs:     refs,
+		store:    store,
+		queue:    queue,
+		grace:    grace,
+		legacy:   legacy,
+	}
+}
+
+//GCReport tells what a collection found, and deleted unless it was a dry run
+type GCReport struct {
+	DryRun  bool             `json:"dry_run"`
+	Scanned int              `json:"scanned"`
+	Orphans []storage.Object `json:"orphans"`
+	Bytes   int64            `json:"bytes"`
+	Deleted int              `json:"deleted"`
+	Failed  []string         `json:"failed,omitempty"`
+}
+
+//references are the keys the database points at. Variants and avatar sizes are stored under a directory named after
+//their image, so an object is also referenced when its directory is.
+type references struct {
+	keys map[string]bool
+	dirs map[string]bool
+}
+
+func (r *references) image(key string) {
+	r.keys[key] = true
+	r.dirs[strings.TrimSuffix(key, path.Ext(key))] = true
+}
+
+func (r *references) has(key string) bool {
+	return r.keys[key] || r.dirs[path.Dir(key)]
+}
+
+//Collect reconciles the storage with the database. The objects are listed first, so an object stored while the
+//references are read is either younger than the grace period or already referenced.
+func (gc *GarbageCollector) Collect(dryRun bool) (*GCReport, error) {
+	cutoff := time.Now().Add(-gc.grace)
+	objects, err := gc.objects()
+	if err != nil {
+		return nil, err
+	}
+	referenced, err := gc.references(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := gc.refs.GetObjectRefs()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the object references: %v", err)
+	}
+	counted := map[string]bool{}
+	for _, ref := range refs {
+		counted[ref.Key] = true
+		//a reference taken within the grace period belongs to an upload whose product may not be saved yet
+		if ref.UpdatedAt.After(cutoff) {
+			referenced.keys[ref.Key] = true
+		}
+	}
+	report := &GCReport{DryRun: dryRun, Scanned: len(objects)}
+	for _, obj := range objects {
+		if obj.LastModified.After(cutoff) || referenced.has(obj.Key) {
+			continue
+		}
+		report.Orphans = append(report.Orphans, obj)
+		report.Bytes += obj.Size
+		if dryRun {
+			continue
+		}
+		if err := gc.store.Delete(obj.Key); err != nil {
+			log.Printf("storage gc: cannot delete %s: %v", obj.Key, err)
+			report.Failed = append(report.Failed, obj.Key)
+			continue
+		}
+		if counted[obj.Key] {
+			if err := gc.refs.DeleteObjectRef(obj.Key); err != nil {
+				log.Printf("storage gc: cannot forget the references of %s: %v", obj.Key, err)
+			}
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+//objects lists the prefixes of the API, and the root of the bucket when legacy objects are collected
+func (gc *GarbageCollector) objects() ([]storage.Object, error) {
+	var objects []storage.Object
+	for _, prefix := range gcPrefixes {
+		listed, err := gc.store.List(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list %s: %v", prefix, err)
+		}
+		objects = append(objects, listed...)
+	}
+	if !gc.legacy {
+		return objects, nil
+	}
+	//the whole bucket is walked, only the keys that are not in a directory are legacy images
+	listed, err := gc.store.List("")
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the storage: %v", err)
+	}
+	for _, obj := range listed {
+		if !strings.Contains(obj.Key, "/") {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func (gc *GarbageCollector) references(cutoff time.Time) (*references, error) {
+	referenced := &references{keys: map[string]bool{}, dirs: map[string]bool{}}
+	products, err := gc.products.GetProductImages()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the product images: %v", err)
+	}
+	for _, product := range products {
+		if product.ProductImage != "" {
+			referenced.image(product.ProductImage)
+		}
+		for _, variant := range product.ImageVariants {
+			referenced.keys[variant.Key] = true
+		}
+	}
+	avatars, err := gc.us.GetAvatars()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the avatars: %v", err)
+	}
+	for _, avatar := range avatars {
+		referenced.dirs[avatar] = true
+	}
+	uploads, err := gc.uploads.GetPendingUploads()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the pending uploads: %v", err)
+	}
+	//an unconfirmed upload is kept until it has been expired for the grace period
+	for _, upload := range uploads {
+		if upload.ExpiresAt.After(cutoff) {
+			referenced.keys[upload.Key] = true
+		}
+	}
+	return referenced, nil
+}
+
+//Run is the handler of entity.JobStorageGC jobs
+func (gc *GarbageCollector) Run(job *entity.Job) error {
+	report, err := gc.Collect(false)
+	if err != nil {
+		return err
+	}
+	log.Printf("storage gc: %d objects scanned, %d orphans deleted, %d bytes", report.Scanned, report.Deleted, report.Bytes)
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("cannot delete %d orphans", len(report.Failed))
+	}
+	return nil
+}
+
+//Report is the dry run: it lists what a collection would delete, without deleting anything
+func (gc *GarbageCollector) Report(c *gin.Context) {
+	report, err := gc.Collect(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+//Start enqueues a collection, it is followed on /jobs/:job_id
+func (gc *GarbageCollector) Start(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	job, jobErr := gc.queue.Enqueue(&entity.Job{
+		Kind:        entity.JobStorageGC,
+		RequestedBy: principal.ActorID(),
+	})
+	if jobErr != nil {
+		c.JSON(http.StatusInternalServerError, jobErr)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
@@ -0,0 +1,133 @@
+package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/storage"
+	"DDD/utils/mock"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type gcSetup struct {
+	store     *storage.Memory
+	forgotten []string
+}
+
+func newGCSetup(t *testing.T, grace time.Duration, legacy bool) (*GarbageCollector, *gcSetup) {
+	s := &gcSetup{store: storage.NewMemory("https://cdn.example.com/")}
+	for _, key := range []string{
+		"images/a.png", "images/a/150.jpg", "images/a/480.jpg",
+		"images/b.png", "images/b/150.jpg",
+		"images/c.png",
+		"avatars/h/64.jpg", "avatars/h/128.jpg",
+		"uploads/x.png", "uploads/y.png",
+		"0b8f0b3c-legacy.png",
+		//the bucket is shared with another app
+		"backups/db.sql",
+	} {
+		if err := s.store.Put(key, strings.NewReader("picture"), 7, "image/png"); err != nil {
+			t.Fatalf("want non error, got %#v", err)
+		}
+	}
+	products := &mock.ProductAppInterface{
+		GetProductImagesFn: func() ([]entity.Product, error) {
+			return []entity.Product{
+				{ID: 1, ProductImage: "images/a.png", ImageVariants: entity.ImageVariants{{Width: 150, Format: "jpg", Key: "images/a/150.jpg"}}},
+				{ID: 2, ProductImage: ""},
+			}, nil
+		},
+	}
+	users := &mock.UserAppInterface{
+		GetAvatarsFn: func() ([]string, error) {
+			return []string{"avatars/h"}, nil
+		},
+	}
+	uploads := &mock.UploadAppInterface{
+		GetPendingUploadsFn: func() ([]entity.Upload, error) {
+			return []entity.Upload{
+				{ID: 1, Key: "uploads/x.png", ExpiresAt: time.Now().Add(time.Hour)},
+				{ID: 2, Key: "uploads/y.png", ExpiresAt: time.Now().Add(-2 * time.Hour)},
+			}, nil
+		},
+	}
+	refs := &mock.ObjectRefAppInterface{
+		GetObjectRefsFn: func() ([]entity.ObjectRef, error) {
+			return []entity.ObjectRef{
+				{Key: "images/b.png", Refs: 1, UpdatedAt: time.Now().Add(-48 * time.Hour)},
+				//retained for a product that is being saved
+				{Key: "images/c.png", Refs: 1, UpdatedAt: time.Now().Add(time.Hour)},
+			}, nil
+		},
+		DeleteObjectRefFn: func(key string) error {
+			s.forgotten = append(s.forgotten, key)
+			return nil
+		},
+	}
+	gc := NewGarbageCollector(products, users, uploads, refs, s.store, &fakeQueue{}, grace, legacy)
+	return gc, s
+}
+
+func orphanKeys(report *GCReport) []string {
+	var keys []string
+	for _, obj := range report.Orphans {
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func TestCollect_DryRun(t *testing.T) {
+	gc, s := newGCSetup(t, 0, false)
+
+	report, err := gc.Collect(true)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 10, report.Scanned)
+	//the variant not recorded for a.png yet is kept with its image, an unconfirmed upload past its expiry is not
+	assert.EqualValues(t, []string{"images/b.png", "images/b/150.jpg", "uploads/y.png"}, orphanKeys(report))
+	assert.EqualValues(t, 21, report.Bytes)
+	assert.EqualValues(t, 0, report.Deleted)
+
+	all, _ := s.store.List("")
+	assert.EqualValues(t, 12, len(all))
+	assert.Empty(t, s.forgotten)
+}
+
+func TestCollect_Deletes(t *testing.T) {
+	gc, s := newGCSetup(t, 0, false)
+
+	report, err := gc.Collect(false)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, report.Deleted)
+	_, err = s.store.Stat("images/b.png")
+	assert.EqualValues(t, storage.ErrNotFound, err)
+	_, err = s.store.Stat("images/a/480.jpg")
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"images/b.png"}, s.forgotten)
+
+	//there is nothing left to collect
+	report, err = gc.Collect(false)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Orphans)
+}
+
+func TestCollect_GracePeriod(t *testing.T) {
+	gc, _ := newGCSetup(t, time.Hour, false)
+
+	//every object was just stored
+	report, err := gc.Collect(true)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Orphans)
+}
+
+func TestCollect_Legacy(t *testing.T) {
+	gc, s := newGCSetup(t, 0, true)
+
+	report, err := gc.Collect(false)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 11, report.Scanned)
+	assert.EqualValues(t, []string{"images/b.png", "images/b/150.jpg", "uploads/y.png", "0b8f0b3c-legacy.png"}, orphanKeys(report))
+	//what other apps keep in the bucket is never touched
+	_, err = s.store.Stat("backups/db.sql")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,354 @@
+package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/tus"
+	"DDD/interfaces/fileupload"
+	"DDD/interfaces/middleware"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//tusVersion is the only version of the protocol spoken, every request but OPTIONS has to announce it
+const tusVersion = "1.0.0"
+
+//tusExtensions are the extensions of the protocol Options announces
+const tusExtensions = "creation,expiration,termination"
+
+//Tus receives product images in chunks, following the tus 1.0 protocol with its creation, expiration and termination extensions:
+//an upload cut by a bad connection resumes where it stopped instead of starting over.
+//Once the last chunk is in, the file goes through UploadFile like any other image and becomes the image of the product named in its metadata.
+//The files are held to the product_image policy of the routes, its MaxBytes is announced as Tus-Max-Size.
+type Tus struct {
+	store    tus.Store
+	products *Product
+	//ttl is how long an upload may take from its creation
+	ttl time.Duration
+	//basePath is where the uploads are mounted, the Location of a new upload is basePath followed by its ID
+	basePath string
+}
+
+//Tus constructor
+func NewTus(store tus.Store, products *Product, ttl time.Duration, basePath string) *Tus {
+	return &Tus{
+		store:    store,
+		products: products,
+		ttl:      ttl,
+		basePath: basePath,
+	}
+}
+
+//Create starts an upload. Upload-Length is required, and Upload-Metadata has to name the product with product_id.
+func (t *Tus) Create(c *gin.Context) {
+	principal, ok := t.begin(c)
+	if !ok {
+		return
+	}
+	policy, ok := routePolicy(c, "product_image")
+	if !ok {
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"invalid_upload": "Upload-Length is required, deferred lengths are not supported",
+		})
+		return
+	}
+	if length > policy.MaxBytes {
+		c.JSON(uploadError(fileupload.ErrImageTooLarge))
+		return
+	}
+	metadata, ok := parseMetadata(c.GetHeader("Upload-Metadata"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"invalid_upload": "Upload-Metadata is malformed",
+		})
+		return
+	}
+	productId, err := strconv.ParseUint(metadata["product_id"], 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"invalid_upload": "the product_id metadata is required",
+		})
+		return
+	}
+	//the product is checked now rather than after the whole file was sent
+	if _, err := t.products.productApp.GetProduct(principal.Membership.OrganizationID, productId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"no_product": err.Error(),
+		})
+		return
+	}
+	upload := &tus.Upload{
+		Length:         length,
+		Metadata:       metadata,
+		UserID:         principal.User.ID,
+		OrganizationID: principal.Membership.OrganizationID,
+		ExpiresAt:      time.Now().Add(t.ttl),
+	}
+	if err := t.store.Create(upload); err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header("Location", t.basePath+upload.ID)
+	c.Header("Tus-Max-Size", strconv.FormatInt(policy.MaxBytes, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+//Options answers the discovery request of tus clients: the version, the extensions and the largest upload of the route.
+//It needs neither the Tus-Resumable header nor a token.
+func (t *Tus) Options(c *gin.Context) {
+	policy, ok := routePolicy(c, "product_image")
+	if !ok {
+		return
+	}
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(policy.MaxBytes, 10))
+	c.Status(http.StatusNoContent)
+}
+
+//Head tells the client where to resume from
+func (t *Tus) Head(c *gin.Context) {
+	principal, ok := t.begin(c)
+	if !ok {
+		return
+	}
+	upload, ok := t.upload(c, principal)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+//Patch appends a chunk at Upload-Offset, which has to be where the upload stands. The last chunk attaches the image to the product.
+func (t *Tus) Patch(c *gin.Context) {
+	principal, ok := t.begin(c)
+	if !ok {
+		return
+	}
+	policy, ok := routePolicy(c, "product_image")
+	if !ok {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"invalid_upload": "chunks are sent as application/offset+octet-stream",
+		})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"invalid_upload": "Upload-Offset is required",
+		})
+		return
+	}
+	//two requests writing the same upload would interleave their bytes
+	unlock, err := t.store.Lock(c.Param("upload_id"))
+	if err == tus.ErrLocked {
+		c.JSON(http.StatusLocked, gin.H{
+			"upload_locked": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer unlock()
+	upload, ok := t.upload(c, principal)
+	if !ok {
+		return
+	}
+	if offset != upload.Offset || upload.Complete() {
+		c.JSON(http.StatusConflict, gin.H{
+			"upload_offset": "the offset does not match the upload, ask for it with HEAD",
+		})
+		return
+	}
+	if c.Request.ContentLength > upload.Length-upload.Offset {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"upload_too_large": "the chunk goes past Upload-Length",
+		})
+		return
+	}
+	upload.Offset, err = t.store.Append(upload.ID, c.Request.Body)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if err == tus.ErrTooLong {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"upload_too_large": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		//the connection was most likely cut, what was received is kept for the next chunk
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if upload.Complete() {
+		if status, errs := t.complete(principal, upload, policy); errs != nil {
+			c.JSON(status, errs)
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+//Terminate gives up on an upload and deletes what was received
+func (t *Tus) Terminate(c *gin.Context) {
+	principal, ok := t.begin(c)
+	if !ok {
+		return
+	}
+	unlock, err := t.store.Lock(c.Param("upload_id"))
+	if err == tus.ErrLocked {
+		c.JSON(http.StatusLocked, gin.H{
+			"upload_locked": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer unlock()
+	upload, ok := t.upload(c, principal)
+	if !ok {
+		return
+	}
+	if err := t.store.Delete(upload.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+//Expire is the handler of entity.JobTusExpire jobs, it deletes the uploads that were abandoned
+func (t *Tus) Expire(job *entity.Job) error {
+	expired, err := t.store.Expired(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, id := range expired {
+		//an upload still being written is left for the next run
+		unlock, err := t.store.Lock(id)
+		if err != nil {
+			continue
+		}
+		err = t.store.Delete(id)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("tus: %d expired uploads deleted", len(expired))
+	}
+	return nil
+}
+
+//complete hands the received file to UploadFile within policy, then to the product. Either way the upload is over and its data deleted:
+//sending the same bytes again would not make a refused image any better.
+func (t *Tus) complete(principal *middleware.Principal, upload *tus.Upload, policy *entity.UploadPolicy) (int, map[string]string) {
+	defer func() {
+		if err := t.store.Delete(upload.ID); err != nil {
+			log.Printf("tus: cannot delete the upload %s: %v", upload.ID, err)
+		}
+	}()
+	r, err := t.store.Open(upload.ID)
+	if err != nil {
+		return http.StatusInternalServerError, map[string]string{"upload_err": err.Error()}
+	}
+	key, err := t.products.fileUpload.UploadFile(r, upload.Metadata["filename"], policy)
+	r.Close()
+	if err != nil {
+		return uploadError(err)
+	}
+	productId, _ := strconv.ParseUint(upload.Metadata["product_id"], 10, 64)
+	if _, status, errs := t.products.AttachImage(principal, productId, key); errs != nil {
+		return status, errs
+	}
+	return 0, nil
+}
+
+//begin checks the protocol version and returns the principal, answering the request when either is missing
+func (t *Tus) begin(c *gin.Context) (*middleware.Principal, bool) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"tus_version": "only tus " + tusVersion + " is supported",
+		})
+		return nil, false
+	}
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+	return principal, true
+}
+
+//upload loads the upload named in the URL. Uploads of other users are not found, expired ones are deleted and gone.
+func (t *Tus) upload(c *gin.Context, principal *middleware.Principal) (*tus.Upload, bool) {
+	upload, err := t.store.Get(c.Param("upload_id"))
+	if err == nil && (upload.UserID != principal.User.ID || upload.OrganizationID != principal.Membership.OrganizationID) {
+		err = tus.ErrNotFound
+	}
+	if err == tus.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"no_upload": err.Error(),
+		})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if upload.ExpiresAt.Before(time.Now()) {
+		_ = t.store.Delete(upload.ID)
+		c.JSON(http.StatusGone, gin.H{
+			"upload_expired": "the upload expired, it has to start over",
+		})
+		return nil, false
+	}
+	return upload, true
+}
+
+//parseMetadata reads Upload-Metadata: comma separated pairs of a key and its value in base64, the value may be left out
+func parseMetadata(header string) (map[string]string, bool) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, true
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, false
+		}
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, false
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, true
+}
@@ -0,0 +1,163 @@
+package interfaces
+
+import (
+	"DDD/infrastructure/storage"
+	"DDD/infrastructure/tus"
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func (s *uploadSetup) tusRequest(t *testing.T, method, path string, userId uint64, header map[string]string, body []byte) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	s.authorize(t, req, userId)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+//createTus starts an upload of length bytes for the product and returns its location
+func (s *uploadSetup) createTus(t *testing.T, productId string, length int) *httptest.ResponseRecorder {
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("photo.png")) + ",product_id " + base64.StdEncoding.EncodeToString([]byte(productId))
+	return s.tusRequest(t, http.MethodPost, "/tus", 1, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": metadata,
+	}, nil)
+}
+
+func (s *uploadSetup) patchTus(t *testing.T, location string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	return s.tusRequest(t, http.MethodPatch, location, 1, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	}, chunk)
+}
+
+func TestTus_Resume(t *testing.T) {
+	s := newUploadSetup(t)
+	assert.EqualValues(t, http.StatusCreated, s.sendImage(t, smallPNG()).Code)
+	previous := s.products[0].ProductImage
+
+	data := encodePNG(image.NewRGBA(image.Rect(0, 0, 128, 96)))
+	rr := s.createTus(t, "1", len(data))
+	assert.EqualValues(t, http.StatusCreated, rr.Code)
+	assert.EqualValues(t, "1.0.0", rr.Header().Get("Tus-Resumable"))
+	assert.NotEmpty(t, rr.Header().Get("Upload-Expires"))
+	location := rr.Header().Get("Location")
+
+	half := len(data) / 2
+	rr = s.patchTus(t, location, 0, data[:half])
+	assert.EqualValues(t, http.StatusNoContent, rr.Code)
+	assert.EqualValues(t, strconv.Itoa(half), rr.Header().Get("Upload-Offset"))
+
+	//the connection dropped, the client asks where to resume from
+	rr = s.tusRequest(t, http.MethodHead, location, 1, nil, nil)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, strconv.Itoa(half), rr.Header().Get("Upload-Offset"))
+	assert.EqualValues(t, strconv.Itoa(len(data)), rr.Header().Get("Upload-Length"))
+	assert.EqualValues(t, "no-store", rr.Header().Get("Cache-Control"))
+
+	//a chunk sent again is refused, the data is not written twice
+	assert.EqualValues(t, http.StatusConflict, s.patchTus(t, location, 0, data[:half]).Code)
+
+	rr = s.patchTus(t, location, half, data[half:])
+	assert.EqualValues(t, http.StatusNoContent, rr.Code)
+	assert.EqualValues(t, strconv.Itoa(len(data)), rr.Header().Get("Upload-Offset"))
+
+	//the file went through UploadFile and replaced the image of the product
+	key := s.products[0].ProductImage
+	assert.NotEqual(t, previous, key)
+	_, err := s.store.Stat(key)
+	assert.Nil(t, err)
+	//the previous image had no other reference
+	_, err = s.store.Stat(previous)
+	assert.EqualValues(t, storage.ErrNotFound, err)
+	//the upload is over
+	assert.EqualValues(t, http.StatusNotFound, s.tusRequest(t, http.MethodHead, location, 1, nil, nil).Code)
+}
+
+func TestTus_Options(t *testing.T) {
+	s := newUploadSetup(t)
+
+	//the discovery of tus clients needs no token
+	req, _ := http.NewRequest(http.MethodOptions, "/tus", nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	assert.EqualValues(t, http.StatusNoContent, rr.Code)
+	assert.EqualValues(t, "1.0.0", rr.Header().Get("Tus-Version"))
+	assert.EqualValues(t, "creation,expiration,termination", rr.Header().Get("Tus-Extension"))
+	assert.EqualValues(t, "1024", rr.Header().Get("Tus-Max-Size"))
+
+	//a CORS preflight is still answered by the middleware
+	req, _ = http.NewRequest(http.MethodOptions, "/tus", nil)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	assert.EqualValues(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Header().Get("Tus-Version"))
+	assert.NotEmpty(t, rr.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestTus_Refused(t *testing.T) {
+	s := newUploadSetup(t)
+	assert.EqualValues(t, http.StatusCreated, s.sendImage(t, smallPNG()).Code)
+	previous := s.products[0].ProductImage
+
+	req, _ := http.NewRequest(http.MethodPost, "/tus", nil)
+	req.Header.Set("Upload-Length", "10")
+	s.authorize(t, req, 1)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	assert.EqualValues(t, http.StatusPreconditionFailed, rr.Code)
+	assert.EqualValues(t, "1.0.0", rr.Header().Get("Tus-Version"))
+
+	assert.EqualValues(t, http.StatusNotFound, s.createTus(t, "9", 10).Code)
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, s.createTus(t, "1", 600000).Code)
+	assert.EqualValues(t, http.StatusBadRequest, s.tusRequest(t, http.MethodPost, "/tus", 1, map[string]string{"Upload-Length": "10"}, nil).Code)
+
+	location := s.createTus(t, "1", 10).Header().Get("Location")
+	//the uploads of other users are not found
+	assert.EqualValues(t, http.StatusNotFound, s.tusRequest(t, http.MethodHead, location, 2, nil, nil).Code)
+	rr = s.tusRequest(t, http.MethodPatch, location, 1, map[string]string{"Upload-Offset": "0"}, []byte("plain text"))
+	assert.EqualValues(t, http.StatusUnsupportedMediaType, rr.Code)
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, s.patchTus(t, location, 0, []byte("plain text and more")).Code)
+
+	//the complete file is refused like any upload, and the upload is over
+	rr = s.patchTus(t, location, 0, []byte("plain text"))
+	assert.EqualValues(t, http.StatusUnsupportedMediaType, rr.Code)
+	assert.EqualValues(t, previous, s.products[0].ProductImage)
+	assert.EqualValues(t, http.StatusNotFound, s.tusRequest(t, http.MethodHead, location, 1, nil, nil).Code)
+
+	location = s.createTus(t, "1", 10).Header().Get("Location")
+	assert.EqualValues(t, http.StatusNoContent, s.tusRequest(t, http.MethodDelete, location, 1, nil, nil).Code)
+	assert.EqualValues(t, http.StatusNotFound, s.tusRequest(t, http.MethodHead, location, 1, nil, nil).Code)
+}
+
+func TestTus_Expire(t *testing.T) {
+	s := newUploadSetup(t)
+	assert.EqualValues(t, http.StatusCreated, s.sendImage(t, smallPNG()).Code)
+	location := s.createTus(t, "1", 10).Header().Get("Location")
+	id := location[len("/tus/"):]
+
+	upload, _ := s.tus.Get(id)
+	expired := &tus.Upload{Length: 10, UserID: upload.UserID, OrganizationID: upload.OrganizationID, ExpiresAt: time.Now().Add(-time.Minute)}
+	_ = s.tus.Create(expired)
+	assert.EqualValues(t, http.StatusGone, s.tusRequest(t, http.MethodHead, "/tus/"+expired.ID, 1, nil, nil).Code)
+
+	_ = s.tus.Create(expired)
+	resumable := NewTus(s.tus, nil, time.Hour, "/tus/")
+	assert.Nil(t, resumable.Expire(nil))
+	_, err := s.tus.Get(expired.ID)
+	assert.EqualValues(t, tus.ErrNotFound, err)
+	_, err = s.tus.Get(id)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,186 @@
+package interfaces
+
+import (
+	"DDD/application"
+	"DDD/domain/entity"
+	"DDD/infrastructure/storage"
+	"DDD/interfaces/fileupload"
+	"DDD/interfaces/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/twinj/uuid"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//uploadIntentTTL is how long a presigned URL accepts the file
+const uploadIntentTTL = 15 * time.Minute
+
+//Uploads lets clients put product images straight into the storage. The product handler confirms them with the upload_id field.
+//The files are held to the product_image policy of the routes.
+type Uploads struct {
+	uploads   application.UploadAppInterface
+	store     storage.Storage
+	presigner storage.Presigner
+}
+
+//Uploads constructor
+func NewUploads(uploads application.UploadAppInterface, store storage.Storage, presigner storage.Presigner) *Uploads {
+	return &Uploads{
+		uploads:   uploads,
+		store:     store,
+		presigner: presigner,
+	}
+}
+
+type uploadIntent struct {
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+//CreateIntent records an upload and returns the URL the client puts the file to
+func (up *Uploads) CreateIntent(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok || principal.Membership == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var intent uploadIntent
+	if err := c.ShouldBindJSON(&intent); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_json": "invalid json",
+		})
+		return
+	}
+	policy, ok := routePolicy(c, "product_image")
+	if !ok {
+		return
+	}
+	ext := policy.Extension(intent.ContentType)
+	if ext == "" {
+		c.JSON(uploadError(fileupload.ErrFileType))
+		return
+	}
+	if intent.Size <= 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_size": "the size of the file is required",
+		})
+		return
+	}
+	if intent.Size > policy.MaxBytes {
+		c.JSON(uploadError(fileupload.ErrImageTooLarge))
+		return
+	}
+	upload, saveErr := up.uploads.SaveUpload(&entity.Upload{
+		//the file is quarantined until the confirm step scanned it
+		Key:            entity.QuarantinePrefix + uuid.NewV4().String() + ext,
+		UserID:         principal.User.ID,
+		OrganizationID: principal.Membership.OrganizationID,
+		ContentType:    intent.ContentType,
+		//the confirm step holds the client to the size it announced
+		MaxBytes:  intent.Size,
+		ExpiresAt: time.Now().Add(uploadIntentTTL),
+	})
+	if saveErr != nil {
+		c.JSON(http.StatusInternalServerError, saveErr)
+		return
+	}
+	putURL, err := up.presigner.PresignPut(upload.Key, upload.ContentType, uploadIntentTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	//uploads signed by the API are received by the API
+	if strings.HasPrefix(putURL, "/") {
+		putURL = requestBaseURL(c) + putURL
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"upload_id":  upload.ID,
+		"key":        upload.Key,
+		"method":     http.MethodPut,
+		"url":        putURL,
+		"headers":    gin.H{"Content-Type": upload.ContentType},
+		"expires_at": upload.ExpiresAt,
+	})
+}
+
+//Receive stores the file of a URL signed by storage.SignedUploads, for the backends that cannot presign uploads themselves.
+//The object gets the content type of the intent, which the URL carries signed, like S3 a request with another Content-Type is refused.
+//The URL only takes files while its upload is pending and no larger than the intent announced, it cannot be replayed once confirmed.
+func (up *Uploads) Receive(c *gin.Context) {
+	signed, ok := up.presigner.(*storage.SignedUploads)
+	if !ok {
+		c.JSON(http.StatusNotFound, "not found")
+		return
+	}
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	contentType := c.Query("content_type")
+	if !signed.Verify(key, contentType, c.Query("expires"), c.Query("signature")) || c.GetHeader("Content-Type") != contentType {
+		c.JSON(http.StatusForbidden, "invalid or expired upload url")
+		return
+	}
+	upload, err := up.uploads.GetUploadByKey(key)
+	if err != nil || upload.Status != entity.UploadPending {
+		c.JSON(http.StatusForbidden, "invalid or expired upload url")
+		return
+	}
+	if c.Request.ContentLength > upload.MaxBytes {
+		c.JSON(uploadError(fileupload.ErrImageTooLarge))
+		return
+	}
+	//the length may be unknown, the body is cut off past the limit either way
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, upload.MaxBytes)
+	if err := up.store.Put(key, body, c.Request.ContentLength, contentType); err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			c.JSON(uploadError(fileupload.ErrImageTooLarge))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+//routePolicy returns the policy the route declared for the files of field with middleware.UploadPolicies.
+//A route without one is a mistake of the server, the request is answered here.
+func routePolicy(c *gin.Context, field string) (*entity.UploadPolicy, bool) {
+	policy, ok := middleware.CurrentUploadPolicies(c)[field]
+	if !ok {
+		log.Printf("upload: %s declares no upload policy for %s", c.FullPath(), field)
+		c.JSON(http.StatusInternalServerError, "no upload policy")
+	}
+	return policy, ok
+}
+
+//uploadError gives the status and error key of a file a policy, ReadForm, UploadFile or UploadAvatar refused.
+//Whichever way the file came, too large is 413 and a type not allowed is 415.
+func uploadError(err error) (int, map[string]string) {
+	switch err {
+	case fileupload.ErrFileType, fileupload.ErrUploadType:
+		return http.StatusUnsupportedMediaType, map[string]string{"upload_type": err.Error()}
+	case fileupload.ErrImageTooLarge, fileupload.ErrUploadTooLarge:
+		return http.StatusRequestEntityTooLarge, map[string]string{"upload_too_large": err.Error()}
+	case fileupload.ErrTooManyFiles:
+		return http.StatusUnprocessableEntity, map[string]string{"too_many_files": err.Error()}
+	case fileupload.ErrInvalidForm:
+		return http.StatusUnprocessableEntity, map[string]string{"invalid_form": err.Error()}
+	case fileupload.ErrFieldsTooLarge:
+		return http.StatusRequestEntityTooLarge, map[string]string{"invalid_form": err.Error()}
+	case fileupload.ErrImageInvalid:
+		return http.StatusUnprocessableEntity, map[string]string{"invalid_image": err.Error()}
+	case fileupload.ErrImagePixels:
+		return http.StatusUnprocessableEntity, map[string]string{"image_too_many_pixels": err.Error()}
+	case fileupload.ErrImageTooSmall:
+		return http.StatusUnprocessableEntity, map[string]string{"image_too_small": err.Error()}
+	case fileupload.ErrImageTooWide:
+		return http.StatusUnprocessableEntity, map[string]string{"image_too_wide": err.Error()}
+	case fileupload.ErrInfected:
+		return http.StatusUnprocessableEntity, map[string]string{"upload_infected": err.Error()}
+	case fileupload.ErrScanFailed:
+		return http.StatusServiceUnavailable, map[string]string{"scan_failed": err.Error()}
+	default:
+		//this error can be any we defined in the UploadFile method
+		return http.StatusUnprocessableEntity, map[string]string{"upload_err": err.Error()}
+	}
+}
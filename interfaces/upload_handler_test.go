@@ -0,0 +1,516 @@
+package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/infrastructure/scanner"
+	"DDD/infrastructure/storage"
+	"DDD/infrastructure/tus"
+	"DDD/interfaces/fileupload"
+	"DDD/interfaces/middleware"
+	"DDD/utils/mock"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type uploadSetup struct {
+	router   *gin.Engine
+	tk       *auth.Token
+	rd       *auth.MemoryAuth
+	store    *storage.Memory
+	uploads  map[uint64]*entity.Upload
+	products []*entity.Product
+	tus      *tus.FileStore
+	//policy is declared by every route that takes product images
+	policy entity.UploadPolicy
+	//confirmFails is the number of the next confirms failing like the database was down
+	confirmFails int
+}
+
+func newUploadSetup(t *testing.T) *uploadSetup {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	s := &uploadSetup{
+		tk:      auth.NewToken(keys),
+		rd:      auth.NewMemoryAuth(),
+		store:   storage.NewMemory("https://cdn.example.com/"),
+		uploads: map[uint64]*entity.Upload{},
+	}
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			return &entity.User{ID: id, FirstName: "Sammi", LastName: "Dev"}, nil
+		},
+	}
+	orgApp := &mock.OrganizationAppInterface{
+		GetMembershipFn: func(orgId, userId uint64) (*entity.Membership, error) {
+			return &entity.Membership{OrganizationID: orgId, UserID: userId, Role: entity.OrgRoleEditor}, nil
+		},
+	}
+	uploadApp := &mock.UploadAppInterface{
+		SaveUploadFn: func(upload *entity.Upload) (*entity.Upload, map[string]string) {
+			upload.ID = uint64(len(s.uploads) + 1)
+			upload.Status = entity.UploadPending
+			s.uploads[upload.ID] = upload
+			return upload, nil
+		},
+		GetUploadFn: func(id uint64) (*entity.Upload, error) {
+			upload, ok := s.uploads[id]
+			if !ok {
+				return nil, errors.New("upload not found")
+			}
+			u := *upload
+			return &u, nil
+		},
+		GetUploadByKeyFn: func(key string) (*entity.Upload, error) {
+			for _, upload := range s.uploads {
+				if upload.Key == key {
+					u := *upload
+					return &u, nil
+				}
+			}
+			return nil, errors.New("upload not found")
+		},
+		RejectUploadFn: func(id uint64) map[string]string {
+			s.uploads[id].Status = entity.UploadInfected
+			return nil
+		},
+		ConfirmUploadFn: func(id uint64) map[string]string {
+			if s.confirmFails > 0 {
+				s.confirmFails--
+				return map[string]string{"db_error": "database error"}
+			}
+			if s.uploads[id].Status != entity.UploadPending {
+				return map[string]string{"no_upload": "upload not found or already used"}
+			}
+			s.uploads[id].Status = entity.UploadConfirmed
+			return nil
+		},
+	}
+	productApp := &mock.ProductAppInterface{
+		SaveProductFn: func(product *entity.Product) (*entity.Product, map[string]string) {
+			product.ID = uint64(len(s.products) + 1)
+			s.products = append(s.products, product)
+			return product, nil
+		},
+		GetProductFn: func(orgId, productId uint64) (*entity.Product, error) {
+			if productId == 0 || productId > uint64(len(s.products)) || s.products[productId-1] == nil {
+				return nil, errors.New("product not found")
+			}
+			p := *s.products[productId-1]
+			return &p, nil
+		},
+		UpdateProductFn: func(product *entity.Product) (*entity.Product, map[string]string) {
+			s.products[product.ID-1] = product
+			return product, nil
+		},
+		DeleteProductFn: func(orgId, productId uint64) error {
+			s.products[productId-1] = nil
+			return nil
+		},
+	}
+	refs, _ := mock.CountedRefs()
+	//infectedPNG stands for a file the scanners refuse
+	sum := sha256.Sum256(infectedPNG())
+	denylist := scanner.NewHashDenylist([]string{hex.EncodeToString(sum[:])})
+	fd := fileupload.NewFileUpload(s.store, refs, &fileupload.DefaultVariants, denylist)
+	uploads := NewUploads(uploadApp, s.store, storage.NewSignedUploads([]byte("secret"), "/storage/"))
+	foods := NewProduct(productApp, userApp, uploadApp, fd, &fakeQueue{})
+	s.tus, err = tus.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	resumable := NewTus(s.tus, foods, time.Hour, "/tus/")
+	authenticated := middleware.AuthMiddleware(s.tk, s.rd, userApp, nil)
+	editor := middleware.RequireOrganization(orgApp, entity.OrgRoleOwner, entity.OrgRoleEditor)
+	//every route takes the same small product images
+	s.policy = entity.ProductImagePolicy
+	s.policy.MaxBytes = 1024
+	productUploads := middleware.UploadPolicies(fileupload.Form{"product_image": &s.policy})
+	s.router = gin.New()
+	s.router.Use(middleware.CORSMiddleware("*"))
+	s.router.POST("/food/uploads", authenticated, editor, productUploads, uploads.CreateIntent)
+	s.router.PUT("/storage/*key", uploads.Receive)
+	s.router.POST("/food", authenticated, editor, productUploads, foods.SaveProduct)
+	s.router.PUT("/food/:product_id", authenticated, editor, productUploads, foods.UpdateProduct)
+	s.router.DELETE("/food/:product_id", authenticated, editor, foods.DeleteProduct)
+	s.router.OPTIONS("/tus", productUploads, resumable.Options)
+	s.router.POST("/tus", authenticated, editor, productUploads, resumable.Create)
+	s.router.HEAD("/tus/:upload_id", authenticated, editor, resumable.Head)
+	s.router.PATCH("/tus/:upload_id", authenticated, editor, productUploads, resumable.Patch)
+	s.router.DELETE("/tus/:upload_id", authenticated, editor, resumable.Terminate)
+	return s
+}
+
+func (s *uploadSetup) authorize(t *testing.T, req *http.Request, userId uint64) {
+	td, err := s.tk.CreateToken(userId, 4, nil)
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	_ = s.rd.CreateAuth(userId, td)
+	req.Header.Set("Authorization", "Bearer "+td.AccessToken)
+}
+
+func (s *uploadSetup) intent(t *testing.T, contentType string, size int64) (*httptest.ResponseRecorder, map[string]interface{}) {
+	payload, _ := json.Marshal(map[string]interface{}{"content_type": contentType, "size": size})
+	req, _ := http.NewRequest(http.MethodPost, "/food/uploads", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	s.authorize(t, req, 1)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	var body map[string]interface{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &body)
+	return rr, body
+}
+
+func (s *uploadSetup) put(putURL string, data []byte, contentType string) *httptest.ResponseRecorder {
+	u, _ := url.Parse(putURL)
+	req, _ := http.NewRequest(http.MethodPut, u.RequestURI(), bytes.NewReader(data))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func (s *uploadSetup) saveProduct(t *testing.T, userId uint64, uploadId string) *httptest.ResponseRecorder {
+	return s.sendProduct(t, http.MethodPost, "/food", userId, uploadId)
+}
+
+func (s *uploadSetup) sendProduct(t *testing.T, method, path string, userId uint64, uploadId string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("title", "Pancakes")
+	_ = writer.WriteField("description", "with syrup")
+	_ = writer.WriteField("upload_id", uploadId)
+	_ = writer.Close()
+	req, _ := http.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	s.authorize(t, req, userId)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+//upload puts data through a direct upload and returns the id and key of the upload
+func (s *uploadSetup) upload(t *testing.T, data []byte) (string, string) {
+	_, intent := s.intent(t, "image/png", int64(len(data)))
+	s.put(intent["url"].(string), data, "image/png")
+	return strconv.Itoa(int(intent["upload_id"].(float64))), intent["key"].(string)
+}
+
+func smallPNG() []byte {
+	return encodePNG(image.NewRGBA(image.Rect(0, 0, 64, 64)))
+}
+
+func infectedPNG() []byte {
+	return encodePNG(image.NewRGBA(image.Rect(0, 0, 64, 65)))
+}
+
+func encodePNG(img image.Image) []byte {
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestDirectUpload_Success(t *testing.T) {
+	s := newUploadSetup(t)
+	data := smallPNG()
+
+	rr, intent := s.intent(t, "image/png", int64(len(data)))
+	assert.EqualValues(t, http.StatusCreated, rr.Code)
+	key := intent["key"].(string)
+	//the file waits in quarantine until it is confirmed
+	assert.True(t, strings.HasPrefix(key, entity.QuarantinePrefix))
+	assert.True(t, strings.HasSuffix(key, ".png"))
+	assert.EqualValues(t, http.MethodPut, intent["method"])
+	//the API signs and receives the uploads of the memory backend
+	putURL := intent["url"].(string)
+	assert.True(t, strings.HasPrefix(putURL, "http://"))
+
+	assert.EqualValues(t, http.StatusOK, s.put(putURL, data, "image/png").Code)
+
+	uploadId := strconv.Itoa(int(intent["upload_id"].(float64)))
+	rr = s.saveProduct(t, 1, uploadId)
+	assert.EqualValues(t, http.StatusCreated, rr.Code)
+	//the image was stored again under its content key
+	assert.True(t, strings.HasPrefix(s.products[0].ProductImage, entity.ImagePrefix))
+	assert.EqualValues(t, entity.UploadConfirmed, s.uploads[1].Status)
+	_, err := s.store.Stat(key)
+	assert.EqualValues(t, storage.ErrNotFound, err)
+
+	//an upload is attached once
+	rr = s.saveProduct(t, 1, uploadId)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.EqualValues(t, 1, len(s.products))
+}
+
+func TestDirectUpload_ConfirmRetried(t *testing.T) {
+	s := newUploadSetup(t)
+	uploadId, key := s.upload(t, smallPNG())
+
+	s.confirmFails = 1
+	rr := s.saveProduct(t, 1, uploadId)
+	assert.EqualValues(t, http.StatusInternalServerError, rr.Code)
+	assert.EqualValues(t, entity.UploadPending, s.uploads[1].Status)
+	//the uploaded file is still there, and the clean copy was given back
+	_, err := s.store.Stat(key)
+	assert.Nil(t, err)
+	images, _ := s.store.List(entity.ImagePrefix)
+	assert.Empty(t, images)
+
+	rr = s.saveProduct(t, 1, uploadId)
+	assert.EqualValues(t, http.StatusCreated, rr.Code)
+	assert.EqualValues(t, entity.UploadConfirmed, s.uploads[1].Status)
+	_, err = s.store.Stat(s.products[0].ProductImage)
+	assert.Nil(t, err)
+	_, err = s.store.Stat(key)
+	assert.EqualValues(t, storage.ErrNotFound, err)
+}
+
+func TestDirectUpload_Refused(t *testing.T) {
+	s := newUploadSetup(t)
+
+	rr, _ := s.intent(t, "application/pdf", 100)
+	assert.EqualValues(t, http.StatusUnsupportedMediaType, rr.Code)
+	rr, _ = s.intent(t, "image/png", 2048)
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, rr.Code)
+	rr, _ = s.intent(t, "image/png", 0)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Empty(t, s.uploads)
+}
+
+func TestDirectUpload_InvalidSignature(t *testing.T) {
+	s := newUploadSetup(t)
+	data := smallPNG()
+
+	_, intent := s.intent(t, "image/png", int64(len(data)))
+	putURL := intent["url"].(string)
+	//the signature covers the key
+	forged := strings.Replace(putURL, entity.QuarantinePrefix, entity.QuarantinePrefix+"x", 1)
+	assert.EqualValues(t, http.StatusForbidden, s.put(forged, data, "image/png").Code)
+
+	//the signature covers the content type too
+	forged = strings.Replace(putURL, "image%2Fpng", "text%2Fhtml", 1)
+	assert.EqualValues(t, http.StatusForbidden, s.put(forged, data, "text/html").Code)
+	assert.EqualValues(t, http.StatusForbidden, s.put(putURL, data, "text/html").Code)
+
+	//the URL does not take more than the intent announced, even within the policy
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, s.put(putURL, append(data, 0), "image/png").Code)
+	assert.EqualValues(t, http.StatusOK, s.put(putURL, data, "image/png").Code)
+
+	//once the upload is confirmed the URL takes nothing more
+	uploadId := strconv.Itoa(int(intent["upload_id"].(float64)))
+	assert.EqualValues(t, http.StatusCreated, s.saveProduct(t, 1, uploadId).Code)
+	assert.EqualValues(t, http.StatusForbidden, s.put(putURL, data, "image/png").Code)
+	quarantined, _ := s.store.List(entity.QuarantinePrefix)
+	assert.Empty(t, quarantined)
+}
+
+func TestDirectUpload_Expired(t *testing.T) {
+	s := newUploadSetup(t)
+
+	uploadId, key := s.upload(t, smallPNG())
+	s.uploads[1].ExpiresAt = time.Now().Add(-time.Second)
+	rr := s.saveProduct(t, 1, uploadId)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "upload_expired")
+	assert.Empty(t, s.products)
+	assert.EqualValues(t, entity.UploadPending, s.uploads[1].Status)
+	//the file is left to the garbage collector
+	_, err := s.store.Stat(key)
+	assert.Nil(t, err)
+}
+
+func TestDirectUpload_ConfirmChecksTheFile(t *testing.T) {
+	s := newUploadSetup(t)
+	data := smallPNG()
+
+	//a bigger file than announced, which a presigned S3 URL takes
+	_, intent := s.intent(t, "image/png", 10)
+	_ = s.store.Put(intent["key"].(string), bytes.NewReader(data), int64(len(data)), "image/png")
+	rr := s.saveProduct(t, 1, "1")
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, rr.Code)
+	_, err := s.store.Stat(intent["key"].(string))
+	assert.EqualValues(t, storage.ErrNotFound, err)
+
+	//not the announced type
+	_, intent = s.intent(t, "image/jpeg", int64(len(data)))
+	s.put(intent["url"].(string), data, "image/jpeg")
+	rr = s.saveProduct(t, 1, "2")
+	assert.EqualValues(t, http.StatusUnsupportedMediaType, rr.Code)
+
+	//never uploaded
+	s.intent(t, "image/png", int64(len(data)))
+	rr = s.saveProduct(t, 1, "3")
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+
+	//the upload of another user
+	_, intent = s.intent(t, "image/png", int64(len(data)))
+	s.put(intent["url"].(string), data, "image/png")
+	rr = s.saveProduct(t, 2, "4")
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Empty(t, s.products)
+}
+
+func TestDirectUpload_Infected(t *testing.T) {
+	s := newUploadSetup(t)
+	data := infectedPNG()
+
+	uploadId, key := s.upload(t, data)
+	rr := s.saveProduct(t, 1, uploadId)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "upload_infected")
+	assert.Empty(t, s.products)
+	assert.EqualValues(t, entity.UploadInfected, s.uploads[1].Status)
+	_, err := s.store.Stat(key)
+	assert.EqualValues(t, storage.ErrNotFound, err)
+
+	//the same picture streamed through the form is refused too
+	rr = s.sendImage(t, data)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "upload_infected")
+	objects, _ := s.store.List("")
+	assert.Empty(t, objects)
+}
+
+func (s *uploadSetup) sendImage(t *testing.T, data []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("product_image", "photo.png")
+	_, _ = part.Write(data)
+	//the fields may come after the file
+	_ = writer.WriteField("title", "Pancakes")
+	_ = writer.WriteField("description", "with syrup")
+	_ = writer.Close()
+	req, _ := http.NewRequest(http.MethodPost, "/food", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	s.authorize(t, req, 1)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSaveProduct_StreamedImage(t *testing.T) {
+	imageURL := entity.ImageURL
+	entity.ImageURL = func(key string) string { return "https://cdn.example.com/" + key }
+	defer func() { entity.ImageURL = imageURL }()
+	s := newUploadSetup(t)
+
+	rr := s.sendImage(t, smallPNG())
+	assert.EqualValues(t, http.StatusCreated, rr.Code)
+	assert.EqualValues(t, "Pancakes", s.products[0].Title)
+	key := s.products[0].ProductImage
+	assert.True(t, strings.HasPrefix(key, entity.ImagePrefix))
+	_, err := s.store.Stat(key)
+	assert.Nil(t, err)
+	//the product keeps the key, the URL is resolved in the response
+	var saved map[string]interface{}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &saved))
+	assert.EqualValues(t, "https://cdn.example.com/"+key, saved["product_image"])
+	assert.EqualValues(t, key, saved["product_image_key"])
+
+	//the upload stops at the limit and leaves nothing behind
+	rr = s.sendImage(t, append(smallPNG(), make([]byte, 600000)...))
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.EqualValues(t, 1, len(s.products))
+	objects, _ := s.store.List("")
+	assert.EqualValues(t, 1, len(objects))
+}
+
+func TestSaveProduct_RefusedImage(t *testing.T) {
+	s := newUploadSetup(t)
+
+	cases := []struct {
+		data   []byte
+		status int
+		key    string
+	}{
+		{[]byte("plain text"), http.StatusUnsupportedMediaType, "upload_type"},
+		//a PNG signature followed by anything else
+		{append(smallPNG()[:16], make([]byte, 64)...), http.StatusUnprocessableEntity, "invalid_image"},
+		{encodePNG(image.NewRGBA(image.Rect(0, 0, 16, 16))), http.StatusUnprocessableEntity, "image_too_small"},
+	}
+	for _, tc := range cases {
+		rr := s.sendImage(t, tc.data)
+		assert.EqualValues(t, tc.status, rr.Code)
+		var body map[string]string
+		_ = json.Unmarshal(rr.Body.Bytes(), &body)
+		assert.Contains(t, body, tc.key)
+	}
+	assert.Empty(t, s.products)
+	objects, _ := s.store.List("")
+	assert.Empty(t, objects)
+}
+
+//errorKey is the key of the error a refused upload was answered with
+func errorKey(rr *httptest.ResponseRecorder) string {
+	var body map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &body)
+	for key := range body {
+		return key
+	}
+	return ""
+}
+
+func TestUploadPolicy_SameErrors(t *testing.T) {
+	s := newUploadSetup(t)
+	large := append(smallPNG(), make([]byte, s.policy.MaxBytes)...)
+
+	//too large is 413 however the file comes
+	tooLarge := []*httptest.ResponseRecorder{s.sendImage(t, large), s.createTus(t, "1", len(large))}
+	rr, _ := s.intent(t, "image/png", int64(len(large)))
+	tooLarge = append(tooLarge, rr)
+	_, intent := s.intent(t, "image/png", 100)
+	tooLarge = append(tooLarge, s.put(intent["url"].(string), large, "image/png"))
+	for _, rr := range tooLarge {
+		assert.EqualValues(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.EqualValues(t, "upload_too_large", errorKey(rr))
+	}
+
+	//a type the policy does not list is 415
+	rr, _ = s.intent(t, "image/svg+xml", 100)
+	assert.EqualValues(t, http.StatusUnsupportedMediaType, rr.Code)
+	assert.EqualValues(t, "upload_type", errorKey(rr))
+	s.policy.Types = map[string][]string{"image/jpeg": {".jpg"}}
+	rr = s.sendImage(t, smallPNG())
+	assert.EqualValues(t, http.StatusUnsupportedMediaType, rr.Code)
+	assert.EqualValues(t, "upload_type", errorKey(rr))
+	s.policy.Types = entity.ImageTypes
+
+	//the field takes one file, the one stored already is released
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, data := range [][]byte{smallPNG(), encodePNG(image.NewRGBA(image.Rect(0, 0, 80, 80)))} {
+		part, _ := writer.CreateFormFile("product_image", "photo.png")
+		_, _ = part.Write(data)
+	}
+	_ = writer.Close()
+	req, _ := http.NewRequest(http.MethodPost, "/food", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	s.authorize(t, req, 1)
+	rr = httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.EqualValues(t, "too_many_files", errorKey(rr))
+
+	assert.Empty(t, s.products)
+	objects, _ := s.store.List("")
+	assert.Empty(t, objects)
+}
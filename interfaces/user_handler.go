@@ -4,24 +4,32 @@ import (
 	"DDD/application"
 	"DDD/domain/entity"
 	"DDD/infrastructure/auth"
+	"DDD/infrastructure/security"
+	"DDD/interfaces/fileupload"
+	"DDD/interfaces/middleware"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
 	"strconv"
 )
 
 //Users struct defines the dependencies that will be used
 type Users struct {
-	us application.UserAppInterface
-	rd auth.AuthInterface
-	tk auth.TokenInterface
+	us     application.UserAppInterface
+	rd     auth.AuthInterface
+	tk     auth.TokenInterface
+	policy     *security.PasswordPolicy
+	fileUpload fileupload.UploadFileInterface
 }
 
 //Users constructor
-func NewUsers(us application.UserAppInterface, rd auth.AuthInterface, tk auth.TokenInterface) *Users {
+func NewUsers(us application.UserAppInterface, rd auth.AuthInterface, tk auth.TokenInterface, policy *security.PasswordPolicy, fd fileupload.UploadFileInterface) *Users {
 	return &Users{
-		us: us,
-		rd: rd,
-		tk: tk,
+		us:         us,
+		rd:         rd,
+		tk:         tk,
+		policy:     policy,
+		fileUpload: fd,
 	}
 }
 
@@ -33,8 +41,15 @@ func (s *Users) SaveUser(c *gin.Context) {
 		})
 		return
 	}
+	//roles are granted by an admin, never chosen at signup
+	user.Role = entity.RoleUser
 	//validate the request:
 	validateErr := user.Validate("")
+	if user.Password != "" {
+		for key, msg := range s.policy.Check(user.Password, &user) {
+			validateErr[key] = msg
+		}
+	}
 	if len(validateErr) > 0 {
 		c.JSON(http.StatusUnprocessableEntity, validateErr)
 		return
@@ -73,3 +88,99 @@ func (s *Users) GetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user.PublicUser())
 }
 
+type passwordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+//ChangePassword sets a new password for the logged in user, who has to prove they know the current one
+func (s *Users) ChangePassword(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var change passwordChange
+	if err := c.ShouldBindJSON(&change); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_json": "invalid json",
+		})
+		return
+	}
+	if change.CurrentPassword == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"current_password_required": "current password is required",
+		})
+		return
+	}
+	_, userErr := s.us.GetUserByEmailAndPassword(&entity.User{Email: principal.User.Email, Password: change.CurrentPassword})
+	if userErr != nil {
+		c.JSON(http.StatusUnprocessableEntity, userErr)
+		return
+	}
+	if policyErr := s.policy.Check(change.NewPassword, principal.User); len(policyErr) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, policyErr)
+		return
+	}
+	if updateErr := s.us.UpdatePassword(principal.User.ID, change.NewPassword); updateErr != nil {
+		c.JSON(http.StatusInternalServerError, updateErr)
+		return
+	}
+	c.JSON(http.StatusOK, "password changed")
+}
+
+//SaveAvatar replaces the picture of a user. Users change their own, admins anyone's.
+func (s *Users) SaveAvatar(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userId, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if userId != principal.User.ID && !principal.Access.HasScope(entity.ScopeUsersAdmin) {
+		c.JSON(http.StatusForbidden, "you can only change your own avatar")
+		return
+	}
+	user, err := s.us.GetUser(userId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, "user not found")
+		return
+	}
+	//the picture is decoded while it streams in, the body is never held in memory
+	keys, err := fileupload.ReadForm(c.Request, middleware.CurrentUploadPolicies(c), s.fileUpload.UploadAvatar)
+	if err != nil {
+		for _, key := range keys["avatar"] {
+			if err := s.fileUpload.DeleteAvatar(key); err != nil {
+				log.Printf("avatar: cannot delete unused avatar %s: %v", key, err)
+			}
+		}
+		c.JSON(uploadError(err))
+		return
+	}
+	if len(keys["avatar"]) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"invalid_file": "a valid file is required",
+		})
+		return
+	}
+	key := keys["avatar"][0]
+	if updateErr := s.us.UpdateAvatar(user.ID, key); updateErr != nil {
+		if err := s.fileUpload.DeleteAvatar(key); err != nil {
+			log.Printf("avatar: cannot delete unused avatar %s: %v", key, err)
+		}
+		c.JSON(http.StatusInternalServerError, updateErr)
+		return
+	}
+	//the previous picture is no longer referenced by anything
+	if user.Avatar != "" {
+		if err := s.fileUpload.DeleteAvatar(user.Avatar); err != nil {
+			log.Printf("avatar: cannot delete previous avatar %s of user %d: %v", user.Avatar, user.ID, err)
+		}
+	}
+	user.Avatar = key
+	c.JSON(http.StatusOK, user.PublicUser())
+}
@@ -1 +1,111 @@
 package interfaces
+
+import (
+	"DDD/domain/entity"
+	"DDD/infrastructure/auth"
+	"DDD/infrastructure/security"
+	"DDD/interfaces/fileupload"
+	"DDD/interfaces/middleware"
+	"DDD/utils/mock"
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type avatarSetup struct {
+	router  *gin.Engine
+	tk      *auth.Token
+	rd      *auth.MemoryAuth
+	users   map[uint64]*entity.User
+	deleted []string
+}
+
+func newAvatarSetup(t *testing.T) *avatarSetup {
+	gin.SetMode(gin.TestMode)
+	keys, err := auth.GenerateKeySet()
+	if err != nil {
+		t.Fatalf("want non error, got %#v", err)
+	}
+	s := &avatarSetup{
+		tk: auth.NewToken(keys),
+		rd: auth.NewMemoryAuth(),
+		users: map[uint64]*entity.User{
+			1: {ID: 1, FirstName: "Sammi", LastName: "Dev", Avatar: "avatars/old"},
+			2: {ID: 2, FirstName: "Izzah", LastName: "Dev"},
+		},
+	}
+	userApp := &mock.UserAppInterface{
+		GetUserFn: func(id uint64) (*entity.User, error) {
+			u := *s.users[id]
+			return &u, nil
+		},
+		UpdateAvatarFn: func(id uint64, key string) map[string]string {
+			s.users[id].Avatar = key
+			return nil
+		},
+	}
+	upload := &mock.UploadFileInterface{
+		UploadAvatarFn: func(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error) {
+			//the file is held to the policy of the route
+			assert.EqualValues(t, &entity.AvatarPolicy, policy)
+			return "avatars/new", nil
+		},
+		DeleteAvatarFn: func(key string) error {
+			s.deleted = append(s.deleted, key)
+			return nil
+		},
+	}
+	policy := security.DefaultPasswordPolicy
+	users := NewUsers(userApp, s.rd, s.tk, &policy, upload)
+	s.router = gin.New()
+	s.router.POST("/users/:user_id/avatar", middleware.AuthMiddleware(s.tk, s.rd, userApp, nil), middleware.UploadPolicies(fileupload.Form{"avatar": &entity.AvatarPolicy}), users.SaveAvatar)
+	return s
+}
+
+func (s *avatarSetup) upload(t *testing.T, asUser uint64, path string) *httptest.ResponseRecorder {
+	td, _ := s.tk.CreateToken(asUser, 0, nil)
+	_ = s.rd.CreateAuth(asUser, td)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("avatar", "me.png")
+	_, _ = part.Write([]byte("picture"))
+	_ = writer.Close()
+	req, _ := http.NewRequest(http.MethodPost, path, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+td.AccessToken)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSaveAvatar_Success(t *testing.T) {
+	entity.AvatarURL = func(key string) string { return "https://cdn.example.com/" + key }
+	defer func() { entity.AvatarURL = func(key string) string { return key } }()
+	s := newAvatarSetup(t)
+
+	rr := s.upload(t, 1, "/users/1/avatar")
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+
+	var public entity.PublicUser
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &public))
+	assert.EqualValues(t, "https://cdn.example.com/avatars/new/128.jpg", public.Avatar["128"])
+	assert.EqualValues(t, len(entity.AvatarSizes), len(public.Avatar))
+	assert.EqualValues(t, "avatars/new", s.users[1].Avatar)
+	//the previous picture is gone
+	assert.EqualValues(t, []string{"avatars/old"}, s.deleted)
+}
+
+func TestSaveAvatar_OtherUser(t *testing.T) {
+	s := newAvatarSetup(t)
+
+	rr := s.upload(t, 2, "/users/1/avatar")
+	assert.EqualValues(t, http.StatusForbidden, rr.Code)
+	assert.EqualValues(t, "avatars/old", s.users[1].Avatar)
+	assert.Empty(t, s.deleted)
+}
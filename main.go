@@ -1,15 +1,29 @@
 package DDD
 
 import (
+	"DDD/domain/entity"
 	"DDD/infrastructure/auth"
+	"DDD/infrastructure/jobs"
+	"DDD/infrastructure/mailer"
 	"DDD/infrastructure/persistence"
+	"DDD/infrastructure/scanner"
+	"DDD/infrastructure/security"
+	"DDD/infrastructure/storage"
+	"DDD/infrastructure/tus"
 	"DDD/interfaces"
 	"DDD/interfaces/fileupload"
 	"DDD/interfaces/middleware"
+	"crypto/rand"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func init() {
@@ -34,6 +48,27 @@ func main() {
 	redis_password := os.Getenv("REDIS_PASSWORD")
 
 
+	//password hashing: new hashes use argon2id, older bcrypt hashes keep verifying and are upgraded on login
+	argon2Params := security.DefaultArgon2Params
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY"), 10, 32); err == nil {
+		argon2Params.Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_ITERATIONS"), 10, 32); err == nil {
+		argon2Params.Iterations = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8); err == nil {
+		argon2Params.Parallelism = uint8(v)
+	}
+	bcryptCost := bcrypt.DefaultCost
+	if v, err := strconv.Atoi(os.Getenv("BCRYPT_COST")); err == nil {
+		bcryptCost = v
+	}
+	if os.Getenv("PASSWORD_HASHER") == "bcrypt" {
+		security.Configure(security.NewPasswords(security.NewBcryptHasher(bcryptCost), security.NewArgon2idHasher(argon2Params)))
+	} else {
+		security.Configure(security.NewPasswords(security.NewArgon2idHasher(argon2Params), security.NewBcryptHasher(bcryptCost)))
+	}
+
 	services, err := persistence.NewRepositories(dbdriver, user, password, port, host, dbname)
 	if err != nil {
 		panic(err)
@@ -41,37 +76,342 @@ func main() {
 	defer services.Close()
 	services.Automigrate()
 
-	redisService, err := auth.NewRedisDB(redis_host, redis_port, redis_password)
+	//token metadata store: redis (default), memory for a single node or development, sql to reuse the database
+	var authStore auth.AuthInterface
+	switch os.Getenv("AUTH_STORE") {
+	case "memory":
+		authStore = auth.NewMemoryAuth()
+	case "sql":
+		sqlAuth := auth.NewSQLAuth(services.DB())
+		if err := sqlAuth.Automigrate(); err != nil {
+			log.Fatal(err)
+		}
+		authStore = sqlAuth
+	default:
+		redisService, err := auth.NewRedisDB(redis_host, redis_port, redis_password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authStore = redisService.Auth
+	}
+
+	//signing keys: the first file is the active key, the others are only kept to verify tokens issued before a rotation
+	var keys *auth.KeySet
+	if keyFiles := os.Getenv("JWT_KEY_FILES"); keyFiles != "" {
+		keys, err = auth.LoadKeySet(strings.Split(keyFiles, ",")...)
+	} else {
+		log.Println("JWT_KEY_FILES is not set, signing tokens with an ephemeral key")
+		keys, err = auth.GenerateKeySet()
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	tk := auth.NewToken()
-	fd := fileupload.NewFileUpload()
+	tk := auth.NewToken(keys)
+
+	//uploaded files: s3 (default) for DigitalOcean Spaces and other S3 compatible services, filesystem or memory for development and tests
+	spacesURL := os.Getenv("DO_SPACES_URL")
+	storageURL := os.Getenv("STORAGE_URL")
+	storageDir := os.Getenv("STORAGE_DIR")
+	if storageDir == "" {
+		storageDir = "./uploads"
+	}
+	var store storage.Storage
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "memory":
+		store = storage.NewMemory(storageURL)
+	case "filesystem":
+		if storageURL == "" {
+			storageURL = "/uploads/"
+		}
+		store, err = storage.NewFileSystem(storageDir, storageURL)
+	default:
+		bucket := os.Getenv("STORAGE_BUCKET")
+		if bucket == "" {
+			bucket = "chodapi"
+		}
+		if storageURL == "" {
+			storageURL = spacesURL
+		}
+		//files not scanned yet, or still coming in, are not readable from the bucket
+		store, err = storage.NewS3(os.Getenv("DO_SPACES_ENDPOINT"), os.Getenv("DO_SPACES_KEY"), os.Getenv("DO_SPACES_SECRET"), bucket, storageURL, os.Getenv("STORAGE_INSECURE") != "true", entity.QuarantinePrefix, entity.IncomingPrefix)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	//product images used to be saved with DO_SPACES_URL in front, sometimes twice, the rows only keep their keys now
+	if err := services.NormalizeProductImages(spacesURL, store.URL("")); err != nil {
+		log.Fatal(err)
+	}
+	//resized copies of product images, with WebP ones when cwebp is installed
+	variants := fileupload.DefaultVariants
+	if widths := os.Getenv("IMAGE_VARIANT_WIDTHS"); widths != "" {
+		variants.Widths = nil
+		for _, w := range strings.Split(widths, ",") {
+			if v, err := strconv.Atoi(strings.TrimSpace(w)); err == nil && v > 0 {
+				variants.Widths = append(variants.Widths, v)
+			}
+		}
+	}
+	if webp, err := fileupload.NewCWebPEncoder(os.Getenv("CWEBP_PATH"), 80); err == nil {
+		variants.Encoders = append(variants.Encoders, webp)
+	} else {
+		log.Println("cwebp is not available, product images get no WebP variants")
+	}
+	//uploaded pictures are decoded in full, so their size in pixels is bounded as well as their size in bytes
+	imageLimits := entity.DefaultImageLimits
+	if v, err := strconv.ParseInt(os.Getenv("IMAGE_MAX_PIXELS"), 10, 64); err == nil && v > 0 {
+		imageLimits.MaxPixels = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("IMAGE_MIN_SIZE")); err == nil && v > 0 {
+		imageLimits.MinWidth = v
+		imageLimits.MinHeight = v
+	}
+	variants.MaxPixels = imageLimits.MaxPixels
+	//the upload policies the routes declare, PRODUCT_IMAGE_MAX_BYTES and AVATAR_MAX_BYTES change how large the files may be
+	productImages := entity.ProductImagePolicy
+	productImages.Image = imageLimits
+	if v, err := strconv.ParseInt(os.Getenv("PRODUCT_IMAGE_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		productImages.MaxBytes = v
+	}
+	avatars := entity.AvatarPolicy
+	avatars.Image = imageLimits
+	if v, err := strconv.ParseInt(os.Getenv("AVATAR_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		avatars.MaxBytes = v
+	}
+	//uploads are scanned before they are stored: against the SHA-256 listed in UPLOAD_DENYLIST, then by the clamd at CLAMD_ADDR
+	var scanners []scanner.Scanner
+	if path := os.Getenv("UPLOAD_DENYLIST"); path != "" {
+		denylist, err := scanner.LoadHashDenylist(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scanners = append(scanners, denylist)
+	}
+	if addr := os.Getenv("CLAMD_ADDR"); addr != "" {
+		clamdTimeout := 30 * time.Second
+		if v, err := time.ParseDuration(os.Getenv("CLAMD_TIMEOUT")); err == nil && v > 0 {
+			clamdTimeout = v
+		}
+		scanners = append(scanners, scanner.NewClamAV(addr, clamdTimeout))
+	}
+	fd := fileupload.NewFileUpload(store, services.ObjectRef, &variants, scanners...)
+	//direct uploads: S3 presigns them itself, the other backends get URLs signed by the API and received on /storage/
+	presigner, ok := store.(storage.Presigner)
+	if !ok {
+		signingKey := []byte(os.Getenv("UPLOAD_SIGNING_KEY"))
+		if len(signingKey) == 0 {
+			log.Println("UPLOAD_SIGNING_KEY is not set, signing upload urls with an ephemeral key")
+			signingKey = make([]byte, 32)
+			if _, err := rand.Read(signingKey); err != nil {
+				log.Fatal(err)
+			}
+		}
+		uploadURL := os.Getenv("STORAGE_UPLOAD_URL")
+		if uploadURL == "" {
+			uploadURL = "/storage/"
+		}
+		presigner = storage.NewSignedUploads(signingKey, uploadURL)
+	}
+	//avatars are public objects in the same storage as the product images
+	entity.AvatarURL = store.URL
+	entity.ImageURL = store.URL
+	//the images can be served by the API itself from MEDIA_URL, the public address of /media/, with links signed by MEDIA_SIGNING_KEY when the bucket is private
+	var mediaSigner *storage.SignedURLs
+	if signingKey := os.Getenv("MEDIA_SIGNING_KEY"); signingKey != "" {
+		ttl := time.Hour
+		if v, err := time.ParseDuration(os.Getenv("MEDIA_URL_TTL")); err == nil && v > 0 {
+			ttl = v
+		}
+		mediaSigner = storage.NewSignedURLs([]byte(signingKey), ttl)
+	}
+	mediaCacheBytes := int64(64 << 20)
+	if v, err := strconv.ParseInt(os.Getenv("MEDIA_CACHE_BYTES"), 10, 64); err == nil && v > 0 {
+		mediaCacheBytes = v
+	}
+	media := interfaces.NewMedia(store, &imageLimits, variants.Widths, mediaCacheBytes, mediaSigner, os.Getenv("MEDIA_URL"))
+	if os.Getenv("MEDIA_URL") != "" {
+		entity.AvatarURL = media.URL
+		entity.ImageURL = media.URL
+	}
+
+	//browser sessions keep the tokens in HttpOnly cookies, bearer tokens keep working either way
+	var sessions *middleware.SessionCookies
+	if os.Getenv("SESSION_COOKIES") == "true" {
+		sessions = &middleware.SessionCookies{
+			Domain:   os.Getenv("SESSION_COOKIE_DOMAIN"),
+			Secure:   os.Getenv("SESSION_COOKIE_INSECURE") != "true", //only for local development over plain http
+			SameSite: http.SameSiteLaxMode,
+		}
+		if os.Getenv("SESSION_COOKIE_SAMESITE") == "strict" {
+			sessions.SameSite = http.SameSiteStrictMode
+		}
+	}
 
-	users := interfaces.NewUsers(services.User, redisService.Auth, tk)
-	foods := interfaces.NewProduct(services.Product, services.User, fd, redisService.Auth, tk)
-	authenticate := interfaces.NewAuthenticate(services.User, redisService.Auth, tk)
+	//outgoing mail: smtp, or log to print messages during development
+	var mail mailer.Mailer = mailer.NewLogMailer()
+	if os.Getenv("MAILER") == "smtp" {
+		mail = mailer.NewSMTPMailer(os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+	}
+
+	//password policy for signup, password change and reset
+	passwordPolicy := security.DefaultPasswordPolicy
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		passwordPolicy.MinLength = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_REPEATED")); err == nil {
+		passwordPolicy.MaxRepeated = v
+	}
+	passwordPolicy.RequireSymbol = os.Getenv("PASSWORD_REQUIRE_SYMBOL") == "true"
+	if breachDir := os.Getenv("PASSWORD_BREACH_DIR"); breachDir != "" {
+		prefixFiles, err := security.NewPrefixFiles(breachDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		passwordPolicy.Breaches = security.NewBreachChecker(prefixFiles)
+	}
+
+	users := interfaces.NewUsers(services.User, authStore, tk, &passwordPolicy, fd)
+	authenticate := interfaces.NewAuthenticate(services.User, services.Organization, authStore, tk, sessions)
+	jwks := interfaces.NewKeys(keys)
+	//the login links are never built from the Host of the request, it can be spoofed
+	magicLinkURL := os.Getenv("MAGIC_LINK_URL")
+	if magicLinkURL == "" {
+		log.Fatal("MAGIC_LINK_URL is required")
+	}
+	magicLink := interfaces.NewMagicLink(services.User, services.Organization, authStore, tk, sessions, mail, magicLinkURL)
+	passwordResetURL := os.Getenv("PASSWORD_RESET_URL")
+	if passwordResetURL == "" {
+		log.Fatal("PASSWORD_RESET_URL is required")
+	}
+	passwordReset := interfaces.NewPasswordReset(services.User, authStore, tk, mail, &passwordPolicy, passwordResetURL)
+	admin := interfaces.NewAdmin(services.User, services.Organization, services.Audit, authStore, tk)
+	//background jobs: erasures and image variants
+	jobRunner := jobs.NewRunner(services.Job, 2)
+	//ERASURE_PRODUCTS=delete removes the products of erased users instead of handing them over to another member
+	privacy := interfaces.NewPrivacy(services.User, services.Product, services.Organization, services.Audit, authStore, fd, jobRunner, os.Getenv("ERASURE_PRODUCTS"))
+	jobRunner.Handle(entity.JobUserErasure, privacy.Erase)
+	foods := interfaces.NewProduct(services.Product, services.User, services.Upload, fd, jobRunner)
+	jobRunner.Handle(entity.JobImageVariants, foods.GenerateVariants)
+	//orphaned files are collected once they are older than STORAGE_GC_GRACE, every STORAGE_GC_INTERVAL when it is set
+	gcGrace := 24 * time.Hour
+	if v, err := time.ParseDuration(os.Getenv("STORAGE_GC_GRACE")); err == nil {
+		gcGrace = v
+	}
+	//the collector only looks at the prefixes of the API, STORAGE_GC_LEGACY=true adds the product images stored at the root of the bucket
+	gc := interfaces.NewGarbageCollector(services.Product, services.User, services.Upload, services.ObjectRef, store, jobRunner, gcGrace, os.Getenv("STORAGE_GC_LEGACY") == "true")
+	jobRunner.Handle(entity.JobStorageGC, gc.Run)
+	if v, err := time.ParseDuration(os.Getenv("STORAGE_GC_INTERVAL")); err == nil && v > 0 {
+		jobRunner.Schedule(entity.JobStorageGC, v)
+	}
+	//resumable uploads keep their chunks on the disk of the instance until they are complete, abandoned ones go after TUS_TTL.
+	//The uploads are not shared: /tus needs a single instance of the API, and a directory that outlives restarts, not the temp dir.
+	tusDir := os.Getenv("TUS_DIR")
+	if tusDir == "" {
+		tusDir = "tus"
+	}
+	tusStore, err := tus.NewFileStore(tusDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tusTTL := 24 * time.Hour
+	if v, err := time.ParseDuration(os.Getenv("TUS_TTL")); err == nil && v > 0 {
+		tusTTL = v
+	}
+	resumable := interfaces.NewTus(tusStore, foods, tusTTL, "/tus/")
+	jobRunner.Handle(entity.JobTusExpire, resumable.Expire)
+	jobRunner.Schedule(entity.JobTusExpire, time.Hour)
+	jobRunner.Start()
+	defer jobRunner.Stop()
+	uploads := interfaces.NewUploads(services.Upload, store, presigner)
+	organizations := interfaces.NewOrganizations(services.Organization, services.User, authStore, tk, sessions, mail, os.Getenv("INVITATION_URL"))
+
+	//services allowed to introspect and revoke tokens, as OAUTH_CLIENTS=id:secret,id:secret
+	oauthClients := map[string]string{}
+	for _, client := range strings.Split(os.Getenv("OAUTH_CLIENTS"), ",") {
+		if parts := strings.SplitN(client, ":", 2); len(parts) == 2 {
+			oauthClients[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	oauth := interfaces.NewOAuth(authStore, tk)
 
 	r := gin.Default()
-	r.Use(middleware.CORSMiddleware()) //For CORS
+	//CORS_ORIGINS lists the frontends, comma separated, whose cookie sessions work cross-origin. Any other origin only gets bearer tokens through.
+	corsOrigins := []string{"*"}
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		corsOrigins = strings.Split(v, ",")
+	}
+	r.Use(middleware.CORSMiddleware(corsOrigins...)) //For CORS
+	r.Use(middleware.AuditImpersonation(services.Audit))
+	//the filesystem backend is served by the API itself, all but the files not scanned yet or still coming in
+	if os.Getenv("STORAGE_BACKEND") == "filesystem" {
+		for _, prefix := range []string{entity.ImagePrefix, entity.AvatarPrefix, entity.UploadPrefix} {
+			r.Static("/uploads/"+strings.TrimSuffix(prefix, "/"), filepath.Join(storageDir, prefix))
+		}
+	}
+
+	authenticated := middleware.AuthMiddleware(tk, authStore, services.User, sessions)
+	productUploads := middleware.UploadPolicies(fileupload.Form{"product_image": &productImages})
+	avatarUploads := middleware.UploadPolicies(fileupload.Form{"avatar": &avatars})
 
 	//user routes
 	r.POST("/users", users.SaveUser)
 	r.GET("/users", users.GetUsers)
 	r.GET("/users/:user_id", users.GetUser)
+	r.PUT("/users/me/password", authenticated, middleware.DenyImpersonation(), users.ChangePassword)
+	r.POST("/users/:user_id/avatar", authenticated, avatarUploads, users.SaveAvatar)
+	r.GET("/users/me/export", authenticated, middleware.DenyImpersonation(), privacy.Export)
+	r.POST("/users/me/erasure", authenticated, middleware.DenyImpersonation(), privacy.RequestErasure)
+	r.GET("/jobs/:job_id", authenticated, privacy.GetJob)
 
-	//post routes
-	r.POST("/food", middleware.AuthMiddleware(), middleware.MaxSizeAllowed(8192000), foods.SaveProduct)
-	r.PUT("/food/:food_id", middleware.AuthMiddleware(), middleware.MaxSizeAllowed(8192000), foods.UpdateProduct)
-	r.GET("/food/:food_id", foods.GetProductAndCreator)
-	r.DELETE("/food/:food_id", middleware.AuthMiddleware(), foods.DeleteProduct)
-	r.GET("/food", foods.GetAllProduct)
+	//organization routes
+	member := middleware.RequireOrganization(services.Organization)
+	owner := middleware.RequireOrganization(services.Organization, entity.OrgRoleOwner)
+	editor := middleware.RequireOrganization(services.Organization, entity.OrgRoleOwner, entity.OrgRoleEditor)
+	r.POST("/organizations", authenticated, organizations.SaveOrganization)
+	r.GET("/organizations", authenticated, organizations.GetOrganizations)
+	r.POST("/organizations/:org_id/switch", authenticated, middleware.DenyImpersonation(), member, organizations.SwitchOrganization)
+	r.GET("/organizations/:org_id/members", authenticated, member, organizations.GetMembers)
+	r.PUT("/organizations/:org_id/members/:user_id", authenticated, owner, organizations.UpdateMember)
+	r.DELETE("/organizations/:org_id/members/:user_id", authenticated, member, organizations.RemoveMember)
+	r.POST("/organizations/:org_id/invitations", authenticated, owner, organizations.Invite)
+	r.POST("/invitations/accept", authenticated, middleware.DenyImpersonation(), organizations.AcceptInvitation)
+
+	//post routes, all scoped to the active organization of the token
+	r.POST("/food", authenticated, middleware.RequireScopes(entity.ScopeProductsWrite), editor, productUploads, foods.SaveProduct)
+	r.PUT("/food/:product_id", authenticated, middleware.RequireScopes(entity.ScopeProductsWrite), editor, productUploads, foods.UpdateProduct)
+	r.GET("/food/:product_id", authenticated, member, foods.GetProductAndCreator)
+	r.DELETE("/food/:product_id", authenticated, middleware.RequireScopes(entity.ScopeProductsWrite), editor, foods.DeleteProduct)
+	r.GET("/food", authenticated, member, foods.GetAllProduct)
+	r.POST("/food/uploads", authenticated, middleware.RequireScopes(entity.ScopeProductsWrite), editor, productUploads, uploads.CreateIntent)
+	r.PUT("/storage/*key", uploads.Receive)
+	r.GET("/media/*key", media.Serve)
+	r.HEAD("/media/*key", media.Serve)
+	r.OPTIONS("/tus", productUploads, resumable.Options)
+	r.POST("/tus", authenticated, middleware.RequireScopes(entity.ScopeProductsWrite), editor, productUploads, resumable.Create)
+	r.HEAD("/tus/:upload_id", authenticated, middleware.RequireScopes(entity.ScopeProductsWrite), editor, resumable.Head)
+	r.PATCH("/tus/:upload_id", authenticated, middleware.RequireScopes(entity.ScopeProductsWrite), editor, productUploads, resumable.Patch)
+	r.DELETE("/tus/:upload_id", authenticated, middleware.RequireScopes(entity.ScopeProductsWrite), editor, resumable.Terminate)
 
 	//authentication routes
 	r.POST("/login", authenticate.Login)
-	r.POST("/logout", authenticate.Logout)
+	r.POST("/logout", authenticated, authenticate.Logout)
 	r.POST("/refresh", authenticate.Refresh)
+	r.POST("/login/magic", magicLink.Send)
+	r.GET("/login/magic/callback", magicLink.Callback)
+	r.POST("/password/forgot", passwordReset.Forgot)
+	r.POST("/password/reset", passwordReset.Reset)
+	r.GET("/.well-known/jwks.json", jwks.JWKS)
+
+	//token introspection and revocation for other services
+	r.POST("/oauth/introspect", middleware.ClientCredentials(oauthClients), oauth.Introspect)
+	r.POST("/oauth/revoke", middleware.ClientCredentials(oauthClients), oauth.Revoke)
+
+	//admin routes
+	r.POST("/admin/impersonate/:user_id", authenticated, middleware.DenyImpersonation(), middleware.RequireScopes(entity.ScopeUsersAdmin), admin.Impersonate)
+	r.POST("/admin/users/:user_id/erasure", authenticated, middleware.DenyImpersonation(), middleware.RequireScopes(entity.ScopeUsersAdmin), privacy.EraseUser)
+	r.GET("/admin/storage/orphans", authenticated, middleware.RequireScopes(entity.ScopeUsersAdmin), gc.Report)
+	r.POST("/admin/storage/gc", authenticated, middleware.DenyImpersonation(), middleware.RequireScopes(entity.ScopeUsersAdmin), gc.Start)
 
 
 	//Starting the application
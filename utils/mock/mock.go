@@ -1 +1,298 @@
 package mock
+
+import (
+	"DDD/domain/entity"
+	"io"
+)
+
+//UserAppInterface is a mock user app interface
+type UserAppInterface struct {
+	SaveUserFn                  func(*entity.User) (*entity.User, map[string]string)
+	GetUsersFn                  func() ([]entity.User, error)
+	GetUserFn                   func(uint64) (*entity.User, error)
+	GetUserByEmailFn            func(string) (*entity.User, error)
+	GetUserByEmailAndPasswordFn func(*entity.User) (*entity.User, map[string]string)
+	UpdatePasswordFn            func(uint64, string) map[string]string
+	UpdateAvatarFn              func(uint64, string) map[string]string
+	AnonymizeUserFn             func(uint64) map[string]string
+	GetAvatarsFn                func() ([]string, error)
+}
+
+func (u *UserAppInterface) SaveUser(user *entity.User) (*entity.User, map[string]string) {
+	return u.SaveUserFn(user)
+}
+
+func (u *UserAppInterface) GetUsers() ([]entity.User, error) {
+	return u.GetUsersFn()
+}
+
+func (u *UserAppInterface) GetUser(userId uint64) (*entity.User, error) {
+	return u.GetUserFn(userId)
+}
+
+func (u *UserAppInterface) GetUserByEmail(email string) (*entity.User, error) {
+	return u.GetUserByEmailFn(email)
+}
+
+func (u *UserAppInterface) GetUserByEmailAndPassword(user *entity.User) (*entity.User, map[string]string) {
+	return u.GetUserByEmailAndPasswordFn(user)
+}
+
+func (u *UserAppInterface) UpdatePassword(userId uint64, password string) map[string]string {
+	return u.UpdatePasswordFn(userId, password)
+}
+
+func (u *UserAppInterface) UpdateAvatar(userId uint64, key string) map[string]string {
+	return u.UpdateAvatarFn(userId, key)
+}
+
+func (u *UserAppInterface) AnonymizeUser(userId uint64) map[string]string {
+	return u.AnonymizeUserFn(userId)
+}
+
+func (u *UserAppInterface) GetAvatars() ([]string, error) {
+	return u.GetAvatarsFn()
+}
+
+//ProductAppInterface is a mock product app interface
+type ProductAppInterface struct {
+	SaveProductFn       func(*entity.Product) (*entity.Product, map[string]string)
+	GetAllProductFn     func(uint64) ([]entity.Product, error)
+	GetProductFn        func(uint64, uint64) (*entity.Product, error)
+	UpdateProductFn     func(*entity.Product) (*entity.Product, map[string]string)
+	DeleteProductFn     func(uint64, uint64) error
+	GetProductsByUserFn func(uint64) ([]entity.Product, error)
+	ReassignProductsFn  func(uint64, uint64, uint64) error
+	SetImageVariantsFn  func(uint64, string, entity.ImageVariants) map[string]string
+	GetProductImagesFn  func() ([]entity.Product, error)
+}
+
+func (p *ProductAppInterface) SaveProduct(product *entity.Product) (*entity.Product, map[string]string) {
+	return p.SaveProductFn(product)
+}
+
+func (p *ProductAppInterface) GetAllProduct(orgId uint64) ([]entity.Product, error) {
+	return p.GetAllProductFn(orgId)
+}
+
+func (p *ProductAppInterface) GetProduct(orgId, productId uint64) (*entity.Product, error) {
+	return p.GetProductFn(orgId, productId)
+}
+
+func (p *ProductAppInterface) UpdateProduct(product *entity.Product) (*entity.Product, map[string]string) {
+	return p.UpdateProductFn(product)
+}
+
+func (p *ProductAppInterface) DeleteProduct(orgId, productId uint64) error {
+	return p.DeleteProductFn(orgId, productId)
+}
+
+func (p *ProductAppInterface) GetProductsByUser(userId uint64) ([]entity.Product, error) {
+	return p.GetProductsByUserFn(userId)
+}
+
+func (p *ProductAppInterface) ReassignProducts(orgId, fromUserId, toUserId uint64) error {
+	return p.ReassignProductsFn(orgId, fromUserId, toUserId)
+}
+
+func (p *ProductAppInterface) SetImageVariants(productId uint64, image string, variants entity.ImageVariants) map[string]string {
+	return p.SetImageVariantsFn(productId, image, variants)
+}
+
+func (p *ProductAppInterface) GetProductImages() ([]entity.Product, error) {
+	return p.GetProductImagesFn()
+}
+
+//AuditAppInterface is a mock audit app interface
+type AuditAppInterface struct {
+	SaveEventFn       func(*entity.AuditEvent) error
+	GetEventsByUserFn func(uint64) ([]entity.AuditEvent, error)
+}
+
+func (a *AuditAppInterface) SaveEvent(event *entity.AuditEvent) error {
+	return a.SaveEventFn(event)
+}
+
+func (a *AuditAppInterface) GetEventsByUser(userId uint64) ([]entity.AuditEvent, error) {
+	return a.GetEventsByUserFn(userId)
+}
+
+//UploadAppInterface is a mock upload app interface
+type UploadAppInterface struct {
+	SaveUploadFn        func(*entity.Upload) (*entity.Upload, map[string]string)
+	GetUploadFn         func(uint64) (*entity.Upload, error)
+	GetUploadByKeyFn    func(string) (*entity.Upload, error)
+	ConfirmUploadFn     func(uint64) map[string]string
+	RejectUploadFn      func(uint64) map[string]string
+	GetPendingUploadsFn func() ([]entity.Upload, error)
+}
+
+func (u *UploadAppInterface) SaveUpload(upload *entity.Upload) (*entity.Upload, map[string]string) {
+	return u.SaveUploadFn(upload)
+}
+
+func (u *UploadAppInterface) GetUpload(uploadId uint64) (*entity.Upload, error) {
+	return u.GetUploadFn(uploadId)
+}
+
+func (u *UploadAppInterface) GetUploadByKey(key string) (*entity.Upload, error) {
+	return u.GetUploadByKeyFn(key)
+}
+
+func (u *UploadAppInterface) ConfirmUpload(uploadId uint64) map[string]string {
+	return u.ConfirmUploadFn(uploadId)
+}
+
+func (u *UploadAppInterface) RejectUpload(uploadId uint64) map[string]string {
+	return u.RejectUploadFn(uploadId)
+}
+
+func (u *UploadAppInterface) GetPendingUploads() ([]entity.Upload, error) {
+	return u.GetPendingUploadsFn()
+}
+
+//ObjectRefAppInterface is a mock object reference app interface
+type ObjectRefAppInterface struct {
+	RetainObjectFn    func(string) error
+	ReleaseObjectFn   func(string, func() error) (bool, error)
+	GetObjectRefsFn   func() ([]entity.ObjectRef, error)
+	DeleteObjectRefFn func(string) error
+}
+
+func (o *ObjectRefAppInterface) RetainObject(key string) error {
+	return o.RetainObjectFn(key)
+}
+
+func (o *ObjectRefAppInterface) ReleaseObject(key string, remove func() error) (bool, error) {
+	return o.ReleaseObjectFn(key, remove)
+}
+
+func (o *ObjectRefAppInterface) GetObjectRefs() ([]entity.ObjectRef, error) {
+	return o.GetObjectRefsFn()
+}
+
+func (o *ObjectRefAppInterface) DeleteObjectRef(key string) error {
+	return o.DeleteObjectRefFn(key)
+}
+
+//CountedRefs is an ObjectRefAppInterface counting the references in a map, the way the database does
+func CountedRefs() (*ObjectRefAppInterface, map[string]int) {
+	refs := map[string]int{}
+	return &ObjectRefAppInterface{
+		RetainObjectFn: func(key string) error {
+			refs[key]++
+			return nil
+		},
+		ReleaseObjectFn: func(key string, remove func() error) (bool, error) {
+			if refs[key] <= 1 {
+				delete(refs, key)
+				return true, remove()
+			}
+			refs[key]--
+			return false, nil
+		},
+	}, refs
+}
+
+//OrganizationAppInterface is a mock organization app interface
+type OrganizationAppInterface struct {
+	SaveOrganizationFn       func(*entity.Organization, uint64) (*entity.Organization, map[string]string)
+	GetOrganizationFn        func(uint64) (*entity.Organization, error)
+	GetOrganizationsByUserFn func(uint64) ([]entity.Organization, error)
+	GetMembershipFn          func(uint64, uint64) (*entity.Membership, error)
+	GetMembershipsFn         func(uint64) ([]entity.Membership, error)
+	GetMembersFn             func(uint64) ([]entity.Membership, error)
+	UpdateMemberRoleFn       func(uint64, uint64, string) map[string]string
+	RemoveMemberFn           func(uint64, uint64) map[string]string
+	RemoveUserMembershipsFn  func(uint64) map[string]string
+	SaveInvitationFn         func(*entity.Invitation) (*entity.Invitation, map[string]string)
+	GetInvitationByTokenFn   func(string) (*entity.Invitation, error)
+	AcceptInvitationFn       func(string, uint64) (*entity.Membership, map[string]string)
+}
+
+func (o *OrganizationAppInterface) SaveOrganization(org *entity.Organization, ownerId uint64) (*entity.Organization, map[string]string) {
+	return o.SaveOrganizationFn(org, ownerId)
+}
+
+func (o *OrganizationAppInterface) GetOrganization(orgId uint64) (*entity.Organization, error) {
+	return o.GetOrganizationFn(orgId)
+}
+
+func (o *OrganizationAppInterface) GetOrganizationsByUser(userId uint64) ([]entity.Organization, error) {
+	return o.GetOrganizationsByUserFn(userId)
+}
+
+func (o *OrganizationAppInterface) GetMembership(orgId, userId uint64) (*entity.Membership, error) {
+	return o.GetMembershipFn(orgId, userId)
+}
+
+func (o *OrganizationAppInterface) GetMemberships(userId uint64) ([]entity.Membership, error) {
+	return o.GetMembershipsFn(userId)
+}
+
+func (o *OrganizationAppInterface) GetMembers(orgId uint64) ([]entity.Membership, error) {
+	return o.GetMembersFn(orgId)
+}
+
+func (o *OrganizationAppInterface) UpdateMemberRole(orgId, userId uint64, role string) map[string]string {
+	return o.UpdateMemberRoleFn(orgId, userId, role)
+}
+
+func (o *OrganizationAppInterface) RemoveMember(orgId, userId uint64) map[string]string {
+	return o.RemoveMemberFn(orgId, userId)
+}
+
+func (o *OrganizationAppInterface) RemoveUserMemberships(userId uint64) map[string]string {
+	return o.RemoveUserMembershipsFn(userId)
+}
+
+func (o *OrganizationAppInterface) SaveInvitation(invitation *entity.Invitation) (*entity.Invitation, map[string]string) {
+	return o.SaveInvitationFn(invitation)
+}
+
+func (o *OrganizationAppInterface) GetInvitationByToken(token string) (*entity.Invitation, error) {
+	return o.GetInvitationByTokenFn(token)
+}
+
+func (o *OrganizationAppInterface) AcceptInvitation(token string, userId uint64) (*entity.Membership, map[string]string) {
+	return o.AcceptInvitationFn(token, userId)
+}
+
+//UploadFileInterface is a mock file upload interface
+type UploadFileInterface struct {
+	UploadFileFn       func(io.Reader, string, *entity.UploadPolicy) (string, error)
+	UploadAvatarFn     func(io.Reader, string, *entity.UploadPolicy) (string, error)
+	DeleteAvatarFn     func(string) error
+	DeleteFileFn       func(string) error
+	GenerateVariantsFn func(string) (entity.ImageVariants, error)
+	CheckUploadFn      func(string, string, int64, *entity.UploadPolicy) (string, error)
+	DeleteUploadFn     func(string) error
+}
+
+func (up *UploadFileInterface) UploadFile(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error) {
+	return up.UploadFileFn(r, filename, policy)
+}
+
+func (up *UploadFileInterface) UploadAvatar(r io.Reader, filename string, policy *entity.UploadPolicy) (string, error) {
+	return up.UploadAvatarFn(r, filename, policy)
+}
+
+func (up *UploadFileInterface) DeleteAvatar(key string) error {
+	return up.DeleteAvatarFn(key)
+}
+
+func (up *UploadFileInterface) DeleteFile(key string) error {
+	return up.DeleteFileFn(key)
+}
+
+func (up *UploadFileInterface) GenerateVariants(key string) (entity.ImageVariants, error) {
+	return up.GenerateVariantsFn(key)
+}
+
+func (up *UploadFileInterface) CheckUpload(key, contentType string, maxBytes int64, policy *entity.UploadPolicy) (string, error) {
+	return up.CheckUploadFn(key, contentType, maxBytes, policy)
+}
+
+func (up *UploadFileInterface) DeleteUpload(key string) error {
+	return up.DeleteUploadFn(key)
+}